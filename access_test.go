@@ -0,0 +1,148 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestIPFilter_Allowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		client  string
+		allowed bool
+	}{
+		{name: "no lists", deny: []string{"192.0.2.1"}, client: "198.51.100.7", allowed: true},
+		{name: "allowed address", allow: []string{"198.51.100.7"}, client: "198.51.100.7", allowed: true},
+		{name: "address not allowed", allow: []string{"198.51.100.7"}, client: "198.51.100.8", allowed: false},
+		{name: "allowed range", allow: []string{"10.0.0.0/8"}, client: "10.20.30.40", allowed: true},
+		{name: "outside allowed range", allow: []string{"10.0.0.0/8"}, client: "11.0.0.1", allowed: false},
+		{name: "denied address", deny: []string{"192.0.2.1"}, client: "192.0.2.1", allowed: false},
+		{name: "denied range", deny: []string{"192.0.2.0/24"}, client: "192.0.2.200", allowed: false},
+		{name: "deny overrides allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.5"}, client: "10.0.0.5", allowed: false},
+		{name: "unmasked range", allow: []string{"10.1.2.3/16"}, client: "10.1.200.1", allowed: true},
+		{name: "ipv6 range", allow: []string{"2001:db8::/32"}, client: "2001:db8::1", allowed: true},
+		{name: "ipv4-mapped ipv6 client", allow: []string{"10.0.0.0/8"}, client: "::ffff:10.0.0.1", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newIPFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("newIPFilter failed: %v", err)
+			}
+			if got := f.allowed(netip.MustParseAddr(tt.client)); got != tt.allowed {
+				t.Errorf("allowed(%s) = %v, want %v", tt.client, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestIPFilter_TrustedProxy(t *testing.T) {
+	f, err := newIPFilter([]string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("newIPFilter failed: %v", err)
+	}
+	request := func(clientIP string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/internal", nil)
+		req.RemoteAddr = "203.0.113.9:443" // the proxy in front of Caddy
+		vars := map[string]any{}
+		if clientIP != "" {
+			vars[caddyhttp.ClientIPVarKey] = clientIP
+		}
+		return req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, vars))
+	}
+
+	// Caddy sets the client IP from X-Forwarded-For for trusted proxies
+	if err := f.checkClient(request("10.1.2.3")); err != nil {
+		t.Errorf("expected the forwarded client to be allowed, got %v", err)
+	}
+	if err := f.checkClient(request("198.51.100.7")); err == nil {
+		t.Error("expected a forwarded client outside the range to be rejected")
+	}
+
+	// Without a client IP, the remote address is used
+	if err := f.checkClient(request("")); err == nil {
+		t.Error("expected the proxy's own address to be rejected")
+	}
+}
+
+func TestNewIPFilter_Invalid(t *testing.T) {
+	if f, err := newIPFilter(nil, nil); f != nil || err != nil {
+		t.Errorf("expected no filter without lists, got %v, %v", f, err)
+	}
+	if _, err := newIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("expected an invalid CIDR range to be rejected")
+	}
+	if _, err := newIPFilter(nil, []string{"not-an-ip"}); err == nil {
+		t.Error("expected an invalid IP address to be rejected")
+	}
+}
+
+func TestHandler_IPFilter(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return nil, errors.New("container start failed")
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:  []string{"GET"},
+			Path:     "/api/internal",
+			Image:    "test:latest",
+			AllowIPs: []string{"10.0.0.0/8"},
+			DenyIPs:  []string{"10.0.0.5"},
+		},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for _, remoteAddr := range []string{"192.0.2.1:1234", "10.0.0.5:1234", "garbage"} {
+		req := fakeRequest("GET", "/api/internal")
+		req.RemoteAddr = remoteAddr
+		err := handler.ServeHTTP(httptest.NewRecorder(), req, next)
+		herr, ok := err.(caddyhttp.HandlerError)
+		if !ok || herr.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403 for client %s, got %v", remoteAddr, err)
+		}
+	}
+	mockCM.AssertNotCalled(t, "StartContainer")
+
+	req := fakeRequest("GET", "/api/internal")
+	req.RemoteAddr = "10.1.2.3:1234"
+	err = handler.ServeHTTP(httptest.NewRecorder(), req, next)
+	if herr, ok := err.(caddyhttp.HandlerError); ok && herr.StatusCode == http.StatusForbidden {
+		t.Errorf("expected client in the allowed range to pass, got %v", err)
+	}
+	mockCM.AssertCalled(t, "StartContainer", 1)
+
+	h := Handler{Functions: []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/bad", Image: "test:latest", DenyIPs: []string{"10.0.0.0/99"}},
+	}}
+	if err := h.Validate(); err == nil || !strings.Contains(err.Error(), "functions[0].deny_ips") {
+		t.Errorf("expected an invalid deny_ip range to fail validation, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// handlerRegistry tracks provisioned handlers so the admin API can inspect them
+var handlerRegistry = struct {
+	sync.RWMutex
+	handlers map[*Handler]struct{}
+}{handlers: make(map[*Handler]struct{})}
+
+// registerHandler makes a provisioned handler visible to the admin API
+func registerHandler(h *Handler) {
+	handlerRegistry.Lock()
+	handlerRegistry.handlers[h] = struct{}{}
+	handlerRegistry.Unlock()
+}
+
+// unregisterHandler removes a handler from the admin API once it is cleaned up
+func unregisterHandler(h *Handler) {
+	handlerRegistry.Lock()
+	delete(handlerRegistry.handlers, h)
+	handlerRegistry.Unlock()
+}
+
+// registeredHandlers returns a snapshot of the provisioned handlers
+func registeredHandlers() []*Handler {
+	handlerRegistry.RLock()
+	defer handlerRegistry.RUnlock()
+	handlers := make([]*Handler, 0, len(handlerRegistry.handlers))
+	for h := range handlerRegistry.handlers {
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+// adminAPI is a module that serves endpoints for inspecting serverless functions.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.serverless",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes for serverless functions.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/serverless/timeline",
+			Handler: caddy.AdminHandlerFunc(a.handleTimeline),
+		},
+		{
+			Pattern: "/serverless/containers",
+			Handler: caddy.AdminHandlerFunc(a.handleContainers),
+		},
+		{
+			Pattern: "/serverless/containers/",
+			Handler: caddy.AdminHandlerFunc(a.handleContainer),
+		},
+		{
+			Pattern: "/serverless/generate-alert-rules",
+			Handler: caddy.AdminHandlerFunc(a.handleGenerateAlertRules),
+		},
+	}
+}
+
+// handleTimeline returns the recent execution timelines of all handlers, oldest first
+func (a *adminAPI) handleTimeline(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	timelines := []Timeline{}
+	for _, h := range registeredHandlers() {
+		timelines = append(timelines, h.timelines.list()...)
+	}
+	sort.SliceStable(timelines, func(i, j int) bool {
+		return timelines[i].RequestReceived.Before(*timelines[j].RequestReceived)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(timelines)
+}
+
+// ContainerInfo describes a container serving a request
+type ContainerInfo struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace,omitempty"`
+	Path      string `json:"path"`
+	Image     string `json:"image"`
+	// Status is running, paused, or stopped when a reload stopped the
+	// container while its request completes
+	Status string `json:"status"`
+	// StartedAt and AgeSeconds are omitted for container managers that do
+	// not record when containers start
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	AgeSeconds float64    `json:"age_seconds,omitempty"`
+}
+
+func newContainerInfo(id string, container inflightContainer) ContainerInfo {
+	info := ContainerInfo{
+		ID:        id,
+		Namespace: container.function.Namespace,
+		Path:      container.function.Path,
+		Image:     container.function.Image,
+		Status:    container.status,
+	}
+	if !container.startedAt.IsZero() {
+		startedAt := container.startedAt
+		info.StartedAt = &startedAt
+		info.AgeSeconds = time.Since(startedAt).Seconds()
+	}
+	return info
+}
+
+// handleContainers returns the containers currently serving requests, sorted
+// by namespace, path and ID. The namespace query parameter filters them.
+func (a *adminAPI) handleContainers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	containers := []ContainerInfo{}
+	for _, h := range registeredHandlers() {
+		for id, container := range h.inflight.snapshot() {
+			if query.Has("namespace") && container.function.Namespace != namespace {
+				continue
+			}
+			containers = append(containers, newContainerInfo(id, container))
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Namespace != containers[j].Namespace {
+			return containers[i].Namespace < containers[j].Namespace
+		}
+		if containers[i].Path != containers[j].Path {
+			return containers[i].Path < containers[j].Path
+		}
+		return containers[i].ID < containers[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(containers)
+}
+
+// handleContainer serves the actions on a container serving a request, at
+// /serverless/containers/{id}/{action}
+func (a *adminAPI) handleContainer(w http.ResponseWriter, r *http.Request) error {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/serverless/containers/"), "/")
+	method := http.MethodPost
+	if action == "logs" {
+		method = http.MethodGet
+	}
+	switch {
+	case id == "" || (action != "logs" && action != "pause" && action != "resume"):
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("not found: %s", r.URL.Path),
+		}
+	case r.Method != method:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	for _, h := range registeredHandlers() {
+		if container, ok := h.inflight.snapshot()[id]; ok {
+			if action == "logs" {
+				return a.handleContainerLogs(w, r, id, container)
+			}
+			return a.handleContainerPause(w, r, h, id, container, action == "pause")
+		}
+	}
+	return caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        fmt.Errorf("no container %s is serving a request", id),
+	}
+}
+
+// handleContainerLogs returns the recent output of a container. The since
+// query parameter limits it to a duration such as 5m and tail to a number
+// of lines.
+func (a *adminAPI) handleContainerLogs(w http.ResponseWriter, r *http.Request, id string, container inflightContainer) error {
+	query := r.URL.Query()
+	var since time.Duration
+	if value := query.Get("since"); value != "" {
+		d, err := caddy.ParseDuration(value)
+		if err != nil || d < 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid since: %s", value),
+			}
+		}
+		since = d
+	}
+	var tail int
+	if value := query.Get("tail"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid tail: %s", value),
+			}
+		}
+		tail = n
+	}
+
+	entries, err := container.manager.GetContainerLogs(r.Context(), id, since, tail)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// handleContainerPause pauses a running container or resumes a paused one,
+// and returns its updated ContainerInfo
+func (a *adminAPI) handleContainerPause(w http.ResponseWriter, r *http.Request, h *Handler, id string, container inflightContainer, pause bool) error {
+	from, to, operation := ContainerStatusRunning, ContainerStatusPaused, container.manager.PauseContainer
+	if !pause {
+		from, to, operation = ContainerStatusPaused, ContainerStatusRunning, container.manager.ResumeContainer
+	}
+	if container.status != from {
+		return caddy.APIError{
+			HTTPStatus: http.StatusConflict,
+			Err:        fmt.Errorf("container %s is %s", id, container.status),
+		}
+	}
+
+	if err := operation(r.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errPauseNotSupported) {
+			status = http.StatusNotImplemented
+		}
+		return caddy.APIError{
+			HTTPStatus: status,
+			Err:        err,
+		}
+	}
+	h.inflight.setStatus(id, to)
+	container.status = to
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(newContainerInfo(id, container))
+}
+
+// handleGenerateAlertRules returns Prometheus alerting rules for the
+// functions of all handlers as YAML. Saving them is left to the caller, so
+// that the admin API never writes to paths chosen by its clients.
+func (a *adminAPI) handleGenerateAlertRules(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	var functions []FunctionConfig
+	for _, h := range registeredHandlers() {
+		functions = append(functions, h.Functions...)
+	}
+	out, err := renderAlertRules(functions)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, err = w.Write(out)
+	return err
+}
+
+// Interface guard
+var _ caddy.AdminRouter = (*adminAPI)(nil)
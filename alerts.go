@@ -0,0 +1,149 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultColdStartBudget is the p95 cold start time above which the
+// ColdStartBudgetExceeded alert fires when a function sets no budget.
+const defaultColdStartBudget = 5 * time.Second
+
+// alertRuleFile is a Prometheus alerting rules file.
+type alertRuleFile struct {
+	Groups []alertRuleGroup `yaml:"groups"`
+}
+
+// alertRuleGroup is a named group of alerting rules.
+type alertRuleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+// alertRule is a single Prometheus alerting rule.
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// GenerateAlertRules writes a Prometheus alerting rules file to outputFile
+// with alerts for common failure modes of each configured function.
+func (h *Handler) GenerateAlertRules(outputFile string) error {
+	return writeAlertRules(outputFile, h.Functions)
+}
+
+// renderAlertRules returns the alerting rules for functions as YAML.
+func renderAlertRules(functions []FunctionConfig) ([]byte, error) {
+	out, err := yaml.Marshal(buildAlertRules(functions))
+	if err != nil {
+		return nil, fmt.Errorf("encoding alert rules: %v", err)
+	}
+	return out, nil
+}
+
+// writeAlertRules renders the alerting rules for functions as YAML and
+// writes them to outputFile.
+func writeAlertRules(outputFile string, functions []FunctionConfig) error {
+	out, err := renderAlertRules(functions)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, out, 0o644); err != nil {
+		return fmt.Errorf("writing alert rules: %v", err)
+	}
+	return nil
+}
+
+// buildAlertRules returns one rule group with the alerts for every function.
+//
+// The OOM alert relies on cAdvisor's container_oom_events_total metric, since
+// containers are removed as soon as they exit; the others use the plugin's
+// own metrics.
+func buildAlertRules(functions []FunctionConfig) alertRuleFile {
+	group := alertRuleGroup{Name: "caddy-serverless", Rules: []alertRule{}}
+
+	for _, fn := range functions {
+		selector := "{function=" + strconv.Quote(fn.Path) + "}"
+		labels := func(severity string) map[string]string {
+			return map[string]string{"severity": severity, "function": fn.Path}
+		}
+
+		group.Rules = append(group.Rules, alertRule{
+			Alert:  "ContainerStartRateHigh",
+			Expr:   fmt.Sprintf("sum(rate(%s%s[5m])) * 60 > 5", metricContainerStarts, selector),
+			For:    "5m",
+			Labels: labels("warning"),
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Function %s is starting more than 5 containers per minute", fn.Path),
+				"description": "Container start rate is {{ $value | humanize }} per minute.",
+			},
+		})
+
+		// One OOM alert per image, including each of the function's versions
+		for _, image := range fn.images() {
+			oomLabels := labels("critical")
+			oomLabels["image"] = image
+			group.Rules = append(group.Rules, alertRule{
+				Alert:  "ContainerOOMRate",
+				Expr:   fmt.Sprintf("sum(increase(container_oom_events_total{image=%s}[1m])) > 0", strconv.Quote(image)),
+				For:    "0m",
+				Labels: oomLabels,
+				Annotations: map[string]string{
+					"summary":     fmt.Sprintf("Containers of function %s are being OOM killed", fn.Path),
+					"description": "{{ $value }} OOM kills in the last minute for image " + image + ".",
+				},
+			})
+		}
+
+		group.Rules = append(group.Rules, alertRule{
+			Alert: "FunctionErrorRate",
+			Expr: fmt.Sprintf("sum(rate(%s{function=%s,code=~\"5..\"}[5m])) / sum(rate(%s%s[5m])) > 0.1",
+				metricResponses, strconv.Quote(fn.Path), metricResponses, selector),
+			For:    "5m",
+			Labels: labels("critical"),
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Function %s returns more than 10%% server errors", fn.Path),
+				"description": "{{ $value | humanizePercentage }} of responses are 5xx.",
+			},
+		})
+
+		budget := time.Duration(fn.ColdStartBudget)
+		if budget <= 0 {
+			budget = defaultColdStartBudget
+		}
+		group.Rules = append(group.Rules, alertRule{
+			Alert: "ColdStartBudgetExceeded",
+			Expr: fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket%s[5m]))) > %s",
+				metricColdStart, selector, strconv.FormatFloat(budget.Seconds(), 'f', -1, 64)),
+			For:    "10m",
+			Labels: labels("warning"),
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Function %s p95 cold start exceeds %s", fn.Path, budget),
+				"description": "p95 cold start is {{ $value | humanizeDuration }}.",
+			},
+		})
+	}
+
+	return alertRuleFile{Groups: []alertRuleGroup{group}}
+}
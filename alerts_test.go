@@ -0,0 +1,154 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGenerateAlertRules(t *testing.T) {
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{Path: "/api/users/.*", Image: "users:latest", ColdStartBudget: caddy.Duration(2 * time.Second)},
+			{Path: "/api/app", ComposeFile: "/srv/app/compose.yml", ComposeService: "web"},
+			{Path: "/api/canary", Versions: []FunctionVersion{{Image: "canary:v1", Weight: 90}, {Image: "canary:v2", Weight: 10}}},
+		},
+	}
+	outputFile := filepath.Join(t.TempDir(), "rules.yml")
+	if err := handler.GenerateAlertRules(outputFile); err != nil {
+		t.Fatalf("GenerateAlertRules failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("failed to read rules file: %v", err)
+	}
+
+	// Decode strictly into the Prometheus rules file layout
+	var rules struct {
+		Groups []struct {
+			Name  string `yaml:"name"`
+			Rules []struct {
+				Alert       string            `yaml:"alert"`
+				Expr        string            `yaml:"expr"`
+				For         string            `yaml:"for"`
+				Labels      map[string]string `yaml:"labels"`
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"rules"`
+		} `yaml:"groups"`
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		t.Fatalf("rules file is not valid: %v\n%s", err, data)
+	}
+	if len(rules.Groups) != 1 || rules.Groups[0].Name == "" {
+		t.Fatalf("expected one named group, got %+v", rules.Groups)
+	}
+
+	alerts := make(map[string]int)
+	for _, rule := range rules.Groups[0].Rules {
+		alerts[rule.Alert]++
+		if rule.Expr == "" {
+			t.Errorf("%s: empty expr", rule.Alert)
+		}
+		if _, err := time.ParseDuration(rule.For); err != nil {
+			t.Errorf("%s: invalid for %q: %v", rule.Alert, rule.For, err)
+		}
+		if rule.Labels["severity"] == "" || rule.Labels["function"] == "" {
+			t.Errorf("%s: missing labels: %v", rule.Alert, rule.Labels)
+		}
+		if !strings.Contains(rule.Annotations["summary"], rule.Labels["function"]) {
+			t.Errorf("%s: summary %q does not mention the function path", rule.Alert, rule.Annotations["summary"])
+		}
+		if strings.Count(rule.Expr, "(") != strings.Count(rule.Expr, ")") {
+			t.Errorf("%s: unbalanced parentheses in %q", rule.Alert, rule.Expr)
+		}
+	}
+
+	// The compose function has no image to match OOM events on, and each
+	// version of the canary function gets its own
+	expected := map[string]int{
+		"ContainerStartRateHigh":  3,
+		"ContainerOOMRate":        3,
+		"FunctionErrorRate":       3,
+		"ColdStartBudgetExceeded": 3,
+	}
+	for alert, count := range expected {
+		if alerts[alert] != count {
+			t.Errorf("expected %d %s alerts, got %d", count, alert, alerts[alert])
+		}
+	}
+
+	if !strings.Contains(string(data), `container_oom_events_total{image="canary:v2"}`) {
+		t.Errorf("expected an OOM alert for each version's image, got:\n%s", data)
+	}
+	if !strings.Contains(string(data), `{function="/api/users/.*"}`) {
+		t.Errorf("expected expressions to select the function path, got:\n%s", data)
+	}
+	if !strings.Contains(string(data), "[5m]))) > 2\n") {
+		t.Errorf("expected the configured cold start budget in the rules, got:\n%s", data)
+	}
+}
+
+func TestAdminGenerateAlertRules(t *testing.T) {
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{Methods: []string{"GET"}, Path: "/api/admin-alerts", Image: "test:latest"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+	defer func() { _ = handler.Cleanup() }()
+
+	req := httptest.NewRequest("GET", "/serverless/generate-alert-rules", nil)
+	w := httptest.NewRecorder()
+	if err := new(adminAPI).handleGenerateAlertRules(w, req); err != nil {
+		t.Fatalf("unexpected admin error: %v", err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/yaml" {
+		t.Errorf("expected YAML content type, got %q", ct)
+	}
+	var rules alertRuleFile
+	if err := yaml.Unmarshal(w.Body.Bytes(), &rules); err != nil || len(rules.Groups) != 1 {
+		t.Fatalf("expected one rule group, got %v (error: %v)", rules.Groups, err)
+	}
+	if !strings.Contains(w.Body.String(), "/api/admin-alerts") {
+		t.Errorf("expected rules for the registered function, got:\n%s", w.Body.String())
+	}
+
+	// Rules are only returned, never written to a path from the request
+	outputFile := filepath.Join(t.TempDir(), "rules.yml")
+	req = httptest.NewRequest("POST", "/serverless/generate-alert-rules",
+		strings.NewReader(`{"output_file": "`+outputFile+`"}`))
+	if err := new(adminAPI).handleGenerateAlertRules(httptest.NewRecorder(), req); err == nil {
+		t.Error("expected error for POST request")
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be written, got %v", err)
+	}
+}
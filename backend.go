@@ -0,0 +1,83 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// BackendFactory creates a container backend from its JSON configuration,
+// the handler's BackendConfig.
+type BackendFactory func(config json.RawMessage, logger *zap.Logger) (ContainerManagerInterface, error)
+
+// backends holds the registered BackendFactory of each backend type
+var backends sync.Map
+
+// RegisterBackend makes a container backend available as the handler's
+// BackendType, letting other packages run functions on something other than
+// Docker, e.g. Kubernetes pods. It is meant to be called from init and
+// panics if name is empty or already registered.
+func RegisterBackend(name string, factory BackendFactory) {
+	if name == "" {
+		panic("serverless: backend name cannot be empty")
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("serverless: backend %s: factory cannot be nil", name))
+	}
+	if _, loaded := backends.LoadOrStore(name, factory); loaded {
+		panic(fmt.Sprintf("serverless: backend already registered: %s", name))
+	}
+}
+
+// lookupBackend returns the factory registered as name
+func lookupBackend(name string) (BackendFactory, bool) {
+	factory, ok := backends.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return factory.(BackendFactory), true
+}
+
+// registeredBackends returns the names of the registered backends, sorted
+func registeredBackends() []string {
+	var names []string
+	backends.Range(func(name, _ any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// newBackend creates the handler's container backend with its BackendType
+func (h *Handler) newBackend() (ContainerManagerInterface, error) {
+	factory, ok := lookupBackend(h.BackendType)
+	if !ok {
+		return nil, fmt.Errorf("unknown backend_type '%s'", h.BackendType)
+	}
+	manager, err := factory(h.BackendConfig, h.logger.With(zap.String("backend", h.BackendType)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s backend: %v", h.BackendType, err)
+	}
+	if manager == nil {
+		return nil, fmt.Errorf("%s backend returned no container manager", h.BackendType)
+	}
+	return manager, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestRegisterBackend_Provision(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	var gotConfig json.RawMessage
+	calls := 0
+	RegisterBackend("test-provision", func(config json.RawMessage, logger *zap.Logger) (ContainerManagerInterface, error) {
+		calls++
+		gotConfig = config
+		if logger == nil {
+			t.Error("expected a logger")
+		}
+		return mockCM, nil
+	})
+
+	handler := &Handler{
+		BackendType:   "test-provision",
+		BackendConfig: json.RawMessage(`{"namespace":"functions"}`),
+		Functions: []FunctionConfig{
+			{Methods: []string{"GET"}, Path: "/api/test", Image: "test:latest"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+	defer handler.Cleanup()
+
+	if calls != 1 {
+		t.Fatalf("expected the factory to be called once, got %d", calls)
+	}
+	if string(gotConfig) != `{"namespace":"functions"}` {
+		t.Errorf("expected the backend config, got %s", gotConfig)
+	}
+	if handler.containerManager != mockCM {
+		t.Error("expected the backend to run the handler's containers")
+	}
+}
+
+func TestRegisterBackend_Duplicate(t *testing.T) {
+	factory := func(json.RawMessage, *zap.Logger) (ContainerManagerInterface, error) {
+		return NewMockContainerManager(), nil
+	}
+	RegisterBackend("test-duplicate", factory)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a backend twice to panic")
+		}
+	}()
+	RegisterBackend("test-duplicate", factory)
+}
+
+func TestHandler_BackendValidation(t *testing.T) {
+	RegisterBackend("test-validation", func(json.RawMessage, *zap.Logger) (ContainerManagerInterface, error) {
+		return NewMockContainerManager(), nil
+	})
+	tests := []struct {
+		name    string
+		handler Handler
+		wantErr string
+	}{
+		{name: "registered", handler: Handler{BackendType: "test-validation"}},
+		{name: "unknown", handler: Handler{BackendType: "nope"}, wantErr: "unknown backend 'nope'"},
+		{name: "with swarm", handler: Handler{BackendType: "test-validation", UseSwarm: true}, wantErr: "use_swarm"},
+		{name: "config without type", handler: Handler{BackendConfig: json.RawMessage(`{}`)}, wantErr: "requires backend_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errBudgetExceeded is returned when starting a container would exceed the
+// handler's MaxContainers or MaxTotalMemory
+var errBudgetExceeded = errors.New("container resource budget exceeded")
+
+// resourceBudget limits the containers a handler runs at once and the sum of
+// their configured memory limits
+type resourceBudget struct {
+	maxContainers int
+	maxMemory     int64
+
+	mutex      sync.Mutex
+	containers int
+	memory     int64
+}
+
+// newResourceBudget returns a budget for the handler's MaxContainers and
+// MaxTotalMemory, or nil if neither is set
+func newResourceBudget(maxContainers int, maxTotalMemory string) (*resourceBudget, error) {
+	var maxMemory int64
+	if maxTotalMemory != "" {
+		var err error
+		maxMemory, err = parseMemorySize(maxTotalMemory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_total_memory: %v", err)
+		}
+	}
+	if maxContainers <= 0 && maxMemory <= 0 {
+		return nil, nil
+	}
+	return &resourceBudget{maxContainers: maxContainers, maxMemory: maxMemory}, nil
+}
+
+// reserve accounts for a container with the given memory limit, failing with
+// errBudgetExceeded if it does not fit. Each successful reserve must be
+// followed by a release of the same memory.
+func (b *resourceBudget) reserve(memory int64) error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.maxContainers > 0 && b.containers+1 > b.maxContainers {
+		return fmt.Errorf("%w: %d of %d containers running", errBudgetExceeded, b.containers, b.maxContainers)
+	}
+	if b.maxMemory > 0 && b.memory+memory > b.maxMemory {
+		return fmt.Errorf("%w: %d of %d bytes of memory in use, %d more requested", errBudgetExceeded, b.memory, b.maxMemory, memory)
+	}
+	b.containers++
+	b.memory += memory
+	return nil
+}
+
+// release returns a container's reservation to the budget
+func (b *resourceBudget) release(memory int64) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	b.containers--
+	b.memory -= memory
+	b.mutex.Unlock()
+}
+
+// memoryUnits are the multipliers of docker's memory size suffixes
+var memoryUnits = map[byte]int64{'b': 1, 'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+
+// parseMemorySize returns the number of bytes in a docker memory size such as
+// 512m or 1g. Sizes without a suffix are in bytes.
+func parseMemorySize(size string) (int64, error) {
+	if !memorySizeRegex.MatchString(size) {
+		return 0, fmt.Errorf("invalid memory size '%s'", size)
+	}
+	digits, unit := size, int64(1)
+	if multiplier, ok := memoryUnits[strings.ToLower(size)[len(size)-1]]; ok {
+		digits, unit = size[:len(size)-1], multiplier
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n > (1<<63-1)/unit {
+		return 0, fmt.Errorf("memory size '%s' is too large", size)
+	}
+	return n * unit, nil
+}
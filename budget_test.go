@@ -0,0 +1,84 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		size     string
+		expected int64
+		wantErr  bool
+	}{
+		{size: "512", expected: 512},
+		{size: "2b", expected: 2},
+		{size: "4k", expected: 4 << 10},
+		{size: "256m", expected: 256 << 20},
+		{size: "1G", expected: 1 << 30},
+		{size: "", wantErr: true},
+		{size: "1.5g", wantErr: true},
+		{size: "99999999999999g", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMemorySize(tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.size, tt.wantErr, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %d bytes, got %d", tt.size, tt.expected, got)
+		}
+	}
+}
+
+func TestResourceBudget(t *testing.T) {
+	if budget, err := newResourceBudget(0, ""); budget != nil || err != nil {
+		t.Fatalf("expected no budget without limits, got %v, %v", budget, err)
+	}
+	var unlimited *resourceBudget
+	if err := unlimited.reserve(1 << 30); err != nil {
+		t.Errorf("expected a nil budget to allow anything, got %v", err)
+	}
+	unlimited.release(1 << 30)
+
+	budget, err := newResourceBudget(2, "1g")
+	if err != nil {
+		t.Fatalf("newResourceBudget failed: %v", err)
+	}
+	if err := budget.reserve(768 << 20); err != nil {
+		t.Fatalf("first reservation failed: %v", err)
+	}
+	if err := budget.reserve(512 << 20); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("expected the memory budget to be exceeded, got %v", err)
+	}
+	if err := budget.reserve(0); err != nil {
+		t.Fatalf("reservation without memory failed: %v", err)
+	}
+	if err := budget.reserve(0); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("expected the container budget to be exceeded, got %v", err)
+	}
+	budget.release(768 << 20)
+	budget.release(0)
+	if err := budget.reserve(1 << 30); err != nil {
+		t.Errorf("expected released reservations to free the budget, got %v", err)
+	}
+
+	if _, err := newResourceBudget(0, "lots"); err == nil {
+		t.Error("expected an error for an invalid max_total_memory")
+	}
+}
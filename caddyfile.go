@@ -17,8 +17,11 @@
 package serverless
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -38,16 +41,108 @@ func init() {
 // UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
 //
 //	serverless {
+//	    no_match 404 "not found"
+//	    method_not_allowed
+//	    timeline_buffer_size 100
+//	    use_swarm
+//	    backend kubernetes `{"namespace": "functions"}`
+//	    event_webhook https://hooks.example.com/serverless
+//	    debug
+//	    default_namespace production
+//	    merge_slashes
+//	    trim_trailing_slash
+//	    case_insensitive_paths
+//	    duplicate_functions warn|error
+//	    auto_prune_images
+//	    allow_privileged
+//	    max_containers 20
+//	    max_total_memory 4g
+//	    global_env LOG_LEVEL=info
 //	    function {
+//	        name api
 //	        methods GET POST
+//	        content_type multipart/* application/json
+//	        auto_options on|off
 //	        path /api/.*
 //	        image nginx:latest
+//	        versions {
+//	            app:v1 90
+//	            app:v2 10
+//	        }
+//	        namespace production
 //	        command /bin/sh -c "echo hello"
+//	        append_args --verbose
+//	        post_start /app/init.sh
+//	        post_start_timeout 10s
+//	        warmup_delay 200ms
 //	        env KEY=value
+//	        inherit_env HOME PATH
+//	        inherit_all_env
+//	        max_env_value_length 65536
+//	        max_env_size 1048576
 //	        volume /host/path:/container/path
 //	        volume /host/path:/container/path:ro
+//	        volume /host/path:/container/path:ro:rshared
+//	        file_mount /host/app.conf:/etc/app.conf:ro <sha256>
+//	        request_scratch /scratch
+//	        isolation per-request
+//	        enable_http2_push
+//	        transport {
+//	            dial_timeout 1s
+//	            tls_handshake_timeout 2s
+//	            response_header_timeout 5s
+//	            max_idle_conns 10
+//	        }
+//	        fallback_response {
+//	            status 503
+//	            header Retry-After 30
+//	            body "temporarily unavailable"
+//	        }
 //	        timeout 30s
+//	        cold_start_budget 2s
 //	        port 8080
+//	        ready_port 9090
+//	        ready_max_attempts 10
+//	        ready_failure_retries 2
+//	        memory 256m
+//	        memory_swap 512m
+//	        log_config {
+//	            max_size 10m
+//	            max_file 3
+//	            compress
+//	        }
+//	        log_driver json-file
+//	        log_opt tag={{.Name}}
+//	        error_logger payments
+//	        oom_kill_disable
+//	        oom_score_adj -500
+//	        cgroup_parent /function/payments
+//	        ipc_mode private|shareable|host|none|container:<name>
+//	        pid_mode private|host
+//	        userns_mode host|keep-id
+//	        group_add video 44
+//	        privileged
+//	        seccomp /etc/caddy/seccomp.json
+//	        max_body_size 1048576
+//	        max_header_bytes 8192
+//	        prebuffer_request
+//	        disable_port_check
+//	        user_agent my-agent/1.0
+//	        timing_header Server-Timing
+//	        debug_bodies [4096]
+//	        debug_redact X-Api-Key password
+//	        allow_ip 10.0.0.0/8 192.168.1.10
+//	        deny_ip 10.0.0.5
+//	        status_map 418 200
+//	        webhook_dedup X-Webhook-ID 5m
+//	        timezone America/New_York [mount_localtime]
+//	        locale en_US.UTF-8
+//	        constraint node.labels.region==us-east
+//	        compose /srv/app/docker-compose.yml web
+//	        inline_script <<SCRIPT
+//	            echo "hello from $HOSTNAME"
+//	            exec my-server
+//	            SCRIPT
 //	    }
 //	}
 func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
@@ -69,18 +164,43 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					function.Methods = args
 
+				case "content_type":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.MatchContentType = args
+
 				case "path":
 					if !d.NextArg() {
 						return d.ArgErr()
 					}
 					function.Path = d.Val()
 
+				case "name":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Name = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
 				case "image":
 					if !d.NextArg() {
 						return d.ArgErr()
 					}
 					function.Image = d.Val()
 
+				case "namespace":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Namespace = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
 				case "command":
 					args := d.RemainingArgs()
 					if len(args) == 0 {
@@ -88,6 +208,43 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					function.Command = args
 
+				case "append_args":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.AppendArgs = append(function.AppendArgs, args...)
+
+				case "post_start":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.PostStartCommand = args
+
+				case "post_start_timeout":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					timeout, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid post_start_timeout duration: %v", err)
+					}
+					function.PostStartTimeout = caddy.Duration(timeout)
+
+				case "warmup_delay":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					delay, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid warmup_delay duration: %v", err)
+					}
+					if delay < 0 {
+						return d.Errf("warmup_delay cannot be negative")
+					}
+					function.WarmupDelay = caddy.Duration(delay)
+
 				case "env":
 					if !d.NextArg() {
 						return d.ArgErr()
@@ -117,6 +274,24 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					// Store the environment variable. Empty values (e.g., "KEY=") are allowed and will be stored as empty strings.
 					function.Environment[key] = value
 
+				case "inherit_env":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					for _, key := range args {
+						if !envVarNameRegex.MatchString(key) {
+							return d.Errf("invalid environment variable name: '%s'", key)
+						}
+					}
+					function.InheritEnv = append(function.InheritEnv, args...)
+
+				case "inherit_all_env":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.InheritAllEnv = true
+
 				case "volume":
 					if !d.NextArg() {
 						return d.ArgErr()
@@ -128,6 +303,246 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					function.Volumes = append(function.Volumes, volume)
 
+				case "request_scratch":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.RequestScratch = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "file_mount":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					spec, err := parseVolumeSpec(d.Val())
+					if err != nil {
+						return d.Errf("invalid file_mount specification: %v", err)
+					}
+					if spec.Propagation != "" {
+						return d.Errf("file_mount does not support mount propagation")
+					}
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.FileMounts = append(function.FileMounts, FileMount{
+						HostPath:      spec.Source,
+						ContainerPath: spec.Target,
+						SHA256:        d.Val(),
+						ReadOnly:      spec.ReadOnly,
+					})
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "log_config":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					logConfig := &ContainerLogConfig{}
+					for d.NextBlock(2) {
+						switch d.Val() {
+						case "max_size":
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							if !memorySizeRegex.MatchString(d.Val()) {
+								return d.Errf("invalid log_config max_size '%s'", d.Val())
+							}
+							logConfig.MaxSize = d.Val()
+
+						case "max_file":
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							maxFile, err := strconv.Atoi(d.Val())
+							if err != nil {
+								return d.Errf("invalid log_config max_file: %v", err)
+							}
+							if maxFile <= 0 {
+								return d.Errf("log_config max_file must be positive")
+							}
+							logConfig.MaxFile = maxFile
+
+						case "compress":
+							logConfig.Compress = true
+
+						default:
+							return d.Errf("unrecognized log_config subdirective '%s'", d.Val())
+						}
+						if d.NextArg() {
+							return d.ArgErr()
+						}
+					}
+					function.LogConfig = logConfig
+
+				case "log_driver":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					if !logDrivers[d.Val()] {
+						return d.Errf("unknown log driver '%s'", d.Val())
+					}
+					function.LogDriver = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "log_opt":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					key, value, ok := strings.Cut(d.Val(), "=")
+					if !ok || key == "" {
+						return d.Errf("invalid log_opt '%s' (expected key=value)", d.Val())
+					}
+					if function.LogOpts == nil {
+						function.LogOpts = make(map[string]string)
+					}
+					function.LogOpts[key] = value
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "error_logger":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					if !loggerNameRegex.MatchString(d.Val()) {
+						return d.Errf("invalid error_logger name '%s'", d.Val())
+					}
+					function.ErrorLogger = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "fallback_response":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					fallback := &FallbackResponse{}
+					for d.NextBlock(2) {
+						switch d.Val() {
+						case "status":
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							status, err := strconv.Atoi(d.Val())
+							if err != nil {
+								return d.Errf("invalid fallback_response status: %v", err)
+							}
+							if status < 100 || status > 599 {
+								return d.Errf("fallback_response status must be between 100 and 599")
+							}
+							fallback.StatusCode = status
+
+						case "header":
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							name := d.Val()
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							if fallback.Headers == nil {
+								fallback.Headers = make(http.Header)
+							}
+							fallback.Headers.Add(name, d.Val())
+
+						case "body":
+							if !d.NextArg() {
+								return d.ArgErr()
+							}
+							fallback.Body = d.Val()
+
+						default:
+							return d.Errf("unrecognized fallback_response subdirective '%s'", d.Val())
+						}
+						if d.NextArg() {
+							return d.ArgErr()
+						}
+					}
+					function.FallbackResponse = fallback
+
+				case "enable_http2_push":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.EnableHTTP2Push = true
+
+				case "transport":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					transport := &TransportConfig{}
+					for d.NextBlock(2) {
+						option := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						switch option {
+						case "dial_timeout", "tls_handshake_timeout", "response_header_timeout":
+							timeout, err := time.ParseDuration(d.Val())
+							if err != nil {
+								return d.Errf("invalid transport %s: %v", option, err)
+							}
+							switch option {
+							case "dial_timeout":
+								transport.DialTimeout = caddy.Duration(timeout)
+							case "tls_handshake_timeout":
+								transport.TLSHandshakeTimeout = caddy.Duration(timeout)
+							default:
+								transport.ResponseHeaderTimeout = caddy.Duration(timeout)
+							}
+						case "max_idle_conns":
+							conns, err := strconv.Atoi(d.Val())
+							if err != nil {
+								return d.Errf("invalid transport max_idle_conns: %v", err)
+							}
+							transport.MaxIdleConns = conns
+						default:
+							return d.Errf("unrecognized transport subdirective '%s'", option)
+						}
+						if d.NextArg() {
+							return d.ArgErr()
+						}
+					}
+					function.Transport = transport
+
+				case "versions":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					for d.NextBlock(2) {
+						image := d.Val()
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						weight, err := strconv.Atoi(d.Val())
+						if err != nil || weight < 1 {
+							return d.Errf("invalid weight '%s' for version %s: must be a positive integer", d.Val(), image)
+						}
+						if d.NextArg() {
+							return d.ArgErr()
+						}
+						function.Versions = append(function.Versions, FunctionVersion{Image: image, Weight: weight})
+					}
+
+				case "isolation":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					switch d.Val() {
+					case IsolationShared, IsolationPerRequest:
+						function.Isolation = d.Val()
+					default:
+						return d.Errf("invalid isolation '%s' (expected %s or %s)", d.Val(), IsolationShared, IsolationPerRequest)
+					}
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
 				case "timeout":
 					if !d.NextArg() {
 						return d.ArgErr()
@@ -139,6 +554,16 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					function.Timeout = caddy.Duration(timeout)
 
+				case "cold_start_budget":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					budget, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid cold_start_budget duration: %v", err)
+					}
+					function.ColdStartBudget = caddy.Duration(budget)
+
 				case "port":
 					if !d.NextArg() {
 						return d.ArgErr()
@@ -153,50 +578,1028 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					function.Port = port
 
-				default:
-					return d.Errf("unrecognized subdirective '%s'", d.Val())
-				}
-			}
+				case "ready_port":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					port, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid ready_port number: %v", err)
+					}
+					if port < 1 || port > 65535 {
+						return d.Errf("ready_port number must be between 1 and 65535")
+					}
+					function.ReadyPort = port
 
-			// After the function configuration block
-			if function.Image == "" {
-				return d.Errf("image is required for serverless function")
-			}
-			if function.Path == "" {
-				return d.Errf("path is required for serverless function")
-			}
-			h.Functions = append(h.Functions, function)
+				case "ready_max_attempts":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					attempts, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid ready_max_attempts: %v", err)
+					}
+					if attempts <= 0 {
+						return d.Errf("ready_max_attempts must be positive")
+					}
+					function.ReadyMaxAttempts = attempts
 
-		default:
-			return d.Errf("unrecognized subdirective '%s'", d.Val())
-		}
-	}
+				case "ready_failure_retries":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					retries, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid ready_failure_retries: %v", err)
+					}
+					if retries < 0 {
+						return d.Errf("ready_failure_retries cannot be negative")
+					}
+					function.ReadyFailureRetries = retries
 
-	return nil
-}
+				case "max_body_size":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					size, err := strconv.ParseInt(d.Val(), 10, 64)
+					if err != nil {
+						return d.Errf("invalid max_body_size: %v", err)
+					}
+					if size < 0 {
+						return d.Errf("max_body_size cannot be negative")
+					}
+					function.MaxBodySize = size
 
-// parseVolumeSpec parses a volume specification in the format:
-// /host/path:/container/path[:ro]
-func parseVolumeSpec(spec string) (VolumeMount, error) {
-	parts := strings.Split(spec, ":")
-	const (
-		minVolumeSpecParts = 2
-		maxVolumeSpecParts = 3
-	)
-	if len(parts) < minVolumeSpecParts || len(parts) > maxVolumeSpecParts {
-		return VolumeMount{}, fmt.Errorf("invalid volume format (expected /host/path:/container/path[:ro])")
-	}
+				case "max_header_bytes":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					size, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid max_header_bytes: %v", err)
+					}
+					if size < 1 {
+						return d.Errf("max_header_bytes must be positive")
+					}
+					function.MaxHeaderBytes = size
 
-	volume := VolumeMount{
-		Source: parts[0],
-		Target: parts[1],
-	}
+				case "max_env_value_length", "max_env_size":
+					option := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					size, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid %s: %v", option, err)
+					}
+					if size < 1 {
+						return d.Errf("%s must be positive", option)
+					}
+					if option == "max_env_size" {
+						function.MaxEnvSize = size
+					} else {
+						function.MaxEnvValueLength = size
+					}
 
-	if len(parts) == 3 {
-		if parts[2] == "ro" {
-			volume.ReadOnly = true
-		} else {
-			return VolumeMount{}, fmt.Errorf("invalid volume option '%s' (only 'ro' is supported)", parts[2])
+				case "memory":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Memory = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "memory_swap":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.MemorySwap = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "oom_kill_disable":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.OOMKillDisable = true
+
+				case "oom_score_adj":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					adj, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid oom_score_adj: %v", err)
+					}
+					if adj < minOOMScoreAdj || adj > maxOOMScoreAdj {
+						return d.Errf("oom_score_adj must be between %d and %d", minOOMScoreAdj, maxOOMScoreAdj)
+					}
+					function.OOMScoreAdj = adj
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "cgroup_parent":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.CgroupParent = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "ipc_mode":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.IPCMode = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "pid_mode":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.PIDMode = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "userns_mode":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.UsernsMode = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "group_add":
+					groups := d.RemainingArgs()
+					if len(groups) == 0 {
+						return d.ArgErr()
+					}
+					function.GroupAdd = append(function.GroupAdd, groups...)
+
+				case "privileged":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Privileged = true
+
+				case "seccomp":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.SeccompProfile = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "prebuffer_request":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.PrebufferRequest = true
+
+				case "auto_options":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					var enabled bool
+					switch d.Val() {
+					case "on":
+						enabled = true
+					case "off":
+						enabled = false
+					default:
+						return d.Errf("auto_options must be 'on' or 'off', got '%s'", d.Val())
+					}
+					function.AutoOptions = &enabled
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "disable_port_check":
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.DisablePortCheck = true
+
+				case "user_agent":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.UserAgent = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "timing_header":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.TimingHeader = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "debug_bodies":
+					function.DebugBodies = true
+					if d.NextArg() {
+						limit, err := strconv.Atoi(d.Val())
+						if err != nil {
+							return d.Errf("invalid debug_bodies limit: %v", err)
+						}
+						if limit < 1 {
+							return d.Errf("debug_bodies limit must be positive")
+						}
+						function.DebugBodyLimit = limit
+					}
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "debug_redact":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.DebugRedact = append(function.DebugRedact, args...)
+
+				case "allow_ip":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.AllowIPs = append(function.AllowIPs, args...)
+
+				case "deny_ip":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					function.DenyIPs = append(function.DenyIPs, args...)
+
+				case "status_map":
+					args := d.RemainingArgs()
+					if len(args) != 2 {
+						return d.ArgErr()
+					}
+					from, err := strconv.Atoi(args[0])
+					if err != nil {
+						return d.Errf("invalid status_map source code: %v", err)
+					}
+					to, err := strconv.Atoi(args[1])
+					if err != nil {
+						return d.Errf("invalid status_map target code: %v", err)
+					}
+					if from < 100 || from > 599 || to < 100 || to > 599 {
+						return d.Errf("status_map codes must be between 100 and 599")
+					}
+					if function.StatusMap == nil {
+						function.StatusMap = make(map[int]int)
+					}
+					function.StatusMap[from] = to
+
+				case "webhook_dedup":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					header := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					window, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid webhook_dedup window: %v", err)
+					}
+					if window <= 0 {
+						return d.Errf("webhook_dedup window must be positive")
+					}
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					function.WebhookDedup = &DedupConfig{Header: header, Window: caddy.Duration(window)}
+
+				case "timezone":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Timezone = d.Val()
+					if d.NextArg() {
+						if d.Val() != "mount_localtime" {
+							return d.Errf("unrecognized timezone option '%s'", d.Val())
+						}
+						function.MountLocaltime = true
+					}
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "locale":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.Locale = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "compose":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.ComposeFile = d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.ComposeService = d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "constraint":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					function.PlacementConstraints = append(function.PlacementConstraints, d.Val())
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+
+				case "inline_script":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					script := d.Val()
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+					if function.InlineScript != "" {
+						return d.Errf("inline_script may only be specified once per function")
+					}
+					function.InlineScript = script
+
+				default:
+					return d.Errf("unrecognized subdirective '%s'", d.Val())
+				}
+			}
+
+			// After the function configuration block
+			if function.Image == "" && function.ComposeFile == "" && len(function.Versions) == 0 {
+				return d.Errf("image is required for serverless function")
+			}
+			if function.Path == "" {
+				return d.Errf("path is required for serverless function")
+			}
+			h.Functions = append(h.Functions, function)
+
+		case "no_match":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			status, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid no_match status code: %v", err)
+			}
+			if status < 100 || status > 599 {
+				return d.Errf("no_match status code must be between 100 and 599")
+			}
+			h.NoMatchStatus = status
+			if d.NextArg() {
+				h.NoMatchBody = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "method_not_allowed":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.MethodNotAllowed = true
+
+		case "timeline_buffer_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid timeline_buffer_size: %v", err)
+			}
+			if size < 1 {
+				return d.Errf("timeline_buffer_size must be positive")
+			}
+			h.TimelineBufferSize = size
+
+		case "use_swarm":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.UseSwarm = true
+
+		case "backend":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.BackendType = d.Val()
+			if d.NextArg() {
+				if !json.Valid([]byte(d.Val())) {
+					return d.Errf("backend config must be valid JSON")
+				}
+				h.BackendConfig = json.RawMessage(d.Val())
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "merge_slashes":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.MergeSlashes = true
+
+		case "trim_trailing_slash":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.TrimTrailingSlash = true
+
+		case "case_insensitive_paths":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.CaseInsensitivePaths = true
+
+		case "duplicate_functions":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.DuplicateFunctions = d.Val()
+			if h.DuplicateFunctions != "warn" && h.DuplicateFunctions != "error" {
+				return d.Errf("duplicate_functions must be warn or error, got %s", h.DuplicateFunctions)
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "auto_prune_images":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.AutoPruneImages = true
+
+		case "allow_privileged":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.AllowPrivileged = true
+
+		case "max_containers":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid max_containers: %v", err)
+			}
+			if n < 1 {
+				return d.Errf("max_containers must be positive")
+			}
+			h.MaxContainers = n
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "global_env":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			key, value, ok := strings.Cut(d.Val(), "=")
+			if !ok {
+				return d.Errf("invalid environment variable format: %s (expected KEY=value)", d.Val())
+			}
+			if !envVarNameRegex.MatchString(key) {
+				return d.Errf("invalid environment variable name: '%s'", key)
+			}
+			if h.GlobalEnvironment == nil {
+				h.GlobalEnvironment = make(map[string]string)
+			}
+			h.GlobalEnvironment[key] = value
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "max_total_memory":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if _, err := parseMemorySize(d.Val()); err != nil {
+				return d.Errf("invalid max_total_memory: %v", err)
+			}
+			h.MaxTotalMemory = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "default_namespace":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.DefaultNamespace = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "debug":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Debug = true
+
+		case "event_webhook":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.EventWebhook = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		default:
+			return d.Errf("unrecognized subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// MarshalCaddyfile returns the handler's configuration in the syntax read
+// by UnmarshalCaddyfile, so that a JSON configuration can be exported.
+// Values the Caddyfile cannot express, such as environment variable names it
+// would reject, return an error.
+func (h Handler) MarshalCaddyfile() ([]byte, error) {
+	var b caddyfileBuilder
+	b.line(0, "serverless", "{")
+
+	if h.NoMatchStatus != 0 {
+		if h.NoMatchBody != "" {
+			b.line(1, "no_match", strconv.Itoa(h.NoMatchStatus), h.NoMatchBody)
+		} else {
+			b.line(1, "no_match", strconv.Itoa(h.NoMatchStatus))
+		}
+	} else if h.NoMatchBody != "" {
+		return nil, fmt.Errorf("no_match_body requires no_match_status")
+	}
+	if h.DefaultFunctionConfig != nil {
+		return nil, fmt.Errorf("default_function_config has no Caddyfile equivalent")
+	}
+	if h.MethodNotAllowed {
+		b.line(1, "method_not_allowed")
+	}
+	if h.TimelineBufferSize > 0 {
+		b.line(1, "timeline_buffer_size", strconv.Itoa(h.TimelineBufferSize))
+	}
+	if h.UseSwarm {
+		b.line(1, "use_swarm")
+	}
+	if h.BackendType != "" {
+		if len(h.BackendConfig) > 0 {
+			b.line(1, "backend", h.BackendType, string(h.BackendConfig))
+		} else {
+			b.line(1, "backend", h.BackendType)
+		}
+	} else if len(h.BackendConfig) > 0 {
+		return nil, fmt.Errorf("backend_config requires backend_type")
+	}
+	if h.EventWebhook != "" {
+		b.line(1, "event_webhook", h.EventWebhook)
+	}
+	if h.Debug {
+		b.line(1, "debug")
+	}
+	if h.DefaultNamespace != "" {
+		b.line(1, "default_namespace", h.DefaultNamespace)
+	}
+	if h.MergeSlashes {
+		b.line(1, "merge_slashes")
+	}
+	if h.TrimTrailingSlash {
+		b.line(1, "trim_trailing_slash")
+	}
+	if h.CaseInsensitivePaths {
+		b.line(1, "case_insensitive_paths")
+	}
+	if h.DuplicateFunctions != "" {
+		b.line(1, "duplicate_functions", h.DuplicateFunctions)
+	}
+	if h.AutoPruneImages {
+		b.line(1, "auto_prune_images")
+	}
+	if h.AllowPrivileged {
+		b.line(1, "allow_privileged")
+	}
+	globalKeys := make([]string, 0, len(h.GlobalEnvironment))
+	for key := range h.GlobalEnvironment {
+		if !envVarNameRegex.MatchString(key) {
+			return nil, fmt.Errorf("environment variable name '%s' cannot be expressed in a Caddyfile", key)
+		}
+		globalKeys = append(globalKeys, key)
+	}
+	sort.Strings(globalKeys)
+	for _, key := range globalKeys {
+		b.line(1, "global_env", key+"="+h.GlobalEnvironment[key])
+	}
+	if h.MaxContainers > 0 {
+		b.line(1, "max_containers", strconv.Itoa(h.MaxContainers))
+	}
+	if h.MaxTotalMemory != "" {
+		b.line(1, "max_total_memory", h.MaxTotalMemory)
+	}
+
+	for i, fn := range h.Functions {
+		if err := b.function(fn); err != nil {
+			return nil, fmt.Errorf("function %d: %v", i, err)
+		}
+	}
+
+	b.line(0, "}")
+	return []byte(b.String()), nil
+}
+
+// caddyfileBuilder writes Caddyfile lines, quoting tokens where needed
+type caddyfileBuilder struct {
+	strings.Builder
+}
+
+// line writes tokens on one line, indented by depth tabs
+func (b *caddyfileBuilder) line(depth int, tokens ...string) {
+	b.WriteString(strings.Repeat("\t", depth))
+	for i, token := range tokens {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		// Braces that open or close a block are written as is
+		if (token == "{" && i > 0 && i == len(tokens)-1) || (token == "}" && len(tokens) == 1) {
+			b.WriteString(token)
+			continue
+		}
+		b.WriteString(quoteCaddyfileToken(token))
+	}
+	b.WriteByte('\n')
+}
+
+// function writes the function block for fn
+func (b *caddyfileBuilder) function(fn FunctionConfig) error {
+	b.line(1, "function", "{")
+
+	if fn.Name != "" {
+		b.line(2, "name", fn.Name)
+	}
+	if len(fn.Methods) > 0 {
+		b.line(2, append([]string{"methods"}, fn.Methods...)...)
+	}
+	if len(fn.MatchContentType) > 0 {
+		b.line(2, append([]string{"content_type"}, fn.MatchContentType...)...)
+	}
+	if fn.AutoOptions != nil {
+		if *fn.AutoOptions {
+			b.line(2, "auto_options", "on")
+		} else {
+			b.line(2, "auto_options", "off")
+		}
+	}
+	if fn.Path != "" {
+		b.line(2, "path", fn.Path)
+	}
+	if fn.Image != "" {
+		b.line(2, "image", fn.Image)
+	}
+	if fn.Namespace != "" {
+		b.line(2, "namespace", fn.Namespace)
+	}
+	if len(fn.Command) > 0 {
+		b.line(2, append([]string{"command"}, fn.Command...)...)
+	}
+	if fn.InlineScript != "" {
+		b.line(2, "inline_script", fn.InlineScript)
+	}
+	if len(fn.AppendArgs) > 0 {
+		b.line(2, append([]string{"append_args"}, fn.AppendArgs...)...)
+	}
+	if len(fn.PostStartCommand) > 0 {
+		b.line(2, append([]string{"post_start"}, fn.PostStartCommand...)...)
+	}
+	if fn.PostStartTimeout != 0 {
+		b.line(2, "post_start_timeout", time.Duration(fn.PostStartTimeout).String())
+	}
+	if fn.WarmupDelay != 0 {
+		b.line(2, "warmup_delay", time.Duration(fn.WarmupDelay).String())
+	}
+
+	keys := make([]string, 0, len(fn.Environment))
+	for key := range fn.Environment {
+		if !envVarNameRegex.MatchString(key) {
+			return fmt.Errorf("environment variable name '%s' cannot be expressed in a Caddyfile", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b.line(2, "env", key+"="+fn.Environment[key])
+	}
+	if len(fn.InheritEnv) > 0 {
+		b.line(2, append([]string{"inherit_env"}, fn.InheritEnv...)...)
+	}
+	if fn.InheritAllEnv {
+		b.line(2, "inherit_all_env")
+	}
+	if fn.MaxEnvValueLength > 0 {
+		b.line(2, "max_env_value_length", strconv.Itoa(fn.MaxEnvValueLength))
+	}
+	if fn.MaxEnvSize > 0 {
+		b.line(2, "max_env_size", strconv.Itoa(fn.MaxEnvSize))
+	}
+
+	for _, volume := range fn.Volumes {
+		spec, err := volumeSpec(volume)
+		if err != nil {
+			return err
+		}
+		b.line(2, "volume", spec)
+	}
+	for _, mount := range fn.FileMounts {
+		spec, err := volumeSpec(VolumeMount{Source: mount.HostPath, Target: mount.ContainerPath, ReadOnly: mount.ReadOnly})
+		if err != nil {
+			return err
+		}
+		b.line(2, "file_mount", spec, mount.SHA256)
+	}
+	if fn.RequestScratch != "" {
+		b.line(2, "request_scratch", fn.RequestScratch)
+	}
+
+	if fn.EnableHTTP2Push {
+		b.line(2, "enable_http2_push")
+	}
+	if transport := fn.Transport; transport != nil {
+		b.line(2, "transport", "{")
+		if transport.DialTimeout != 0 {
+			b.line(3, "dial_timeout", time.Duration(transport.DialTimeout).String())
+		}
+		if transport.TLSHandshakeTimeout != 0 {
+			b.line(3, "tls_handshake_timeout", time.Duration(transport.TLSHandshakeTimeout).String())
+		}
+		if transport.ResponseHeaderTimeout != 0 {
+			b.line(3, "response_header_timeout", time.Duration(transport.ResponseHeaderTimeout).String())
+		}
+		if transport.MaxIdleConns != 0 {
+			b.line(3, "max_idle_conns", strconv.Itoa(transport.MaxIdleConns))
+		}
+		b.line(2, "}")
+	}
+	if len(fn.Versions) > 0 {
+		b.line(2, "versions", "{")
+		for _, version := range fn.Versions {
+			b.line(3, version.Image, strconv.Itoa(version.Weight))
+		}
+		b.line(2, "}")
+	}
+	if fn.Isolation != "" {
+		b.line(2, "isolation", fn.Isolation)
+	}
+	if fallback := fn.FallbackResponse; fallback != nil {
+		b.line(2, "fallback_response", "{")
+		if fallback.StatusCode != 0 {
+			b.line(3, "status", strconv.Itoa(fallback.StatusCode))
+		}
+		names := make([]string, 0, len(fallback.Headers))
+		for name := range fallback.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range fallback.Headers[name] {
+				b.line(3, "header", name, value)
+			}
+		}
+		if fallback.Body != "" {
+			b.line(3, "body", fallback.Body)
+		}
+		b.line(2, "}")
+	}
+
+	if fn.Timeout != 0 {
+		b.line(2, "timeout", time.Duration(fn.Timeout).String())
+	}
+	if fn.ColdStartBudget != 0 {
+		b.line(2, "cold_start_budget", time.Duration(fn.ColdStartBudget).String())
+	}
+	if fn.Port != 0 {
+		b.line(2, "port", strconv.Itoa(fn.Port))
+	}
+	if fn.ReadyPort != 0 {
+		b.line(2, "ready_port", strconv.Itoa(fn.ReadyPort))
+	}
+	if fn.ReadyMaxAttempts != 0 {
+		b.line(2, "ready_max_attempts", strconv.Itoa(fn.ReadyMaxAttempts))
+	}
+	if fn.ReadyFailureRetries != 0 {
+		b.line(2, "ready_failure_retries", strconv.Itoa(fn.ReadyFailureRetries))
+	}
+	if fn.Memory != "" {
+		b.line(2, "memory", fn.Memory)
+	}
+	if fn.MemorySwap != "" {
+		b.line(2, "memory_swap", fn.MemorySwap)
+	}
+	if fn.OOMKillDisable {
+		b.line(2, "oom_kill_disable")
+	}
+	if fn.OOMScoreAdj != 0 {
+		b.line(2, "oom_score_adj", strconv.Itoa(fn.OOMScoreAdj))
+	}
+	if fn.CgroupParent != "" {
+		b.line(2, "cgroup_parent", fn.CgroupParent)
+	}
+	if fn.IPCMode != "" {
+		b.line(2, "ipc_mode", fn.IPCMode)
+	}
+	if fn.PIDMode != "" {
+		b.line(2, "pid_mode", fn.PIDMode)
+	}
+	if fn.UsernsMode != "" {
+		b.line(2, "userns_mode", fn.UsernsMode)
+	}
+	if len(fn.GroupAdd) > 0 {
+		b.line(2, append([]string{"group_add"}, fn.GroupAdd...)...)
+	}
+	if fn.Privileged {
+		b.line(2, "privileged")
+	}
+	if fn.SeccompProfile != "" {
+		b.line(2, "seccomp", fn.SeccompProfile)
+	}
+	if logConfig := fn.LogConfig; logConfig != nil {
+		b.line(2, "log_config", "{")
+		if logConfig.MaxSize != "" {
+			b.line(3, "max_size", logConfig.MaxSize)
+		}
+		if logConfig.MaxFile != 0 {
+			b.line(3, "max_file", strconv.Itoa(logConfig.MaxFile))
+		}
+		if logConfig.Compress {
+			b.line(3, "compress")
+		}
+		b.line(2, "}")
+	}
+	if fn.LogDriver != "" {
+		b.line(2, "log_driver", fn.LogDriver)
+	}
+	logOpts := make([]string, 0, len(fn.LogOpts))
+	for key := range fn.LogOpts {
+		logOpts = append(logOpts, key)
+	}
+	sort.Strings(logOpts)
+	for _, key := range logOpts {
+		b.line(2, "log_opt", key+"="+fn.LogOpts[key])
+	}
+	if fn.ErrorLogger != "" {
+		b.line(2, "error_logger", fn.ErrorLogger)
+	}
+	if fn.MaxBodySize != 0 {
+		b.line(2, "max_body_size", strconv.FormatInt(fn.MaxBodySize, 10))
+	}
+	if fn.MaxHeaderBytes != 0 {
+		b.line(2, "max_header_bytes", strconv.Itoa(fn.MaxHeaderBytes))
+	}
+	if fn.PrebufferRequest {
+		b.line(2, "prebuffer_request")
+	}
+	if fn.DisablePortCheck {
+		b.line(2, "disable_port_check")
+	}
+	if fn.UserAgent != "" {
+		b.line(2, "user_agent", fn.UserAgent)
+	}
+	if fn.TimingHeader != "" {
+		b.line(2, "timing_header", fn.TimingHeader)
+	}
+	if fn.DebugBodies {
+		if fn.DebugBodyLimit > 0 {
+			b.line(2, "debug_bodies", strconv.Itoa(fn.DebugBodyLimit))
+		} else {
+			b.line(2, "debug_bodies")
+		}
+	}
+	if len(fn.DebugRedact) > 0 {
+		b.line(2, append([]string{"debug_redact"}, fn.DebugRedact...)...)
+	}
+	if len(fn.AllowIPs) > 0 {
+		b.line(2, append([]string{"allow_ip"}, fn.AllowIPs...)...)
+	}
+	if len(fn.DenyIPs) > 0 {
+		b.line(2, append([]string{"deny_ip"}, fn.DenyIPs...)...)
+	}
+
+	codes := make([]int, 0, len(fn.StatusMap))
+	for from := range fn.StatusMap {
+		codes = append(codes, from)
+	}
+	sort.Ints(codes)
+	for _, from := range codes {
+		b.line(2, "status_map", strconv.Itoa(from), strconv.Itoa(fn.StatusMap[from]))
+	}
+	if fn.WebhookDedup != nil {
+		b.line(2, "webhook_dedup", fn.WebhookDedup.Header, time.Duration(fn.WebhookDedup.Window).String())
+	}
+
+	if fn.Timezone != "" {
+		if fn.MountLocaltime {
+			b.line(2, "timezone", fn.Timezone, "mount_localtime")
+		} else {
+			b.line(2, "timezone", fn.Timezone)
+		}
+	} else if fn.MountLocaltime {
+		return fmt.Errorf("mount_localtime requires timezone")
+	}
+	if fn.Locale != "" {
+		b.line(2, "locale", fn.Locale)
+	}
+	for _, constraint := range fn.PlacementConstraints {
+		b.line(2, "constraint", constraint)
+	}
+	if fn.ComposeFile != "" || fn.ComposeService != "" {
+		b.line(2, "compose", fn.ComposeFile, fn.ComposeService)
+	}
+
+	b.line(1, "}")
+	return nil
+}
+
+// volumeSpec formats a mount in the syntax read by parseVolumeSpec
+func volumeSpec(volume VolumeMount) (string, error) {
+	if strings.Contains(volume.Source, ":") || strings.Contains(volume.Target, ":") {
+		return "", fmt.Errorf("volume paths containing ':' cannot be expressed in a Caddyfile: %s:%s", volume.Source, volume.Target)
+	}
+	spec := volume.Source + ":" + volume.Target
+	if options := volume.options(); options != "" {
+		spec += ":" + options
+	}
+	return spec, nil
+}
+
+// quoteCaddyfileToken quotes token if the Caddyfile lexer would otherwise
+// split or reinterpret it
+func quoteCaddyfileToken(token string) string {
+	if token != "" && !strings.ContainsAny(token, " \t\r\n\"'`{}\\") &&
+		!strings.HasPrefix(token, "#") && !strings.HasPrefix(token, "<<") {
+		return token
+	}
+	// Everything between backticks is literal
+	if !strings.Contains(token, "`") {
+		return "`" + token + "`"
+	}
+	return `"` + strings.ReplaceAll(token, `"`, `\"`) + `"`
+}
+
+// parseVolumeSpec parses a volume specification in the format:
+// /host/path:/container/path[:options], where options are ro and a mount
+// propagation mode, separated by a comma or, as in /src:/dst:ro:rshared, by
+// a colon
+func parseVolumeSpec(spec string) (VolumeMount, error) {
+	parts := strings.Split(spec, ":")
+	const (
+		minVolumeSpecParts = 2
+		maxVolumeSpecParts = 4
+	)
+	if len(parts) < minVolumeSpecParts || len(parts) > maxVolumeSpecParts {
+		return VolumeMount{}, fmt.Errorf("invalid volume format (expected /host/path:/container/path[:ro][:propagation])")
+	}
+
+	if parts[0] == "" || parts[1] == "" {
+		return VolumeMount{}, fmt.Errorf("invalid volume format: host and container paths are required")
+	}
+
+	volume := VolumeMount{
+		Source: parts[0],
+		Target: parts[1],
+	}
+
+	if len(parts) > minVolumeSpecParts {
+		for _, option := range strings.Split(strings.Join(parts[2:], ","), ",") {
+			switch {
+			case option == "ro" && !volume.ReadOnly:
+				volume.ReadOnly = true
+			case mountPropagations[option] && volume.Propagation == "":
+				volume.Propagation = option
+			default:
+				return VolumeMount{}, fmt.Errorf("invalid volume option '%s' (expected 'ro' and a mount propagation mode)", option)
+			}
 		}
 	}
 
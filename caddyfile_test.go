@@ -0,0 +1,784 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestUnmarshalCaddyfile_InlineScript(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	d := caddyfile.NewTestDispenser(`serverless {
+		function {
+			path /script
+			methods GET
+			image alpine:latest
+			port 8080
+			inline_script <<SCRIPT
+				echo "starting"
+				exec httpd -f -p 8080
+				SCRIPT
+		}
+	}`)
+
+	var h Handler
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	want := "echo \"starting\"\nexec httpd -f -p 8080"
+	if h.Functions[0].InlineScript != want {
+		t.Errorf("script = %q, want %q", h.Functions[0].InlineScript, want)
+	}
+	// Adapting a Caddyfile leaves no files behind
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("expected no files to be written while parsing, got %d", len(entries))
+	}
+
+	// Each provisioned handler writes its own file, so cleaning up the
+	// handler of the previous configuration leaves the new one's in place
+	provisioned := func() *Handler {
+		handler, err := newTestHandler(t, h.Functions, nil, nil)
+		if err != nil {
+			t.Fatalf("newTestHandler failed: %v", err)
+		}
+		return handler
+	}
+	old, current := provisioned(), provisioned()
+	fn := &current.Functions[0]
+
+	content, err := os.ReadFile(fn.inlineScript.Source)
+	if err != nil {
+		t.Fatalf("reading inline script: %v", err)
+	}
+	if string(content) != want {
+		t.Errorf("script content = %q, want %q", content, want)
+	}
+	command := containerCommand(fn)
+	if len(command) != 2 || command[0] != "/bin/sh" {
+		t.Fatalf("command = %v, want [/bin/sh /tmp/<hash>.sh]", command)
+	}
+	target := command[1]
+	if !strings.HasPrefix(target, "/tmp/") || !strings.HasSuffix(target, ".sh") {
+		t.Errorf("script target = %q, want /tmp/<hash>.sh", target)
+	}
+	volumes, err := containerVolumes(fn)
+	if err != nil {
+		t.Fatalf("containerVolumes failed: %v", err)
+	}
+	wantVolume := VolumeMount{Source: fn.inlineScript.Source, Target: target, ReadOnly: true}
+	if len(volumes) != 1 || volumes[0] != wantVolume {
+		t.Errorf("volumes = %+v, want [%+v]", volumes, wantVolume)
+	}
+
+	if err := old.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(old.Functions[0].inlineScript.Source); !os.IsNotExist(err) {
+		t.Errorf("inline script file still exists after Cleanup: %v", err)
+	}
+	if _, err := os.Stat(fn.inlineScript.Source); err != nil {
+		t.Errorf("expected the current handler's script to be kept: %v", err)
+	}
+
+	h.Functions[0].Command = []string{"/app"}
+	if err := h.Validate(); err == nil || !strings.Contains(err.Error(), "inline_script") {
+		t.Errorf("expected inline_script and command to be rejected together, got %v", err)
+	}
+
+	// Swarm tasks may run on a node without the script file
+	h.Functions[0].Command = nil
+	h.UseSwarm = true
+	if err := h.Validate(); err == nil || !strings.Contains(err.Error(), "functions[0].inline_script: not supported for swarm services") {
+		t.Errorf("expected inline_script to be rejected with use_swarm, got %v", err)
+	}
+}
+
+func TestUnmarshalCaddyfile_TimezoneLocale(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`serverless {
+		function {
+			path /tz
+			image alpine:latest
+			timezone Europe/Berlin mount_localtime
+			locale de_DE.UTF-8
+		}
+	}`)
+
+	var h Handler
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	fn := h.Functions[0]
+	if fn.Timezone != "Europe/Berlin" || !fn.MountLocaltime {
+		t.Errorf("expected timezone Europe/Berlin with localtime mount, got %q (mount=%v)", fn.Timezone, fn.MountLocaltime)
+	}
+	if fn.Locale != "de_DE.UTF-8" {
+		t.Errorf("expected locale de_DE.UTF-8, got %q", fn.Locale)
+	}
+
+	volumes, err := containerVolumes(&fn)
+	if err != nil {
+		t.Fatalf("containerVolumes failed: %v", err)
+	}
+	want := VolumeMount{Source: "/usr/share/zoneinfo/Europe/Berlin", Target: "/etc/localtime", ReadOnly: true}
+	if len(volumes) != 1 || volumes[0] != want {
+		t.Errorf("expected volumes [%+v], got %+v", want, volumes)
+	}
+
+	d = caddyfile.NewTestDispenser(`serverless {
+		function {
+			path /tz
+			image alpine:latest
+			timezone Europe/Berlin bogus
+		}
+	}`)
+	if err := new(Handler).UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected error for unknown timezone option")
+	}
+}
+
+func TestUnmarshalCaddyfile_AutoOptions(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    bool
+		expectError bool
+	}{
+		{value: "on", expected: true},
+		{value: "off", expected: false},
+		{value: "maybe", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			d := caddyfile.NewTestDispenser(`serverless {
+				function {
+					methods GET
+					path /cors
+					image alpine:latest
+					auto_options ` + tt.value + `
+				}
+			}`)
+
+			var h Handler
+			err := h.UnmarshalCaddyfile(d)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error for invalid auto_options value")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+			}
+			autoOptions := h.Functions[0].AutoOptions
+			if autoOptions == nil || *autoOptions != tt.expected {
+				t.Errorf("expected auto_options %v, got %v", tt.expected, autoOptions)
+			}
+		})
+	}
+}
+
+// FuzzUnmarshalCaddyfile checks that the parser returns errors rather than
+// panicking on arbitrary input.
+func FuzzUnmarshalCaddyfile(f *testing.F) {
+	seeds := []string{
+		// Known-good configurations
+		`serverless {
+			function {
+				path /script
+				image alpine:latest
+				port 8080
+				inline_script <<SCRIPT
+					echo "starting"
+					exec httpd -f -p 8080
+					SCRIPT
+			}
+		}`,
+		`serverless {
+			function {
+				path /tz
+				image alpine:latest
+				timezone Europe/Berlin mount_localtime
+				locale de_DE.UTF-8
+			}
+		}`,
+		`serverless {
+			no_match 404 "not found"
+			method_not_allowed
+			timeline_buffer_size 100
+			use_swarm
+			backend kubernetes "{\"namespace\": \"functions\"}"
+			event_webhook https://hooks.example.com/serverless
+			debug
+			default_namespace staging
+			merge_slashes
+			trim_trailing_slash
+			case_insensitive_paths
+			duplicate_functions error
+			auto_prune_images
+			allow_privileged
+			max_containers 20
+			max_total_memory 4g
+			global_env LOG_LEVEL=info
+			function {
+				name api
+				methods GET POST
+				content_type multipart/* application/json
+				auto_options off
+				path /api/.*
+				image nginx:latest
+				versions {
+					app:v1 90
+					app:v2 10
+				}
+				namespace production
+				command /bin/sh -c "echo hello"
+				append_args --verbose
+				post_start /app/init.sh
+				post_start_timeout 10s
+				warmup_delay 200ms
+				env KEY=value
+				inherit_env HOME PATH
+				inherit_all_env
+				max_env_value_length 65536
+				max_env_size 1048576
+				volume /host/path:/container/path:ro
+				request_scratch /scratch
+				file_mount /host/app.conf:/etc/app.conf:ro 0000000000000000000000000000000000000000000000000000000000000000
+				isolation per-request
+				enable_http2_push
+				transport {
+					dial_timeout 1s
+					tls_handshake_timeout 2s
+					response_header_timeout 5s
+					max_idle_conns 10
+				}
+				timeout 30s
+				cold_start_budget 2s
+				port 8080
+				ready_port 9090
+				ready_max_attempts 10
+				ready_failure_retries 2
+				memory 256m
+				memory_swap 512m
+				oom_kill_disable
+				oom_score_adj -500
+				cgroup_parent /function/payments
+				ipc_mode shareable
+				pid_mode host
+				userns_mode keep-id
+				group_add video 44
+				privileged
+				seccomp unconfined
+				log_config {
+					max_size 10m
+					max_file 3
+					compress
+				}
+				log_driver json-file
+				log_opt tag=fn
+				error_logger payments.errors
+				max_body_size 1048576
+				max_header_bytes 8192
+				prebuffer_request
+				disable_port_check
+				user_agent my-agent/1.0
+				timing_header Server-Timing
+				debug_bodies 512
+				debug_redact X-Api-Key password
+				allow_ip 10.0.0.0/8 192.168.1.10
+				deny_ip 10.0.0.5
+				status_map 418 200
+				webhook_dedup X-Webhook-ID 5m
+				constraint node.labels.region==us-east
+				compose /srv/app/docker-compose.yml web
+			}
+		}`,
+		`serverless {
+			function {
+				path /fallback
+				image alpine:latest
+				fallback_response {
+					status 503
+					header Retry-After 30
+					body "temporarily unavailable"
+				}
+			}
+		}`,
+		// Known-bad configurations
+		`serverless { function { path /x } }`,
+		`serverless { function { image x } }`,
+		`serverless { function { path /x image x env NOEQUALS } }`,
+		`serverless { function { path /x image x port 99999 } }`,
+		`serverless { function { path /x image x ready_port 0 } }`,
+		`serverless { function { path /x image x ready_max_attempts 0 } }`,
+		`serverless { function { path /x image x ready_failure_retries -1 } }`,
+		`serverless { function { path /x image x auto_options maybe } }`,
+		`serverless { function { path /x image x content_type } }`,
+		`serverless { function { path /x image x max_env_size 0 } }`,
+		`serverless { function { path /x image x max_header_bytes 0 } }`,
+		`serverless { function { path /x image x debug_bodies -1 } }`,
+		`serverless { function { path /x image x allow_ip } }`,
+		`serverless { function { path /x image x log_config { max_file 0 } } }`,
+		`serverless { function { path /x image x log_driver splunky } }`,
+		`serverless { function { path /x image x log_opt tag } }`,
+		`serverless { function { path /x image x error_logger a..b } }`,
+		`serverless { function { path /x image x seccomp } }`,
+		`serverless { function { path /x image x request_scratch } }`,
+		`serverless { function { path /x image x oom_score_adj 1001 } }`,
+		`serverless { function { path /x image x cgroup_parent } }`,
+		`serverless { function { path /x image x ipc_mode private host } }`,
+		`serverless { function { path /x image x pid_mode } }`,
+		`serverless { function { path /x image x userns_mode host keep-id } }`,
+		`serverless { function { path /x image x timing_header } }`,
+		`serverless { function { path /x image x transport { dial_timeout } } }`,
+		`serverless { function { path /x image x timeout forever } }`,
+		`serverless { function { path /x image x status_map 418 } }`,
+		`serverless { function { path /x image x bogus } }`,
+		`serverless { no_match }`,
+		`serverless { debug on }`,
+		`serverless { default_namespace }`,
+		`serverless { auto_prune_images yes }`,
+		`serverless { allow_privileged yes }`,
+		`serverless { max_containers 0 }`,
+		`serverless { function { path /x image x warmup_delay -1s } }`,
+		`serverless { global_env LOG_LEVEL }`,
+		`serverless { global_env 1X=y }`,
+		`serverless { function { path /x versions { app:v1 0 } } }`,
+		`serverless { function { path /x versions { app:v1 } } }`,
+		`serverless { max_total_memory lots }`,
+		`serverless { function { path /x image x group_add } }`,
+		`serverless { function { path /x image x privileged on } }`,
+		`serverless { merge_slashes on }`,
+		`serverless { method_not_allowed on }`,
+		`serverless { backend }`,
+		`serverless { backend kubernetes {namespace} }`,
+		`serverless { duplicate_functions ignore }`,
+		`serverless { function { name } }`,
+		`serverless { function { path /x image x enable_http2_push on } }`,
+		`serverless { function { path /x image x namespace a b } }`,
+		`serverless {
+			function {
+				function {
+					function { path /x image x }
+				}
+			}
+		}`,
+		`serverless { function { path /x image x env KEY=` + "\x00\xff\xfe" + ` } }`,
+		`serverless { function { path /` + "\xc3\x28" + ` image ` + "\xa0\xa1" + ` } }`,
+		`serverless { function { path /x image ` + strings.Repeat("a", 1<<16) + ` } }`,
+		`serverless { function { path /x image x inline_script <<EOF`,
+		`serverless {`,
+		`}`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tokens, err := caddyfile.Tokenize([]byte(input), "Caddyfile")
+		if err != nil {
+			return
+		}
+		var h Handler
+		_ = h.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens))
+		// Remove any inline script files written while parsing
+		_ = h.Cleanup()
+	})
+}
+
+// FuzzParseVolumeSpec checks that parseVolumeSpec never panics and only
+// returns a mount, with both paths set, when it reports no error.
+func FuzzParseVolumeSpec(f *testing.F) {
+	seeds := []string{
+		"/src:/dst",
+		"/src:/dst:ro",
+		"/host/path:/container/path",
+		"/src:/dst:rw",
+		"/src:/dst:ro:extra",
+		"/src:/dst:ro,rshared",
+		"/src:/dst:ro:rshared",
+		"/src:/dst:rslave",
+		"/src:/dst:ro,ro",
+		"/src:/dst:shared,slave",
+		"/src:/dst:ro,",
+		"/src",
+		":/dst",
+		"/src:",
+		":",
+		"::",
+		"",
+		`C:\data:/data`,
+		`C:\data:C:\data:ro`,
+		"/path:with:colons:/dst",
+		"/src:/dst:" + "\xff\xfe",
+		strings.Repeat("/a", 1024) + ":/dst",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, spec string) {
+		volume, err := parseVolumeSpec(spec)
+		if err != nil {
+			if volume != (VolumeMount{}) {
+				t.Errorf("parseVolumeSpec(%q) returned %+v along with error %v", spec, volume, err)
+			}
+			return
+		}
+		if volume.Source == "" {
+			t.Errorf("parseVolumeSpec(%q) returned an empty source", spec)
+		}
+		if volume.Target == "" {
+			t.Errorf("parseVolumeSpec(%q) returned an empty target", spec)
+		}
+	})
+}
+
+func TestParseVolumeSpec_Propagation(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    VolumeMount
+		wantErr bool
+	}{
+		{spec: "/src:/dst:rshared", want: VolumeMount{Source: "/src", Target: "/dst", Propagation: "rshared"}},
+		{spec: "/src:/dst:ro,rslave", want: VolumeMount{Source: "/src", Target: "/dst", ReadOnly: true, Propagation: "rslave"}},
+		{spec: "/src:/dst:private,ro", want: VolumeMount{Source: "/src", Target: "/dst", ReadOnly: true, Propagation: "private"}},
+		{spec: "/src:/dst:shared,slave", wantErr: true},
+		{spec: "/src:/dst:ro,ro", wantErr: true},
+		{spec: "/src:/dst:ro:rshared", want: VolumeMount{Source: "/src", Target: "/dst", ReadOnly: true, Propagation: "rshared"}},
+		{spec: "/src:/dst:ro:", wantErr: true},
+		{spec: "/src:/dst:ro:rshared:extra", wantErr: true},
+		{spec: "/src:/dst:rshared,bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVolumeSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	// The four-part form reaches docker in its own syntax
+	volume, err := parseVolumeSpec("/mnt/fuse:/fuse:ro:rshared")
+	if err != nil {
+		t.Fatalf("failed to parse four-part spec: %v", err)
+	}
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "x", Volumes: []VolumeMount{volume}}), " ")
+	if expected := "-v /mnt/fuse:/fuse:ro,rshared"; !strings.Contains(args, expected) {
+		t.Errorf("expected args to contain %q, got: %s", expected, args)
+	}
+
+	d := caddyfile.NewTestDispenser(`serverless {
+		function {
+			path /x
+			image x
+			file_mount /etc/app.conf:/etc/app.conf:ro,rshared abc123
+		}
+	}`)
+	var h Handler
+	if err := h.UnmarshalCaddyfile(d); err == nil {
+		t.Error("expected mount propagation to be rejected for a file_mount")
+	}
+}
+
+func TestUnmarshalCaddyfile_FallbackResponse(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`serverless {
+		function {
+			path /fallback
+			image alpine:latest
+			fallback_response {
+				status 503
+				header Retry-After 30
+				body "temporarily unavailable"
+			}
+			port 8080
+		}
+	}`)
+
+	var h Handler
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	fn := h.Functions[0]
+	fb := fn.FallbackResponse
+	if fb == nil {
+		t.Fatal("expected fallback response")
+	}
+	if fb.StatusCode != 503 || fb.Body != "temporarily unavailable" || fb.Headers.Get("Retry-After") != "30" {
+		t.Errorf("unexpected fallback response: %+v", fb)
+	}
+	if fn.Port != 8080 {
+		t.Errorf("expected parsing to continue after the block, got port %d", fn.Port)
+	}
+}
+
+func TestMarshalCaddyfile_RoundTrip(t *testing.T) {
+	// Every field that both the JSON and the Caddyfile formats support
+	config := `{
+		"no_match_status": 404,
+		"no_match_body": "{\"error\": \"not found\"}",
+		"method_not_allowed": true,
+		"timeline_buffer_size": 50,
+		"use_swarm": true,
+		"backend_type": "kubernetes",
+		"backend_config": {"namespace": "functions", "labels": {"team": "api"}},
+		"event_webhook": "https://hooks.example.com/serverless?source=caddy",
+		"debug": true,
+		"default_namespace": "staging",
+		"merge_slashes": true,
+		"trim_trailing_slash": true,
+		"case_insensitive_paths": true,
+		"duplicate_functions": "warn",
+		"auto_prune_images": true,
+		"allow_privileged": true,
+		"max_containers": 20,
+		"max_total_memory": "4g",
+		"global_environment": {"LOG_LEVEL": "info", "OTEL_ENDPOINT": "http://otel:4317"},
+		"functions": [
+			{
+				"name": "users",
+				"methods": ["GET", "POST"],
+				"match_content_type": ["multipart/*", "application/json"],
+				"auto_options": false,
+				"path": "^/api/users/{id}$",
+				"image": "users:latest",
+				"namespace": "production",
+				"command": ["/bin/sh", "-c", "echo \"hello world\" && exec server"],
+				"append_args": ["--verbose", "--name=a b"],
+				"post_start_command": ["/app/init.sh", "--quiet"],
+				"post_start_timeout": "10s",
+				"warmup_delay": "200ms",
+				"environment": {"GREETING": "hello world", "EMPTY": "", "QUOTED": "say \"hi\"", "MULTILINE": "a\nb", "TICK": "` + "`" + `x` + "`" + `"},
+				"inherit_env": ["HOME", "PATH"],
+				"inherit_all_env": true,
+				"max_env_value_length": 4096,
+				"max_env_size": 65536,
+				"volumes": [
+					{"source": "/srv/data", "target": "/data", "propagation": "rshared"},
+					{"source": "/srv/My Files", "target": "/files", "read_only": true}
+				],
+				"file_mounts": [
+					{"host_path": "/etc/app.conf", "container_path": "/etc/app.conf", "sha256": "abc123", "read_only": true}
+				],
+				"request_scratch": "/scratch",
+				"isolation": "per-request",
+				"enable_http2_push": true,
+				"transport": {
+					"dial_timeout": "500ms",
+					"tls_handshake_timeout": "2s",
+					"response_header_timeout": "5s",
+					"max_idle_conns": 10
+				},
+				"fallback_response": {
+					"status_code": 503,
+					"headers": {"Retry-After": ["30"], "X-Reason": ["maintenance", "upgrade"]},
+					"body": "{\"error\": \"unavailable\"}"
+				},
+				"timeout": "1m30s",
+				"cold_start_budget": "2s",
+				"port": 9000,
+				"ready_port": 9090,
+				"ready_max_attempts": 10,
+				"ready_failure_retries": 2,
+				"memory": "256m",
+				"memory_swap": "-1",
+				"oom_kill_disable": true,
+				"oom_score_adj": -500,
+				"cgroup_parent": "payments.slice",
+				"ipc_mode": "container:shm-sidecar",
+				"pid_mode": "private",
+				"userns_mode": "host",
+				"group_add": ["video", "44"],
+				"privileged": true,
+				"seccomp_profile": "/etc/caddy/seccomp.json",
+				"log_config": {"max_size": "20m", "max_file": 5, "compress": true},
+				"log_driver": "local",
+				"log_opts": {"mode": "non-blocking", "tag": "fn {{.Name}}"},
+				"error_logger": "payments",
+				"max_body_size": 1048576,
+				"max_header_bytes": 8192,
+				"prebuffer_request": true,
+				"disable_port_check": true,
+				"user_agent": "my-agent/1.0 (serverless)",
+				"timing_header": "X-Upstream-Time",
+				"debug_bodies": true,
+				"debug_body_limit": 512,
+				"debug_redact": ["X-Api-Key", "password"],
+				"allow_ips": ["10.0.0.0/8", "::1"],
+				"deny_ips": ["10.0.0.5"],
+				"status_map": {"418": 200, "503": 502},
+				"webhook_dedup": {"header": "X-Webhook-ID", "window": "5m"},
+				"timezone": "Europe/Berlin",
+				"mount_localtime": true,
+				"locale": "de_DE.UTF-8",
+				"placement_constraints": ["node.labels.region==us-east", "node.role == worker"]
+			},
+			{
+				"methods": ["PUT"],
+				"auto_options": true,
+				"path": "/app",
+				"compose_file": "/srv/app/docker-compose.yml",
+				"compose_service": "web"
+			},
+			{
+				"methods": ["GET"],
+				"auto_options": true,
+				"path": "/canary",
+				"versions": [{"image": "app:v1", "weight": 90}, {"image": "app:v2", "weight": 10}],
+				"inline_script": "echo \"starting\"\nexec app"
+			}
+		]
+	}`
+
+	var original Handler
+	if err := json.Unmarshal([]byte(config), &original); err != nil {
+		t.Fatalf("failed to unmarshal JSON: %v", err)
+	}
+	caddyfileText, err := original.MarshalCaddyfile()
+	if err != nil {
+		t.Fatalf("MarshalCaddyfile failed: %v", err)
+	}
+
+	var parsed Handler
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser(string(caddyfileText))); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v\n%s", err, caddyfileText)
+	}
+
+	want, _ := json.Marshal(original)
+	got, _ := json.Marshal(parsed)
+	if string(got) != string(want) {
+		t.Errorf("round trip changed the configuration\nwant: %s\ngot:  %s\nCaddyfile:\n%s", want, got, caddyfileText)
+	}
+
+	if !strings.HasSuffix(string(caddyfileText), "\t}\n}\n") {
+		t.Errorf("expected blocks to be closed with bare braces, got:\n%s", caddyfileText)
+	}
+
+	// Marshaling again yields the same Caddyfile
+	again, err := parsed.MarshalCaddyfile()
+	if err != nil {
+		t.Fatalf("second MarshalCaddyfile failed: %v", err)
+	}
+	if string(again) != string(caddyfileText) {
+		t.Errorf("expected stable output, got:\n%s\nthen:\n%s", caddyfileText, again)
+	}
+}
+
+func TestMarshalCaddyfile_Unrepresentable(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+	}{
+		{
+			name:    "no_match body without status",
+			handler: Handler{NoMatchBody: "not found"},
+		},
+		{
+			name: "invalid environment variable name",
+			handler: Handler{Functions: []FunctionConfig{
+				{Path: "/x", Image: "x", Environment: map[string]string{"MY-VAR": "1"}},
+			}},
+		},
+		{
+			name:    "default function config",
+			handler: Handler{DefaultFunctionConfig: &FunctionConfig{Memory: "256m"}},
+		},
+		{
+			name: "volume path with colon",
+			handler: Handler{Functions: []FunctionConfig{
+				{Path: "/x", Image: "x", Volumes: []VolumeMount{{Source: "C:/data", Target: "/data"}}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.handler.MarshalCaddyfile(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestUnmarshalCaddyfile_Import(t *testing.T) {
+	// Function templates can live in snippets or in imported files
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "functions.caddy")
+	template := "function {\n\tmethods GET\n\tpath /api/{args[0]}\n\timage {args[1]}\n\timport limits\n}\n"
+	if err := os.WriteFile(templateFile, []byte(template), 0o644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	input := `(limits) {
+	timeout 10s
+	memory 128m
+}
+
+:8080 {
+	serverless {
+		import ` + templateFile + ` users users:latest
+		import ` + templateFile + ` orders orders:latest
+		function {
+			methods POST
+			path /hooks
+			image hooks:latest
+			import limits
+		}
+	}
+}`
+	blocks, err := caddyfile.Parse(filepath.Join(dir, "Caddyfile"), []byte(input))
+	if err != nil {
+		t.Fatalf("failed to parse Caddyfile: %v", err)
+	}
+	if len(blocks) != 1 || len(blocks[0].Segments) != 1 {
+		t.Fatalf("expected one site block with one directive, got %+v", blocks)
+	}
+
+	var h Handler
+	if err := h.UnmarshalCaddyfile(caddyfile.NewDispenser(blocks[0].Segments[0])); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+
+	expected := []struct{ path, image string }{
+		{"/api/users", "users:latest"},
+		{"/api/orders", "orders:latest"},
+		{"/hooks", "hooks:latest"},
+	}
+	if len(h.Functions) != len(expected) {
+		t.Fatalf("expected %d functions, got %d", len(expected), len(h.Functions))
+	}
+	for i, want := range expected {
+		fn := h.Functions[i]
+		if fn.Path != want.path || fn.Image != want.image {
+			t.Errorf("function %d: expected %s %s, got %s %s", i, want.path, want.image, fn.Path, fn.Image)
+		}
+		if time.Duration(fn.Timeout) != 10*time.Second || fn.Memory != "128m" {
+			t.Errorf("function %d: expected imported limits, got timeout %v memory %q", i, time.Duration(fn.Timeout), fn.Memory)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// composeDownTimeout bounds tearing down a project, which must run even when
+// the request that started it has already been cancelled
+const composeDownTimeout = 30 * time.Second
+
+// commandRunner runs a docker command and returns its combined output
+type commandRunner func(ctx context.Context, args ...string) ([]byte, error)
+
+// runDocker runs the docker CLI with the given arguments
+func runDocker(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+}
+
+// composeProject is a running docker compose project started for a function
+type composeProject struct {
+	file    string
+	service string
+
+	// container is set once the project is up
+	container *Container
+}
+
+// ComposeContainerManager runs functions made of several containers (e.g. app + sidecar)
+// using docker compose. Each start brings up an isolated compose project, and requests
+// are proxied to the host port published for the configured service.
+type ComposeContainerManager struct {
+	projects map[string]composeProject
+	logger   *zap.Logger
+	mutex    sync.RWMutex
+	run      commandRunner
+
+	// containerManager is used for the TCP readiness probe
+	containerManager *ContainerManager
+}
+
+// NewComposeContainerManager creates a new compose project manager
+func NewComposeContainerManager(logger *zap.Logger) *ComposeContainerManager {
+	return &ComposeContainerManager{
+		projects:         make(map[string]composeProject),
+		logger:           logger,
+		run:              runDocker,
+		containerManager: NewContainerManager(logger),
+	}
+}
+
+// composeArgs returns the docker compose arguments selecting a project and file
+func composeArgs(project, file string, args ...string) []string {
+	return append([]string{"compose", "--project-name", project, "--file", file}, args...)
+}
+
+// composeUnsupportedOptions returns the fields set on fn that only apply to
+// containers started with docker run. A compose project is configured by its
+// compose file, so they would be silently ignored.
+func composeUnsupportedOptions(fn FunctionConfig) []string {
+	set := []struct {
+		field string
+		isSet bool
+	}{
+		{"command", len(fn.Command) > 0},
+		{"append_args", len(fn.AppendArgs) > 0},
+		{"environment", len(fn.Environment) > 0},
+		{"inherit_env", len(fn.InheritEnv) > 0},
+		{"inherit_all_env", fn.InheritAllEnv},
+		{"volumes", len(fn.Volumes) > 0},
+		{"request_scratch", fn.RequestScratch != ""},
+		{"file_mounts", len(fn.FileMounts) > 0},
+		{"inline_script", fn.InlineScript != ""},
+		{"timezone", fn.Timezone != ""},
+		{"mount_localtime", fn.MountLocaltime},
+		{"locale", fn.Locale != ""},
+		{"memory", fn.Memory != ""},
+		{"memory_swap", fn.MemorySwap != ""},
+		{"oom_kill_disable", fn.OOMKillDisable},
+		{"oom_score_adj", fn.OOMScoreAdj != 0},
+		{"cgroup_parent", fn.CgroupParent != ""},
+		{"ipc_mode", fn.IPCMode != ""},
+		{"pid_mode", fn.PIDMode != ""},
+		{"userns_mode", fn.UsernsMode != ""},
+		{"group_add", len(fn.GroupAdd) > 0},
+		{"privileged", fn.Privileged},
+		{"seccomp_profile", fn.SeccompProfile != ""},
+		{"log_config", fn.LogConfig != nil},
+		{"log_driver", fn.LogDriver != ""},
+		{"log_opts", len(fn.LogOpts) > 0},
+		{"placement_constraints", len(fn.PlacementConstraints) > 0},
+	}
+	var fields []string
+	for _, option := range set {
+		if option.isSet {
+			fields = append(fields, option.field)
+		}
+	}
+	return fields
+}
+
+// composeProjectName returns a unique project name, prefixed with the
+// namespace if there is one. Compose only accepts lowercase letters, digits,
+// dashes and underscores.
+func composeProjectName(namespace string) string {
+	if namespace == "" {
+		return "serverless-" + generateRequestID()
+	}
+	prefix := strings.ReplaceAll(strings.ToLower(namespace), ".", "-")
+	return "serverless-" + prefix + "-" + generateRequestID()
+}
+
+// StartContainer brings up a new compose project and resolves the published port of its service
+func (cm *ComposeContainerManager) StartContainer(ctx context.Context, config ContainerConfig) (*Container, error) {
+	if strings.TrimSpace(config.ComposeFile) == "" {
+		return nil, fmt.Errorf("invalid container configuration: compose file is required")
+	}
+	if strings.TrimSpace(config.ComposeService) == "" {
+		return nil, fmt.Errorf("invalid container configuration: compose service is required")
+	}
+
+	project := composeProjectName(config.Namespace)
+	args := composeArgs(project, config.ComposeFile, "up", "--detach")
+	cm.logger.Debug("starting compose project", zap.Strings("args", args))
+
+	// Track the project before starting it so a partial start is still cleaned up
+	cm.mutex.Lock()
+	cm.projects[project] = composeProject{file: config.ComposeFile, service: config.ComposeService}
+	cm.mutex.Unlock()
+
+	if output, err := cm.run(ctx, args...); err != nil {
+		cm.stopAfterFailure(ctx, project)
+		return nil, fmt.Errorf("failed to start compose project: %v (output: %s)", err, string(output))
+	}
+
+	port, err := cm.publishedPort(ctx, project, config)
+	if err != nil {
+		cm.stopAfterFailure(ctx, project)
+		return nil, err
+	}
+
+	cm.logger.Debug("compose project started",
+		zap.String("project", project),
+		zap.String("service", config.ComposeService),
+		zap.Int("port", port))
+
+	container := &Container{
+		ID:        project,
+		IP:        "127.0.0.1",
+		Port:      port,
+		StartedAt: time.Now(),
+	}
+	cm.mutex.Lock()
+	if p, ok := cm.projects[project]; ok {
+		p.container = container
+		cm.projects[project] = p
+	}
+	cm.mutex.Unlock()
+	return container, nil
+}
+
+// publishedPort returns the host port published for the service's container port
+func (cm *ComposeContainerManager) publishedPort(ctx context.Context, project string, config ContainerConfig) (int, error) {
+	output, err := cm.run(ctx, composeArgs(project, config.ComposeFile, "port", config.ComposeService, strconv.Itoa(config.Port))...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve published port for service %s: %v (output: %s)", config.ComposeService, err, string(output))
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	_, portStr, err := net.SplitHostPort(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse published port for service %s: %v", config.ComposeService, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("service %s does not publish port %d", config.ComposeService, config.Port)
+	}
+	return port, nil
+}
+
+// stopAfterFailure tears down a project that failed to start, logging any error.
+// The start often failed because ctx was cancelled, so the teardown detaches
+// from its cancellation and gets its own deadline instead.
+func (cm *ComposeContainerManager) stopAfterFailure(ctx context.Context, project string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), composeDownTimeout)
+	defer cancel()
+	if err := cm.StopContainer(ctx, project); err != nil {
+		cm.logger.Error("failed to stop compose project after start failure", zap.String("project", project), zap.Error(err))
+	}
+}
+
+// WaitForReady waits for the service's published port to accept connections
+func (cm *ComposeContainerManager) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, _ int, maxAttempts int) error {
+	return cm.containerManager.WaitForReady(ctx, container, timeout, container.Port, maxAttempts)
+}
+
+// StopContainer tears down a compose project, removing its containers, networks and volumes.
+// The project stays tracked until docker compose down succeeds, so a failed
+// teardown is retried by Cleanup rather than leaked.
+func (cm *ComposeContainerManager) StopContainer(ctx context.Context, project string) error {
+	cm.mutex.RLock()
+	p, ok := cm.projects[project]
+	cm.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown compose project %s", project)
+	}
+
+	if err := cm.down(ctx, project, p.file); err != nil {
+		return err
+	}
+
+	cm.mutex.Lock()
+	if p, ok := cm.projects[project]; ok && p.container != nil {
+		p.container.markStopped()
+	}
+	delete(cm.projects, project)
+	cm.mutex.Unlock()
+	return nil
+}
+
+// down runs docker compose down for a project
+func (cm *ComposeContainerManager) down(ctx context.Context, project, file string) error {
+	cm.logger.Debug("stopping compose project", zap.String("project", project))
+
+	if output, err := cm.run(ctx, composeArgs(project, file, "down", "--volumes", "--remove-orphans")...); err != nil {
+		return fmt.Errorf("failed to stop compose project: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+// ExecInContainer runs a command in the project's service container with
+// docker compose exec and waits for it to exit.
+func (cm *ComposeContainerManager) ExecInContainer(ctx context.Context, project string, command []string) (string, string, int, error) {
+	cm.mutex.RLock()
+	p, ok := cm.projects[project]
+	cm.mutex.RUnlock()
+	if !ok {
+		return "", "", -1, fmt.Errorf("unknown compose project %s", project)
+	}
+
+	args := composeArgs(project, p.file, append([]string{"exec", "-T", p.service}, command...)...)
+	return runCommand(ctx, "docker", args...)
+}
+
+// GetContainerLogs returns the recent output of the project's service
+// container with docker compose logs, which reports both streams as stdout
+func (cm *ComposeContainerManager) GetContainerLogs(ctx context.Context, project string, since time.Duration, tail int) ([]LogEntry, error) {
+	cm.mutex.RLock()
+	p, ok := cm.projects[project]
+	cm.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown compose project %s", project)
+	}
+
+	args := append([]string{"logs", "--no-color", "--no-log-prefix"}, logsArgs(since, tail)...)
+	return runLogsCommand(ctx, composeArgs(project, p.file, append(args, p.service)...)...)
+}
+
+// PauseContainer freezes the project's containers with docker compose pause
+func (cm *ComposeContainerManager) PauseContainer(ctx context.Context, project string) error {
+	return cm.pauseOrUnpause(ctx, project, "pause")
+}
+
+// ResumeContainer thaws the project's containers with docker compose unpause
+func (cm *ComposeContainerManager) ResumeContainer(ctx context.Context, project string) error {
+	return cm.pauseOrUnpause(ctx, project, "unpause")
+}
+
+// pauseOrUnpause runs docker compose pause or unpause for a project
+func (cm *ComposeContainerManager) pauseOrUnpause(ctx context.Context, project, command string) error {
+	cm.mutex.RLock()
+	p, ok := cm.projects[project]
+	cm.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown compose project %s", project)
+	}
+
+	cm.logger.Debug("running compose "+command, zap.String("project", project))
+	if output, err := cm.run(ctx, composeArgs(project, p.file, command)...); err != nil {
+		return fmt.Errorf("failed to %s compose project: %v (output: %s)", command, err, string(output))
+	}
+	return nil
+}
+
+// ContainerRunning reports whether the project's service container is
+// running with docker compose ps
+func (cm *ComposeContainerManager) ContainerRunning(ctx context.Context, project string) (bool, error) {
+	cm.mutex.RLock()
+	p, ok := cm.projects[project]
+	cm.mutex.RUnlock()
+	if !ok {
+		return false, nil
+	}
+
+	output, err := cm.run(ctx, composeArgs(project, p.file, "ps", "--quiet", "--status", "running", p.service)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to list compose containers: %v (output: %s)", err, string(output))
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
+// Cleanup tears down all managed compose projects
+func (cm *ComposeContainerManager) Cleanup() error {
+	cm.mutex.Lock()
+	projects := cm.projects
+	cm.projects = make(map[string]composeProject)
+	cm.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), composeDownTimeout)
+	defer cancel()
+
+	var lastErr error
+	for project, p := range projects {
+		if err := cm.down(ctx, project, p.file); err != nil {
+			cm.logger.Error("failed to stop compose project during cleanup", zap.String("project", project), zap.Error(err))
+			lastErr = err
+
+			// Keep the project tracked so a later cleanup can retry it
+			cm.mutex.Lock()
+			cm.projects[project] = p
+			cm.mutex.Unlock()
+			continue
+		}
+		if p.container != nil {
+			p.container.markStopped()
+		}
+	}
+
+	return lastErr
+}
+
+// Interface guards
+var _ ContainerManagerInterface = (*ComposeContainerManager)(nil)
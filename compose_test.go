@@ -0,0 +1,216 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeComposeRunner records docker commands and returns canned output
+type fakeComposeRunner struct {
+	commands []string
+	portErr  error
+	downErr  error
+}
+
+func (f *fakeComposeRunner) run(ctx context.Context, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, strings.Join(args, " "))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(args) > 5 && args[5] == "down" && f.downErr != nil {
+		return nil, f.downErr
+	}
+	if len(args) > 5 && args[5] == "port" {
+		if f.portErr != nil {
+			return nil, f.portErr
+		}
+		return []byte("0.0.0.0:49153\n"), nil
+	}
+	return nil, nil
+}
+
+// TestComposeContainerManager_Lifecycle tests compose command construction for a start/stop cycle
+func TestComposeContainerManager_Lifecycle(t *testing.T) {
+	runner := &fakeComposeRunner{}
+	cm := NewComposeContainerManager(zap.NewNop())
+	cm.run = runner.run
+
+	container, err := cm.StartContainer(context.Background(), ContainerConfig{
+		ComposeFile:    "/srv/app/docker-compose.yml",
+		ComposeService: "web",
+		Port:           8080,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if container.Port != 49153 || container.IP != "127.0.0.1" {
+		t.Errorf("expected container at 127.0.0.1:49153, got %s:%d", container.IP, container.Port)
+	}
+	if container.StartedAt.IsZero() || container.StoppedAt != nil {
+		t.Errorf("expected a started, running container, got started %v and stopped %v", container.StartedAt, container.StoppedAt)
+	}
+
+	project := container.ID
+	if !strings.HasPrefix(project, "serverless-") {
+		t.Errorf("expected project name with serverless- prefix, got '%s'", project)
+	}
+
+	if err := cm.StopContainer(context.Background(), project); err != nil {
+		t.Fatalf("unexpected stop error: %v", err)
+	}
+	if container.StoppedAt == nil || container.StoppedAt.Before(container.StartedAt) {
+		t.Errorf("expected the container to be stopped after it started, got %v", container.StoppedAt)
+	}
+
+	base := fmt.Sprintf("compose --project-name %s --file /srv/app/docker-compose.yml", project)
+	expected := []string{
+		base + " up --detach",
+		base + " port web 8080",
+		base + " down --volumes --remove-orphans",
+	}
+	if strings.Join(runner.commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected commands:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(runner.commands, "\n"))
+	}
+	if len(cm.projects) != 0 {
+		t.Errorf("expected no tracked projects, got %d", len(cm.projects))
+	}
+}
+
+// TestComposeProjectName tests that project names carry the namespace in a form compose accepts
+func TestComposeProjectName(t *testing.T) {
+	if name := composeProjectName(""); !strings.HasPrefix(name, "serverless-") || strings.Count(name, "-") != 1 {
+		t.Errorf("expected serverless-<id> without a namespace, got %q", name)
+	}
+	name := composeProjectName("Prod.EU")
+	if !strings.HasPrefix(name, "serverless-prod-eu-") {
+		t.Errorf("expected the lowercased namespace after the prefix, got %q", name)
+	}
+	if composeProjectName("Prod.EU") == name {
+		t.Error("expected each project to get a unique name")
+	}
+}
+
+// TestComposeContainerManager_StartFailureCleansUp tests that a failed start tears the project down
+func TestComposeContainerManager_StartFailureCleansUp(t *testing.T) {
+	runner := &fakeComposeRunner{portErr: fmt.Errorf("no such service")}
+	cm := NewComposeContainerManager(zap.NewNop())
+	cm.run = runner.run
+
+	_, err := cm.StartContainer(context.Background(), ContainerConfig{
+		ComposeFile:    "/srv/app/docker-compose.yml",
+		ComposeService: "web",
+		Port:           8080,
+	})
+	if err == nil {
+		t.Fatal("expected error when the published port cannot be resolved")
+	}
+
+	last := runner.commands[len(runner.commands)-1]
+	if !strings.HasSuffix(last, "down --volumes --remove-orphans") {
+		t.Errorf("expected project to be brought down, last command was '%s'", last)
+	}
+	if len(cm.projects) != 0 {
+		t.Errorf("expected no tracked projects, got %d", len(cm.projects))
+	}
+}
+
+// TestComposeContainerManager_Cleanup tests that cleanup brings down all projects
+func TestComposeContainerManager_Cleanup(t *testing.T) {
+	runner := &fakeComposeRunner{}
+	cm := NewComposeContainerManager(zap.NewNop())
+	cm.run = runner.run
+
+	for i := 0; i < 2; i++ {
+		if _, err := cm.StartContainer(context.Background(), ContainerConfig{
+			ComposeFile:    "/srv/app/docker-compose.yml",
+			ComposeService: "web",
+			Port:           8080,
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	runner.commands = nil
+
+	if err := cm.Cleanup(); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if len(runner.commands) != 2 {
+		t.Errorf("expected 2 down commands, got %v", runner.commands)
+	}
+}
+
+// TestComposeContainerManager_CancelledStartCleansUp tests that a start failing
+// because its request was cancelled still brings the project down
+func TestComposeContainerManager_CancelledStartCleansUp(t *testing.T) {
+	runner := &fakeComposeRunner{}
+	cm := NewComposeContainerManager(zap.NewNop())
+	cm.run = runner.run
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cm.StartContainer(ctx, ContainerConfig{
+		ComposeFile:    "/srv/app/docker-compose.yml",
+		ComposeService: "web",
+		Port:           8080,
+	})
+	if err == nil {
+		t.Fatal("expected error when the start is cancelled")
+	}
+	if len(cm.projects) != 0 {
+		t.Errorf("expected the project to be brought down despite the cancelled context, %d still tracked: %v", len(cm.projects), runner.commands)
+	}
+}
+
+// TestComposeContainerManager_FailedDownKeepsProject tests that a project whose
+// teardown fails stays tracked so Cleanup retries it
+func TestComposeContainerManager_FailedDownKeepsProject(t *testing.T) {
+	runner := &fakeComposeRunner{}
+	cm := NewComposeContainerManager(zap.NewNop())
+	cm.run = runner.run
+
+	container, err := cm.StartContainer(context.Background(), ContainerConfig{
+		ComposeFile:    "/srv/app/docker-compose.yml",
+		ComposeService: "web",
+		Port:           8080,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runner.downErr = fmt.Errorf("daemon unavailable")
+	if err := cm.StopContainer(context.Background(), container.ID); err == nil {
+		t.Fatal("expected the failed teardown to be reported")
+	}
+	if err := cm.Cleanup(); err == nil {
+		t.Fatal("expected cleanup to report the failed teardown")
+	}
+	if _, ok := cm.projects[container.ID]; !ok || container.StoppedAt != nil {
+		t.Fatalf("expected the project to stay tracked and running after failed teardowns")
+	}
+
+	runner.downErr = nil
+	if err := cm.Cleanup(); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if len(cm.projects) != 0 || container.StoppedAt == nil {
+		t.Errorf("expected the project to be brought down on retry, %d still tracked", len(cm.projects))
+	}
+}
@@ -15,14 +15,20 @@
 package serverless
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -32,11 +38,46 @@ import (
 // allowing for easier testing and potential support for alternative container runtimes.
 type ContainerManagerInterface interface {
 	StartContainer(ctx context.Context, config ContainerConfig) (*Container, error)
-	WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int) error
+	WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error
 	StopContainer(ctx context.Context, containerID string) error
+
+	// ExecInContainer runs command in a started container, e.g. for
+	// post-start hooks, and waits for it to exit. A command that runs and
+	// fails is reported through exitCode; err is for commands that could not
+	// be run, and is errExecNotSupported for managers that cannot run any.
+	ExecInContainer(ctx context.Context, containerID string, command []string) (stdout string, stderr string, exitCode int, err error)
+
+	// GetContainerLogs returns the lines a container wrote in the last since,
+	// limited to the last tail of them, oldest first. Zero values return
+	// all lines.
+	GetContainerLogs(ctx context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error)
+
+	// PauseContainer freezes a container's processes without stopping it,
+	// and ResumeContainer thaws them. Both return errPauseNotSupported for
+	// managers that cannot pause their containers.
+	PauseContainer(ctx context.Context, containerID string) error
+	ResumeContainer(ctx context.Context, containerID string) error
+
+	// ContainerRunning reports whether a started container is still
+	// running; a container that no longer exists is not. It returns
+	// errRunningNotSupported for managers that cannot tell.
+	ContainerRunning(ctx context.Context, containerID string) (bool, error)
+
 	Cleanup() error
 }
 
+// errExecNotSupported is returned by ExecInContainer when the container
+// manager cannot run commands in its containers
+var errExecNotSupported = errors.New("container manager does not support exec")
+
+// errRunningNotSupported is returned by ContainerRunning when the container
+// manager cannot tell whether its containers are running
+var errRunningNotSupported = errors.New("container manager does not report whether containers are running")
+
+// errPauseNotSupported is returned by PauseContainer and ResumeContainer when
+// the container manager cannot pause its containers
+var errPauseNotSupported = errors.New("container manager does not support pausing containers")
+
 // ContainerManager manages Docker containers for serverless functions
 type ContainerManager struct {
 	containers map[string]*Container
@@ -50,6 +91,20 @@ type Container struct {
 	ID   string
 	IP   string
 	Port int
+
+	// StartedAt is when the container manager started the container
+	StartedAt time.Time
+
+	// StoppedAt is when the container manager stopped the container, or nil
+	// while it runs. It is set under the manager's lock, so it may be read
+	// once the caller's StopContainer has returned.
+	StoppedAt *time.Time
+}
+
+// markStopped records that the container was stopped now
+func (c *Container) markStopped() {
+	now := time.Now()
+	c.StoppedAt = &now
 }
 
 // VolumeMount represents a Docker volume mount
@@ -57,8 +112,92 @@ type VolumeMount struct {
 	Source   string
 	Target   string
 	ReadOnly bool
+
+	// Propagation is the bind propagation mode, one of mountPropagations,
+	// e.g. rshared to let mounts made on either side appear on the other.
+	// Empty keeps docker's default, rprivate.
+	Propagation string
+}
+
+// mountPropagations are the bind propagation modes docker accepts
+var mountPropagations = map[string]bool{
+	"private":  true,
+	"rprivate": true,
+	"shared":   true,
+	"rshared":  true,
+	"slave":    true,
+	"rslave":   true,
+}
+
+// options returns the options of the volume's -v spec, e.g. ro,rshared
+func (v VolumeMount) options() string {
+	var options []string
+	if v.ReadOnly {
+		options = append(options, "ro")
+	}
+	if v.Propagation != "" {
+		options = append(options, v.Propagation)
+	}
+	return strings.Join(options, ",")
+}
+
+// Defaults for the log rotation options of a ContainerLogConfig
+const (
+	defaultLogMaxSize = "10m"
+	defaultLogMaxFile = 3
+)
+
+// ContainerLogConfig sets how docker rotates a container's logs, so that a
+// chatty function cannot fill the host's disk
+type ContainerLogConfig struct {
+	// MaxSize is the size at which a log file is rotated, e.g. 10m
+	// (default: 10m)
+	MaxSize string `json:"max_size,omitempty"`
+
+	// MaxFile is the number of log files kept (default: 3)
+	MaxFile int `json:"max_file,omitempty"`
+
+	// Compress compresses rotated log files
+	Compress bool `json:"compress,omitempty"`
+}
+
+// args returns the docker run --log-opt flags for the configuration
+func (c *ContainerLogConfig) args() []string {
+	args := []string{
+		"--log-opt", "max-size=" + c.MaxSize,
+		"--log-opt", fmt.Sprintf("max-file=%d", c.MaxFile),
+	}
+	if c.Compress {
+		args = append(args, "--log-opt", "compress=true")
+	}
+	return args
+}
+
+// logDrivers are the logging drivers built into docker
+var logDrivers = map[string]bool{
+	"none":       true,
+	"local":      true,
+	"json-file":  true,
+	"syslog":     true,
+	"journald":   true,
+	"gelf":       true,
+	"fluentd":    true,
+	"awslogs":    true,
+	"splunk":     true,
+	"etwlogs":    true,
+	"gcplogs":    true,
+	"logentries": true,
 }
 
+// rotatesLogs reports whether driver writes log files that a
+// ContainerLogConfig can rotate. Empty is the daemon's default, json-file.
+func rotatesLogs(driver string) bool {
+	return driver == "" || driver == "json-file" || driver == "local"
+}
+
+// seccompUnconfined runs a container without syscall filtering
+const seccompUnconfined = "unconfined"
+
 // ContainerConfig represents the configuration for starting a container
 type ContainerConfig struct {
 	Image       string
@@ -66,8 +205,102 @@ type ContainerConfig struct {
 	Environment map[string]string
 	Volumes     []VolumeMount
 	Port        int
+
+	// AppendArgs are passed to the container after Command
+	AppendArgs []string
+
+	// Namespace isolates the containers of one deployment from those of
+	// others sharing the Caddy instance. Namespaced containers are named
+	// after their containerKey and labelled with the namespace.
+	Namespace string
+
+	// FunctionPath is the path of the function the container serves
+	FunctionPath string
+
+	// Name is the docker container name; set by StartContainer for
+	// namespaced containers
+	Name string
+
+	// PlacementConstraints restrict which swarm nodes may run the function.
+	// They only apply when running as a swarm service.
+	PlacementConstraints []string
+
+	// ComposeFile and ComposeService select a docker compose service to run
+	// instead of a single image. Only used by the compose manager.
+	ComposeFile    string
+	ComposeService string
+
+	// Memory limits the container's memory (docker --memory), e.g. 256m
+	Memory string
+
+	// MemorySwap limits memory plus swap (docker --memory-swap); -1 allows
+	// unlimited swap. Requires Memory.
+	MemorySwap string
+
+	// OOMKillDisable stops the kernel OOM killer from killing the container
+	// when it exceeds Memory. Requires Memory.
+	OOMKillDisable bool
+
+	// OOMScoreAdj shifts the container's OOM killer priority, from
+	// minOOMScoreAdj (never killed) to maxOOMScoreAdj (killed first). Zero
+	// keeps the kernel's default.
+	OOMScoreAdj int
+
+	// CgroupParent is the cgroup the container is placed under
+	CgroupParent string
+
+	// IPCMode is the container's IPC namespace; see validIPCMode
+	IPCMode string
+
+	// PIDMode is "host" to share the host's PID namespace, or empty or
+	// "private" for the container's own
+	PIDMode string
+
+	// UsernsMode is the container's user namespace mode, "host" or, with
+	// Podman, "keep-id"
+	UsernsMode string
+
+	// GroupAdd lists supplementary groups, by name or GID, for the
+	// container's user
+	GroupAdd []string
+
+	// Privileged gives the container all capabilities and access to the
+	// host's devices
+	Privileged bool
+
+	// SeccompProfile is a seccomp profile path or seccompUnconfined
+	SeccompProfile string
+
+	// LogConfig sets the rotation of the container's logs. Nil keeps the
+	// docker daemon's logging defaults.
+	LogConfig *ContainerLogConfig
+
+	// LogDriver selects the docker logging driver, e.g. journald or
+	// fluentd, and LogOpts are passed to it. Empty keeps the daemon's.
+	LogDriver string
+	LogOpts   map[string]string
+
+	// PortCheckEnabled verifies the port is free on the host before starting
+	// the container, since host networking would otherwise fail inside it
+	PortCheckEnabled bool
+
+	// MaxEnvValueLength and MaxEnvSize limit the length of each environment
+	// value and the size of all KEY=VALUE pairs together, in bytes. Zero
+	// selects defaultMaxEnvValueLength and defaultMaxEnvSize.
+	MaxEnvValueLength int
+	MaxEnvSize        int
 }
 
+const (
+	// defaultMaxEnvValueLength stays well below Linux's 128 KiB limit on a
+	// single exec argument, which each -e KEY=VALUE must fit in
+	defaultMaxEnvValueLength = 64 * 1024
+
+	// defaultMaxEnvSize leaves room within the usual 2 MiB limit on the
+	// total size of exec arguments for the rest of the docker command
+	defaultMaxEnvSize = 1024 * 1024
+)
+
 // validateDockerImage checks if the Docker image name is valid.
 // Basic validation: non-empty. More sophisticated validation can be added,
 // e.g., regex for valid image names from Docker's spec.
@@ -86,37 +319,151 @@ func validateDockerCommand(command string) bool {
 	return strings.TrimSpace(command) != ""
 }
 
-// validateContainerConfig validates the container configuration fields.
+// The range of ContainerConfig.OOMScoreAdj
+const (
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+)
+
+// cgroupParentRegex matches cgroup parents: absolute cgroupfs paths such as
+// /function/payments, or systemd slices such as payments.slice
+var cgroupParentRegex = regexp.MustCompile(`^(/[a-zA-Z0-9_-][a-zA-Z0-9_.-]*)+$|^[a-zA-Z0-9_-][a-zA-Z0-9_.@:-]*\.slice$`)
+
+// groupRegex matches group names and GIDs
+var groupRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// validIPCMode reports whether mode is a docker IPC mode: none, private,
+// shareable, host, or container:<name> to join the IPC namespace of a
+// shareable container
+func validIPCMode(mode string) bool {
+	switch mode {
+	case "none", "private", "shareable", "host":
+		return true
+	}
+	name, ok := strings.CutPrefix(mode, "container:")
+	return ok && namespaceRegex.MatchString(name)
+}
+
+// validateContainerConfig validates the container configuration fields,
+// reporting every invalid field at once.
 func validateContainerConfig(config ContainerConfig) error {
+	return containerConfigReport(config).Err()
+}
+
+// containerConfigReport returns the issues found in the container
+// configuration fields.
+func containerConfigReport(config ContainerConfig) ValidationErrors {
+	var report ValidationErrors
 	if !validateDockerImage(config.Image) {
-		return fmt.Errorf("invalid docker image name: '%s'", config.Image)
+		report.addError("image", "invalid docker image name: '%s'", config.Image)
+	} else if digest, ok := imageDigest(config.Image); ok && !imageDigestRegex.MatchString(digest) {
+		report.addError("image", "invalid image digest '%s': expected sha256: followed by 64 hex digits", digest)
 	}
-	if len(config.Command) > 0 {
-		for i, cmdPart := range config.Command {
-			if !validateDockerCommand(cmdPart) {
-				return fmt.Errorf("invalid docker command part at index %d: '%s'", i, cmdPart)
-			}
+	for i, cmdPart := range config.Command {
+		if !validateDockerCommand(cmdPart) {
+			report.addError(fmt.Sprintf("command[%d]", i), "invalid docker command part: '%s'", cmdPart)
+		}
+	}
+	for i, arg := range config.AppendArgs {
+		if !validateDockerCommand(arg) {
+			report.addError(fmt.Sprintf("append_args[%d]", i), "invalid appended argument: '%s'", arg)
 		}
 	}
 
-	// Validate Environment variables
-	for key := range config.Environment {
+	if config.OOMScoreAdj < minOOMScoreAdj || config.OOMScoreAdj > maxOOMScoreAdj {
+		report.addError("oom_score_adj", "must be between %d and %d, got %d", minOOMScoreAdj, maxOOMScoreAdj, config.OOMScoreAdj)
+	}
+	if config.CgroupParent != "" && !cgroupParentRegex.MatchString(config.CgroupParent) {
+		report.addError("cgroup_parent", "invalid cgroup parent '%s'", config.CgroupParent)
+	}
+	if config.IPCMode != "" && !validIPCMode(config.IPCMode) {
+		report.addError("ipc_mode", "invalid IPC mode '%s'", config.IPCMode)
+	}
+	switch config.PIDMode {
+	case "", "private", "host":
+	default:
+		report.addError("pid_mode", "invalid PID mode '%s'", config.PIDMode)
+	}
+	switch config.UsernsMode {
+	case "", "host", "keep-id":
+	default:
+		report.addError("userns_mode", "invalid user namespace mode '%s'", config.UsernsMode)
+	}
+	for i, group := range config.GroupAdd {
+		if !groupRegex.MatchString(group) {
+			report.addError(fmt.Sprintf("group_add[%d]", i), "invalid group '%s'", group)
+		}
+	}
+
+	// Validate logging
+	if config.LogDriver != "" && !logDrivers[config.LogDriver] {
+		report.addError("log_driver", "unknown log driver '%s'", config.LogDriver)
+	}
+	for key := range config.LogOpts {
 		if strings.TrimSpace(key) == "" {
-			return fmt.Errorf("environment variable key cannot be empty")
+			report.addError("log_opts", "log option key cannot be empty")
 		}
-		// Potentially add more checks for key/value formats if needed
+	}
+
+	// Validate Environment variables
+	if err := validateEnvironment(config); err != nil {
+		report.addError("environment", "%v", err)
 	}
 
 	// Validate Volumes
 	for i, volume := range config.Volumes {
 		if strings.TrimSpace(volume.Source) == "" {
-			return fmt.Errorf("volume mount source cannot be empty at index %d", i)
+			report.addError(fmt.Sprintf("volumes[%d].source", i), "volume mount source cannot be empty")
 		}
 		if strings.TrimSpace(volume.Target) == "" {
-			return fmt.Errorf("volume mount target cannot be empty at index %d", i)
+			report.addError(fmt.Sprintf("volumes[%d].target", i), "volume mount target cannot be empty")
+		}
+		if volume.Propagation != "" && !mountPropagations[volume.Propagation] {
+			report.addError(fmt.Sprintf("volumes[%d].propagation", i), "invalid mount propagation '%s'", volume.Propagation)
 		}
 		// Potentially add more checks for path validity
 	}
+	return report
+}
+
+// validateEnvironment checks environment keys and keeps the variables within
+// the configured size limits, naming the variable at fault.
+func validateEnvironment(config ContainerConfig) error {
+	maxValue := config.MaxEnvValueLength
+	if maxValue == 0 {
+		maxValue = defaultMaxEnvValueLength
+	}
+	maxTotal := config.MaxEnvSize
+	if maxTotal == 0 {
+		maxTotal = defaultMaxEnvSize
+	}
+
+	// Check keys in order so that errors are deterministic
+	keys := make([]string, 0, len(config.Environment))
+	for key := range config.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	total := 0
+	largest := ""
+	for _, key := range keys {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("environment variable key cannot be empty")
+		}
+		value := config.Environment[key]
+		if len(value) > maxValue {
+			return fmt.Errorf("environment variable %s is %d bytes long, exceeding the limit of %d bytes", key, len(value), maxValue)
+		}
+		total += len(key) + 1 + len(value)
+		if largest == "" || len(value) > len(config.Environment[largest]) {
+			largest = key
+		}
+	}
+	if total > maxTotal {
+		return fmt.Errorf("environment is %d bytes in total, exceeding the limit of %d bytes (largest variable: %s, %d bytes)",
+			total, maxTotal, largest, len(config.Environment[largest]))
+	}
 	return nil
 }
 
@@ -143,34 +490,20 @@ func (cm *ContainerManager) StartContainer(ctx context.Context, config Container
 		return nil, fmt.Errorf("invalid container configuration: %w", err)
 	}
 
-	// Build docker run command
-	args := []string{"run", "-d", "--rm"}
-
-	// Use host networking mode
-	args = append(args, "--network", "host")
-
-	// Add environment variables
-	for key, value := range config.Environment {
-		args = append(args, "-e", fmt.Sprintf("%s=%s", key, value))
-	}
-
-	// Add volume mounts
-	for _, volume := range config.Volumes {
-		mountStr := fmt.Sprintf("%s:%s", volume.Source, volume.Target)
-		if volume.ReadOnly {
-			mountStr += ":ro"
+	// With host networking the container binds directly to the host port,
+	// so fail fast if something is already listening there
+	if config.PortCheckEnabled {
+		if err := checkHostPortAvailable(config.Port); err != nil {
+			return nil, err
 		}
-		args = append(args, "-v", mountStr)
 	}
 
-	// Add image
-	args = append(args, config.Image)
-
-	// Add command if specified
-	if len(config.Command) > 0 {
-		args = append(args, config.Command...)
+	if config.Namespace != "" && config.Name == "" {
+		config.Name = cm.containerKey(config.Namespace, config.Image, config.FunctionPath) + "_" + generateRequestID()[:12]
 	}
 
+	args := buildRunArgs(config)
+
 	cm.logger.Debug("starting container", zap.Strings("args", args))
 
 	// Execute docker run command
@@ -202,7 +535,18 @@ func (cm *ContainerManager) StartContainer(ctx context.Context, config Container
 		return nil, fmt.Errorf("failed to get container info for %s: %w (container has been stopped)", containerID, err)
 	}
 
+	// Images pinned by digest must actually run that digest
+	if digest, ok := imageDigest(config.Image); ok {
+		if err := cm.verifyImageDigest(ctx, containerID, digest); err != nil {
+			if stopErr := cm.stopContainerByID(ctx, containerID); stopErr != nil {
+				cm.logger.Error("Failed to stop container after image digest verification failed", zap.String("container_id", containerID), zap.Error(stopErr))
+			}
+			return nil, fmt.Errorf("container %s: %w", containerID, err)
+		}
+	}
+
 	// Store container reference
+	container.StartedAt = time.Now()
 	cm.mutex.Lock()
 	cm.containers[containerID] = container
 	cm.mutex.Unlock()
@@ -210,10 +554,172 @@ func (cm *ContainerManager) StartContainer(ctx context.Context, config Container
 	return container, nil
 }
 
+// checkHostPortAvailable returns an error if the given port is already bound on the host.
+// Failures other than the address being in use (e.g. lacking permission to bind a
+// privileged port) are not treated as conflicts, since the container may still bind it.
+func checkHostPortAvailable(port int) error {
+	if port == 0 {
+		return nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return fmt.Errorf("port %d is already in use on the host: %w", port, err)
+		}
+		return nil
+	}
+	_ = listener.Close()
+	return nil
+}
+
+// namespaceLabel is the docker label holding a container's namespace
+const namespaceLabel = "serverless.namespace"
+
+// invalidNameChars matches characters not allowed in docker container names
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// maxKeyPartLength bounds each part of a containerKey
+const maxKeyPartLength = 32
+
+// containerKey identifies a function's containers within a namespace, e.g.
+// production_nginx-alpine_api-hello. It is a valid docker container name, so
+// containers of the same image and path in different namespaces never clash.
+func (cm *ContainerManager) containerKey(namespace, image, path string) string {
+	parts := []string{namespace, image, path}
+	for i, part := range parts {
+		part = strings.Trim(invalidNameChars.ReplaceAllString(part, "-"), "-._")
+		if len(part) > maxKeyPartLength {
+			part = part[:maxKeyPartLength]
+		}
+		if part == "" {
+			part = "default"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "_")
+}
+
+// buildRunArgs builds the docker run arguments for the given configuration
+func buildRunArgs(config ContainerConfig) []string {
+	// Build docker run command
+	args := []string{"run", "-d", "--rm"}
+
+	// Use host networking mode
+	args = append(args, "--network", "host")
+
+	// Name and label namespaced containers
+	if config.Name != "" {
+		args = append(args, "--name", config.Name)
+	}
+	if config.Namespace != "" {
+		args = append(args, "--label", namespaceLabel+"="+config.Namespace)
+	}
+
+	// Add environment variables
+	for key, value := range config.Environment {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", key, value))
+	}
+
+	// Add volume mounts
+	for _, volume := range config.Volumes {
+		mountStr := fmt.Sprintf("%s:%s", volume.Source, volume.Target)
+		if options := volume.options(); options != "" {
+			mountStr += ":" + options
+		}
+		args = append(args, "-v", mountStr)
+	}
+
+	// Add memory limits
+	if config.Memory != "" {
+		args = append(args, "--memory", config.Memory)
+	}
+	if config.MemorySwap != "" {
+		args = append(args, "--memory-swap", config.MemorySwap)
+	}
+	if config.OOMKillDisable {
+		args = append(args, "--oom-kill-disable")
+	}
+	if config.OOMScoreAdj != 0 {
+		args = append(args, "--oom-score-adj", strconv.Itoa(config.OOMScoreAdj))
+	}
+	if config.CgroupParent != "" {
+		args = append(args, "--cgroup-parent", config.CgroupParent)
+	}
+	if config.IPCMode != "" {
+		args = append(args, "--ipc", config.IPCMode)
+	}
+	if config.PIDMode == "host" {
+		args = append(args, "--pid", "host")
+	}
+	if config.UsernsMode != "" {
+		args = append(args, "--userns", config.UsernsMode)
+	}
+	for _, group := range config.GroupAdd {
+		args = append(args, "--group-add", group)
+	}
+	if config.Privileged {
+		args = append(args, "--privileged")
+	}
+
+	// Add syscall filtering
+	if config.SeccompProfile != "" {
+		args = append(args, "--security-opt", "seccomp="+config.SeccompProfile)
+	}
+
+	// Add logging options
+	if config.LogDriver != "" {
+		args = append(args, "--log-driver", config.LogDriver)
+	}
+	if config.LogConfig != nil {
+		args = append(args, config.LogConfig.args()...)
+	}
+	logOpts := make([]string, 0, len(config.LogOpts))
+	for key := range config.LogOpts {
+		logOpts = append(logOpts, key)
+	}
+	sort.Strings(logOpts)
+	for _, key := range logOpts {
+		args = append(args, "--log-opt", key+"="+config.LogOpts[key])
+	}
+
+	// Add image
+	args = append(args, config.Image)
+
+	// Add command if specified
+	if len(config.Command) > 0 {
+		args = append(args, config.Command...)
+	}
+
+	// Add extra arguments after the command
+	args = append(args, config.AppendArgs...)
+
+	return args
+}
+
+// containerInspect holds the parts of docker inspect's output used to reach
+// a container
+type containerInspect struct {
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// hostPort returns the host port that internalPort is published on, or
+// internalPort itself when it is not published, as with host networking
+func (c *containerInspect) hostPort(internalPort int) int {
+	for _, binding := range c.NetworkSettings.Ports[fmt.Sprintf("%d/tcp", internalPort)] {
+		if port, err := strconv.Atoi(binding.HostPort); err == nil && port > 0 {
+			return port
+		}
+	}
+	return internalPort
+}
+
 // getContainerInfo retrieves the IP address and port mapping for a container
 func (cm *ContainerManager) getContainerInfo(ctx context.Context, containerID string, internalPort int) (*Container, error) {
-	// With host networking, containers use localhost
-	// We just need to verify the container exists
 	cmd := exec.CommandContext(ctx, "docker", "inspect", containerID)
 	output, err := cmd.Output()
 	if err != nil {
@@ -221,7 +727,7 @@ func (cm *ContainerManager) getContainerInfo(ctx context.Context, containerID st
 	}
 
 	// Parse JSON output to verify container exists
-	var inspectData []map[string]interface{}
+	var inspectData []containerInspect
 	if err := json.Unmarshal(output, &inspectData); err != nil {
 		return nil, fmt.Errorf("failed to parse container inspect output: %v", err)
 	}
@@ -230,35 +736,44 @@ func (cm *ContainerManager) getContainerInfo(ctx context.Context, containerID st
 		return nil, fmt.Errorf("no container data returned")
 	}
 
-	// With host networking, use localhost and the configured port
+	// The container is reached through localhost, on the internal port with
+	// host networking or on the port it is published on otherwise
 	return &Container{
 		ID:   containerID,
 		IP:   "127.0.0.1",
-		Port: internalPort,
+		Port: inspectData[0].hostPort(internalPort),
 	}, nil
 }
 
-// WaitForReady waits for the container to be ready to accept connections
-func (cm *ContainerManager) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int) error {
+// WaitForReady waits for the container to be ready to accept connections.
+// If maxAttempts is positive, it also gives up after that many probes,
+// whichever limit is hit first.
+func (cm *ContainerManager) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error {
 	deadline := time.Now().Add(timeout)
+	host := container.IP
+	if host == "" {
+		host = "127.0.0.1"
+	}
 
-	for time.Now().Before(deadline) {
+	for attempt := 1; time.Now().Before(deadline); attempt++ {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
-		// With host networking, connect to localhost on the configured port
-		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
 		if err == nil {
 			_ = conn.Close()
 			cm.logger.Info("container is ready",
 				zap.String("container_id", container.ID),
-				zap.String("ip", "127.0.0.1"),
+				zap.String("ip", host),
 				zap.Int("port", port))
 			return nil
 		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return fmt.Errorf("container did not become ready after %d attempts", maxAttempts)
+		}
 
 		// Wait a bit before retrying
 		time.Sleep(500 * time.Millisecond)
@@ -270,7 +785,10 @@ func (cm *ContainerManager) WaitForReady(ctx context.Context, container *Contain
 // StopContainer stops and removes a container
 func (cm *ContainerManager) StopContainer(ctx context.Context, containerID string) error {
 	cm.mutex.Lock()
-	delete(cm.containers, containerID)
+	if container, ok := cm.containers[containerID]; ok {
+		container.markStopped()
+		delete(cm.containers, containerID)
+	}
 	cm.mutex.Unlock()
 
 	return cm.stopContainerByID(ctx, containerID)
@@ -294,6 +812,71 @@ func (cm *ContainerManager) stopContainerByID(ctx context.Context, containerID s
 	return nil
 }
 
+// ExecInContainer runs a command in a running container with docker exec
+// and waits for it to exit
+func (cm *ContainerManager) ExecInContainer(ctx context.Context, containerID string, command []string) (string, string, int, error) {
+	return runCommand(ctx, "docker", append([]string{"exec", containerID}, command...)...)
+}
+
+// GetContainerLogs returns a container's recent output with docker logs
+func (cm *ContainerManager) GetContainerLogs(ctx context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error) {
+	args := append([]string{"logs"}, logsArgs(since, tail)...)
+	return runLogsCommand(ctx, append(args, containerID)...)
+}
+
+// PauseContainer freezes a container with docker pause
+func (cm *ContainerManager) PauseContainer(ctx context.Context, containerID string) error {
+	cm.logger.Debug("pausing container", zap.String("container_id", containerID))
+	if output, err := exec.CommandContext(ctx, "docker", "pause", containerID).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to pause container: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+// ResumeContainer thaws a paused container with docker unpause
+func (cm *ContainerManager) ResumeContainer(ctx context.Context, containerID string) error {
+	cm.logger.Debug("resuming container", zap.String("container_id", containerID))
+	if output, err := exec.CommandContext(ctx, "docker", "unpause", containerID).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to resume container: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+// ContainerRunning reports whether a container is running with docker
+// inspect. Containers are run with --rm, so one that exited may be gone.
+func (cm *ContainerManager) ContainerRunning(ctx context.Context, containerID string) (bool, error) {
+	stdout, stderr, exitCode, err := runCommand(ctx, "docker", "inspect", "--format", "{{.State.Running}}", containerID)
+	switch {
+	case err != nil:
+		return false, fmt.Errorf("failed to inspect container: %v", err)
+	case exitCode != 0 && strings.Contains(stderr, "No such"):
+		return false, nil
+	case exitCode != 0:
+		return false, fmt.Errorf("failed to inspect container: exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+	return strings.TrimSpace(stdout) == "true", nil
+}
+
+// runCommand runs name with args and returns its output and exit status. A
+// command exiting with a non-zero status is not an error.
+func runCommand(ctx context.Context, name string, args ...string) (stdout string, stderr string, exitCode int, err error) {
+	var outBuf, errBuf bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+
+	// A command killed by a signal, e.g. on timeout, has no exit status
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return outBuf.String(), errBuf.String(), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return outBuf.String(), errBuf.String(), -1, err
+	}
+	return outBuf.String(), errBuf.String(), 0, nil
+}
+
 // Cleanup stops all managed containers
 func (cm *ContainerManager) Cleanup() error {
 	cm.mutex.Lock()
@@ -338,3 +921,6 @@ func (cm *ContainerManager) HealthCheck(ctx context.Context, container *Containe
 
 	return nil
 }
+
+// Interface guards
+var _ ContainerManagerInterface = (*ContainerManager)(nil)
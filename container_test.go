@@ -0,0 +1,696 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestStartContainer_PortInUse tests that a port conflict fails before docker is invoked
+func TestStartContainer_PortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	cm := NewContainerManager(zap.NewNop())
+	_, err = cm.StartContainer(context.Background(), ContainerConfig{
+		Image:            "test:latest",
+		Port:             port,
+		PortCheckEnabled: true,
+	})
+	if err == nil {
+		t.Fatal("expected error when port is already in use")
+	}
+	if !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("expected port-in-use error, got: %v", err)
+	}
+}
+
+// TestCheckHostPortAvailable tests that a free port passes the check
+func TestCheckHostPortAvailable(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	if err := checkHostPortAvailable(port); err != nil {
+		t.Errorf("expected free port %d to pass the check, got: %v", port, err)
+	}
+}
+
+// TestWaitForReady_MaxAttempts tests that readiness fails after the configured
+// number of probes when the port never opens, well before the timeout
+func TestWaitForReady_MaxAttempts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	cm := NewContainerManager(zap.NewNop())
+	container := &Container{ID: "never-ready", IP: "127.0.0.1", Port: port}
+
+	start := time.Now()
+	err = cm.WaitForReady(context.Background(), container, time.Minute, port, 3)
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("expected readiness to fail when the port never opens")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected attempts error, got: %v", err)
+	}
+	// Three probes are separated by two 500ms pauses
+	if elapsed < time.Second || elapsed > 10*time.Second {
+		t.Errorf("expected failure after about 1s, took %v", elapsed)
+	}
+
+	// The timeout still applies when it is hit first
+	start = time.Now()
+	err = cm.WaitForReady(context.Background(), container, 100*time.Millisecond, port, 100)
+	if err == nil || !strings.Contains(err.Error(), "within timeout") {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the timeout to end the check, took %v", elapsed)
+	}
+}
+
+func TestContainerInspect_HostPort(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected int
+	}{
+		{
+			name:     "host networking",
+			output:   `[{"HostConfig": {"NetworkMode": "host"}, "NetworkSettings": {"Ports": {}}}]`,
+			expected: 8080,
+		},
+		{
+			name: "bridge with published port",
+			output: `[{"HostConfig": {"NetworkMode": "bridge"}, "NetworkSettings": {"Ports": {
+				"8080/tcp": [{"HostIp": "0.0.0.0", "HostPort": "49153"}, {"HostIp": "::", "HostPort": "49153"}],
+				"9090/tcp": [{"HostIp": "0.0.0.0", "HostPort": "49154"}]
+			}}}]`,
+			expected: 49153,
+		},
+		{
+			name:     "bridge with exposed but unpublished port",
+			output:   `[{"HostConfig": {"NetworkMode": "bridge"}, "NetworkSettings": {"Ports": {"8080/tcp": null}}}]`,
+			expected: 8080,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inspect []containerInspect
+			if err := json.Unmarshal([]byte(tt.output), &inspect); err != nil {
+				t.Fatalf("failed to parse inspect output: %v", err)
+			}
+			if port := inspect[0].hostPort(8080); port != tt.expected {
+				t.Errorf("expected port %d, got %d", tt.expected, port)
+			}
+		})
+	}
+}
+
+// TestBuildRunArgs_AppendArgs tests that appended arguments follow the image and command
+func TestBuildRunArgs_AppendArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    []string
+		appendArgs []string
+		expected   []string
+	}{
+		{"command and appended args", []string{"/app/handler", "--mode"}, []string{"fast", "--debug"}, []string{"test:latest", "/app/handler", "--mode", "fast", "--debug"}},
+		{"appended args without command", nil, []string{"--debug"}, []string{"test:latest", "--debug"}},
+		{"command without appended args", []string{"/app/handler"}, nil, []string{"test:latest", "/app/handler"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := buildRunArgs(ContainerConfig{
+				Image:      "test:latest",
+				Command:    tt.command,
+				AppendArgs: tt.appendArgs,
+			})
+
+			tail := args[len(args)-len(tt.expected):]
+			if strings.Join(tail, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected args to end with %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+// TestBuildServiceArgs tests swarm service flag construction, including placement constraints
+func TestBuildServiceArgs(t *testing.T) {
+	config := ContainerConfig{
+		Image:                "test:latest",
+		Command:              []string{"/app/handler"},
+		AppendArgs:           []string{"--debug"},
+		Environment:          map[string]string{"KEY": "value"},
+		Volumes:              []VolumeMount{{Source: "/host", Target: "/data", ReadOnly: true}, {Source: "/mnt", Target: "/mnt", Propagation: "rslave"}},
+		Port:                 9000,
+		PlacementConstraints: []string{"node.labels.region==us-east", "node.role==worker"},
+		Memory:               "256m",
+		GroupAdd:             []string{"video", "44"},
+		LogDriver:            "json-file",
+		LogConfig:            &ContainerLogConfig{MaxSize: "10m", MaxFile: 3},
+		LogOpts:              map[string]string{"tag": "fn", "labels": "app"},
+	}
+
+	args := strings.Join(buildServiceArgs(config), " ")
+
+	expected := []string{
+		"service create --detach",
+		"--publish published=9000,target=9000",
+		"--env KEY=value",
+		"--mount type=bind,source=/host,target=/data,readonly",
+		"--mount type=bind,source=/mnt,target=/mnt,bind-propagation=rslave",
+		"--constraint node.labels.region==us-east --constraint node.role==worker",
+		"--limit-memory 256m --group video --group 44",
+		"--log-driver json-file --log-opt max-size=10m --log-opt max-file=3 --log-opt labels=app --log-opt tag=fn",
+		"test:latest /app/handler --debug",
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(args, fragment) {
+			t.Errorf("expected service args to contain '%s', got: %s", fragment, args)
+		}
+	}
+	if strings.Contains(args, "--network host") {
+		t.Errorf("expected service args not to use host networking, got: %s", args)
+	}
+}
+
+// TestBuildRunArgs_IgnoresConstraints tests that plain containers do not receive swarm flags
+func TestBuildRunArgs_IgnoresConstraints(t *testing.T) {
+	args := strings.Join(buildRunArgs(ContainerConfig{
+		Image:                "test:latest",
+		Port:                 9000,
+		PlacementConstraints: []string{"node.labels.region==us-east"},
+	}), " ")
+
+	if !strings.HasPrefix(args, "run -d --rm --network host") {
+		t.Errorf("expected docker run with host networking, got: %s", args)
+	}
+	if strings.Contains(args, "--constraint") {
+		t.Errorf("expected no constraint flags for docker run, got: %s", args)
+	}
+}
+
+func TestBuildRunArgs_MemoryLimits(t *testing.T) {
+	args := strings.Join(buildRunArgs(ContainerConfig{
+		Image:          "test:latest",
+		Memory:         "256m",
+		MemorySwap:     "512m",
+		OOMKillDisable: true,
+	}), " ")
+
+	for _, flag := range []string{"--memory 256m", "--memory-swap 512m", "--oom-kill-disable"} {
+		if !strings.Contains(args, flag) {
+			t.Errorf("expected %q in args, got: %s", flag, args)
+		}
+	}
+	if !strings.HasSuffix(args, "--oom-kill-disable test:latest") {
+		t.Errorf("expected memory flags before the image, got: %s", args)
+	}
+
+	args = strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--memory") || strings.Contains(args, "--oom-kill-disable") {
+		t.Errorf("expected no memory flags by default, got: %s", args)
+	}
+}
+
+func TestBuildRunArgs_OOMScoreAdj(t *testing.T) {
+	tests := []struct {
+		adj      int
+		expected string
+	}{
+		{-500, "--oom-score-adj -500"},
+		{750, "--oom-score-adj 750"},
+		{0, ""},
+	}
+	for _, tt := range tests {
+		args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", OOMScoreAdj: tt.adj}), " ")
+		if tt.expected == "" {
+			if strings.Contains(args, "--oom-score-adj") {
+				t.Errorf("expected no --oom-score-adj for 0, got: %s", args)
+			}
+			continue
+		}
+		if !strings.HasSuffix(args, tt.expected+" test:latest") {
+			t.Errorf("expected args ending in %q, got: %s", tt.expected, args)
+		}
+	}
+
+	for _, adj := range []int{minOOMScoreAdj, maxOOMScoreAdj} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", OOMScoreAdj: adj}); err != nil {
+			t.Errorf("%d: unexpected error: %v", adj, err)
+		}
+	}
+	for _, adj := range []int{minOOMScoreAdj - 1, maxOOMScoreAdj + 1} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", OOMScoreAdj: adj}); err == nil {
+			t.Errorf("%d: expected an out of range value to be rejected", adj)
+		}
+	}
+}
+
+func TestBuildRunArgs_CgroupParent(t *testing.T) {
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", CgroupParent: "/function/payments"}), " ")
+	if !strings.HasSuffix(args, "--cgroup-parent /function/payments test:latest") {
+		t.Errorf("expected --cgroup-parent before the image, got: %s", args)
+	}
+	args = strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--cgroup-parent") {
+		t.Errorf("expected no --cgroup-parent by default, got: %s", args)
+	}
+
+	for _, parent := range []string{"/function/payments", "/caddy", "payments.slice", "system-caddy.slice"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", CgroupParent: parent}); err != nil {
+			t.Errorf("%q: unexpected error: %v", parent, err)
+		}
+	}
+	for _, parent := range []string{"function/payments", "/function/../payments", "/function/", "payments", "/a b", ".slice"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", CgroupParent: parent}); err == nil {
+			t.Errorf("%q: expected an invalid cgroup parent to be rejected", parent)
+		}
+	}
+}
+
+func TestBuildRunArgs_GroupAddPrivileged(t *testing.T) {
+	config := ContainerConfig{Image: "test:latest", GroupAdd: []string{"video", "44"}, Privileged: true}
+	if err := validateContainerConfig(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args := strings.Join(buildRunArgs(config), " ")
+	if expected := "--group-add video --group-add 44 --privileged test:latest"; !strings.HasSuffix(args, expected) {
+		t.Errorf("expected args ending in %q, got: %s", expected, args)
+	}
+
+	args = strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--group-add") || strings.Contains(args, "--privileged") {
+		t.Errorf("expected no extra groups or privileges by default, got: %s", args)
+	}
+	for _, group := range []string{"", "-g", "bad group", "wheel;rm"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", GroupAdd: []string{group}}); err == nil {
+			t.Errorf("%q: expected an invalid group to be rejected", group)
+		}
+	}
+}
+
+func TestBuildRunArgs_VolumePropagation(t *testing.T) {
+	config := ContainerConfig{
+		Image: "test:latest",
+		Volumes: []VolumeMount{
+			{Source: "/srv/data", Target: "/data"},
+			{Source: "/mnt/fuse", Target: "/fuse", Propagation: "rshared"},
+			{Source: "/srv/ro", Target: "/ro", ReadOnly: true, Propagation: "rslave"},
+		},
+	}
+	if err := validateContainerConfig(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args := strings.Join(buildRunArgs(config), " ")
+	if expected := "-v /srv/data:/data -v /mnt/fuse:/fuse:rshared -v /srv/ro:/ro:ro,rslave"; !strings.Contains(args, expected) {
+		t.Errorf("expected args to contain %q, got: %s", expected, args)
+	}
+
+	config.Volumes = []VolumeMount{{Source: "/srv", Target: "/srv", Propagation: "shared-ish"}}
+	if err := validateContainerConfig(config); err == nil {
+		t.Error("expected an invalid mount propagation to be rejected")
+	}
+}
+
+func TestBuildRunArgs_IPCMode(t *testing.T) {
+	for _, mode := range []string{"none", "private", "shareable", "host", "container:shm-sidecar"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", IPCMode: mode}); err != nil {
+			t.Errorf("%q: unexpected error: %v", mode, err)
+		}
+		args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", IPCMode: mode}), " ")
+		if expected := "--ipc " + mode + " test:latest"; !strings.HasSuffix(args, expected) {
+			t.Errorf("expected args ending in %q, got: %s", expected, args)
+		}
+	}
+	for _, mode := range []string{"shared", "Host", "container:", "container:bad name"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", IPCMode: mode}); err == nil {
+			t.Errorf("%q: expected an invalid IPC mode to be rejected", mode)
+		}
+	}
+
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--ipc") {
+		t.Errorf("expected no --ipc by default, got: %s", args)
+	}
+}
+
+func TestBuildRunArgs_PIDMode(t *testing.T) {
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", PIDMode: "host"}), " ")
+	if !strings.HasSuffix(args, "--pid host test:latest") {
+		t.Errorf("expected --pid host before the image, got: %s", args)
+	}
+	for _, mode := range []string{"", "private"} {
+		args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", PIDMode: mode}), " ")
+		if strings.Contains(args, "--pid") {
+			t.Errorf("%q: expected no --pid, got: %s", mode, args)
+		}
+	}
+	if err := validateContainerConfig(ContainerConfig{Image: "alpine", PIDMode: "shared"}); err == nil {
+		t.Error("expected an invalid PID mode to be rejected")
+	}
+}
+
+func TestBuildRunArgs_UsernsMode(t *testing.T) {
+	for _, mode := range []string{"host", "keep-id"} {
+		args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", UsernsMode: mode}), " ")
+		if expected := "--userns " + mode + " test:latest"; !strings.HasSuffix(args, expected) {
+			t.Errorf("expected args ending in %q, got: %s", expected, args)
+		}
+	}
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--userns") {
+		t.Errorf("expected no --userns by default, got: %s", args)
+	}
+	if err := validateContainerConfig(ContainerConfig{Image: "alpine", UsernsMode: "private"}); err == nil {
+		t.Error("expected an invalid user namespace mode to be rejected")
+	}
+}
+
+func TestBuildRunArgs_Seccomp(t *testing.T) {
+	for _, profile := range []string{"/etc/caddy/seccomp.json", "unconfined"} {
+		args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest", SeccompProfile: profile}), " ")
+		if expected := "--security-opt seccomp=" + profile + " test:latest"; !strings.HasSuffix(args, expected) {
+			t.Errorf("expected args ending in %q, got: %s", expected, args)
+		}
+	}
+
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(args, "--security-opt") {
+		t.Errorf("expected no security options by default, got: %s", args)
+	}
+}
+
+func TestContainerKey(t *testing.T) {
+	cm := NewContainerManager(zap.NewNop())
+	tests := []struct {
+		namespace, image, path string
+		expected               string
+	}{
+		{"production", "nginx:alpine", "/api/hello", "production_nginx-alpine_api-hello"},
+		{"staging", "nginx:alpine", "/api/hello", "staging_nginx-alpine_api-hello"},
+		{"production", "registry.example.com/app:1.0", "^/api/.*$", "production_registry.example.com-app-1.0_api"},
+		{"production", "app", "/", "production_app_default"},
+	}
+	for _, tt := range tests {
+		key := cm.containerKey(tt.namespace, tt.image, tt.path)
+		if key != tt.expected {
+			t.Errorf("containerKey(%q, %q, %q) = %q, want %q", tt.namespace, tt.image, tt.path, key, tt.expected)
+		}
+	}
+
+	long := cm.containerKey("ns", strings.Repeat("a", 100), "/p")
+	if long != "ns_"+strings.Repeat("a", maxKeyPartLength)+"_p" {
+		t.Errorf("expected long parts to be truncated, got %q", long)
+	}
+}
+
+func TestBuildServiceArgs_Namespace(t *testing.T) {
+	sm := NewSwarmContainerManager(zap.NewNop())
+	config := ContainerConfig{Image: "registry.example.com/app:1.0", FunctionPath: "/api", Namespace: "production"}
+	config.Name = sm.serviceName(config)
+	if !strings.HasPrefix(config.Name, "production_registry-example-com-app-1-0_api_") {
+		t.Errorf("expected the service to be named after its containerKey without dots, got %q", config.Name)
+	}
+
+	args := strings.Join(buildServiceArgs(config), " ")
+	for _, fragment := range []string{
+		"--name " + config.Name,
+		"--label serverless.namespace=production --container-label serverless.namespace=production",
+	} {
+		if !strings.Contains(args, fragment) {
+			t.Errorf("expected %q in args, got: %s", fragment, args)
+		}
+	}
+
+	long := sm.serviceName(ContainerConfig{Image: strings.Repeat("a", 100), FunctionPath: "/" + strings.Repeat("p", 100), Namespace: "production"})
+	if len(long) > maxServiceNameLength {
+		t.Errorf("expected service names of at most %d characters, got %d: %q", maxServiceNameLength, len(long), long)
+	}
+	if sm.serviceName(config) == config.Name {
+		t.Error("expected each service to get a unique name")
+	}
+
+	args = strings.Join(buildServiceArgs(ContainerConfig{Image: "app:latest"}), " ")
+	if strings.Contains(args, "--name") || strings.Contains(args, "--label") {
+		t.Errorf("expected no name or label without a namespace, got: %s", args)
+	}
+}
+
+func TestBuildRunArgs_Namespace(t *testing.T) {
+	cm := NewContainerManager(zap.NewNop())
+	var names []string
+	for _, namespace := range []string{"production", "staging"} {
+		args := strings.Join(buildRunArgs(ContainerConfig{
+			Image:     "app:latest",
+			Namespace: namespace,
+			Name:      cm.containerKey(namespace, "app:latest", "/api") + "_1",
+		}), " ")
+		name := fmt.Sprintf("--name %s_app-latest_api_1", namespace)
+		if !strings.Contains(args, name) {
+			t.Errorf("expected %q in args, got: %s", name, args)
+		}
+		if label := "--label serverless.namespace=" + namespace; !strings.Contains(args, label) {
+			t.Errorf("expected %q in args, got: %s", label, args)
+		}
+		names = append(names, name)
+	}
+	if names[0] == names[1] {
+		t.Error("expected containers of the same image in different namespaces to get different names")
+	}
+
+	args := strings.Join(buildRunArgs(ContainerConfig{Image: "app:latest"}), " ")
+	if strings.Contains(args, "--name") || strings.Contains(args, "--label") {
+		t.Errorf("expected no name or label without a namespace, got: %s", args)
+	}
+}
+
+func TestBuildRunArgs_LogConfig(t *testing.T) {
+	args := buildRunArgs(ContainerConfig{
+		Image:     "test:latest",
+		LogConfig: &ContainerLogConfig{MaxSize: "20m", MaxFile: 5, Compress: true},
+	})
+	joined := strings.Join(args, " ")
+	for _, flag := range []string{"--log-opt max-size=20m", "--log-opt max-file=5", "--log-opt compress=true"} {
+		if !strings.Contains(joined, flag) {
+			t.Errorf("expected %q in args, got: %s", flag, joined)
+		}
+	}
+	if !strings.HasSuffix(joined, "compress=true test:latest") {
+		t.Errorf("expected log options before the image, got: %s", joined)
+	}
+
+	joined = strings.Join(buildRunArgs(ContainerConfig{
+		Image:     "test:latest",
+		LogConfig: &ContainerLogConfig{MaxSize: "10m", MaxFile: 3},
+	}), " ")
+	if strings.Contains(joined, "compress") {
+		t.Errorf("expected no compress option unless enabled, got: %s", joined)
+	}
+
+	joined = strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(joined, "--log-opt") {
+		t.Errorf("expected no log options by default, got: %s", joined)
+	}
+}
+
+func TestBuildRunArgs_LogDriver(t *testing.T) {
+	args := buildRunArgs(ContainerConfig{
+		Image:     "test:latest",
+		LogDriver: "fluentd",
+		LogOpts:   map[string]string{"tag": "fn", "fluentd-address": "localhost:24224"},
+	})
+	joined := strings.Join(args, " ")
+	expected := "--log-driver fluentd --log-opt fluentd-address=localhost:24224 --log-opt tag=fn test:latest"
+	if !strings.HasSuffix(joined, expected) {
+		t.Errorf("expected args ending in %q, got: %s", expected, joined)
+	}
+
+	joined = strings.Join(buildRunArgs(ContainerConfig{Image: "test:latest"}), " ")
+	if strings.Contains(joined, "--log-driver") {
+		t.Errorf("expected no log driver by default, got: %s", joined)
+	}
+}
+
+func TestValidateContainerConfig_LogDriver(t *testing.T) {
+	for _, driver := range []string{"", "json-file", "journald", "syslog", "fluentd", "none"} {
+		if err := validateContainerConfig(ContainerConfig{Image: "alpine", LogDriver: driver}); err != nil {
+			t.Errorf("%q: unexpected error: %v", driver, err)
+		}
+	}
+	if err := validateContainerConfig(ContainerConfig{Image: "alpine", LogDriver: "syslogd"}); err == nil {
+		t.Error("expected an unknown log driver to be rejected")
+	}
+	if err := validateContainerConfig(ContainerConfig{Image: "alpine", LogOpts: map[string]string{" ": "x"}}); err == nil {
+		t.Error("expected an empty log option key to be rejected")
+	}
+}
+
+func TestValidateContainerConfig_EnvironmentSize(t *testing.T) {
+	large := strings.Repeat("x", 40*1024)
+	many := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		many[fmt.Sprintf("VAR_%02d", i)] = large
+	}
+
+	tests := []struct {
+		name        string
+		config      ContainerConfig
+		expectedErr string
+	}{
+		{
+			name:   "within default limits",
+			config: ContainerConfig{Image: "alpine", Environment: map[string]string{"KEY": large}},
+		},
+		{
+			name:        "value over default limit",
+			config:      ContainerConfig{Image: "alpine", Environment: map[string]string{"KEY": "v", "CERT": strings.Repeat("x", 65*1024)}},
+			expectedErr: "environment variable CERT is 66560 bytes long, exceeding the limit of 65536 bytes",
+		},
+		{
+			name:        "total over default limit",
+			config:      ContainerConfig{Image: "alpine", Environment: many},
+			expectedErr: "exceeding the limit of 1048576 bytes (largest variable: VAR_00, 40960 bytes)",
+		},
+		{
+			name:        "value over configured limit",
+			config:      ContainerConfig{Image: "alpine", Environment: map[string]string{"TOKEN": "0123456789"}, MaxEnvValueLength: 8},
+			expectedErr: "environment variable TOKEN is 10 bytes long, exceeding the limit of 8 bytes",
+		},
+		{
+			name:        "total over configured limit",
+			config:      ContainerConfig{Image: "alpine", Environment: map[string]string{"A": "12345", "B": "1"}, MaxEnvSize: 8},
+			expectedErr: "environment is 10 bytes in total, exceeding the limit of 8 bytes (largest variable: A, 5 bytes)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateContainerConfig(tt.config)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+// randomContainerConfig generates container configs for property tests. Its
+// strings are drawn from a small alphabet that includes blanks, so both valid
+// and invalid configs are produced.
+type randomContainerConfig struct {
+	config ContainerConfig
+}
+
+func randomConfigString(r *rand.Rand) string {
+	choices := []string{"", " ", "\t", "alpine", "nginx:latest", "/data", "KEY", "my image", "a"}
+	return choices[r.Intn(len(choices))]
+}
+
+// Generate implements quick.Generator
+func (randomContainerConfig) Generate(r *rand.Rand, size int) reflect.Value {
+	config := ContainerConfig{
+		Image:       randomConfigString(r),
+		Environment: make(map[string]string),
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		config.Environment[randomConfigString(r)] = randomConfigString(r)
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		config.Volumes = append(config.Volumes, VolumeMount{
+			Source:   randomConfigString(r),
+			Target:   randomConfigString(r),
+			ReadOnly: r.Intn(2) == 0,
+		})
+	}
+	return reflect.ValueOf(randomContainerConfig{config: config})
+}
+
+// TestValidateContainerConfig_Properties property-tests validateContainerConfig
+func TestValidateContainerConfig_Properties(t *testing.T) {
+	blank := func(s string) bool { return strings.TrimSpace(s) == "" }
+
+	// A config that passes validation has an image, env keys and volume paths
+	valid := func(c randomContainerConfig) bool {
+		if validateContainerConfig(c.config) != nil {
+			return true
+		}
+		if blank(c.config.Image) {
+			return false
+		}
+		for key := range c.config.Environment {
+			if blank(key) {
+				return false
+			}
+		}
+		for _, v := range c.config.Volumes {
+			if blank(v.Source) || blank(v.Target) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(valid, nil); err != nil {
+		t.Errorf("validated config violates invariants: %v", err)
+	}
+
+	// An empty image is always rejected
+	emptyImage := func(c randomContainerConfig) bool {
+		c.config.Image = ""
+		return validateContainerConfig(c.config) != nil
+	}
+	if err := quick.Check(emptyImage, nil); err != nil {
+		t.Errorf("config with empty image accepted: %v", err)
+	}
+
+	// An empty environment key is always rejected
+	emptyEnvKey := func(c randomContainerConfig) bool {
+		c.config.Environment[""] = "value"
+		return validateContainerConfig(c.config) != nil
+	}
+	if err := quick.Check(emptyEnvKey, nil); err != nil {
+		t.Errorf("config with empty env key accepted: %v", err)
+	}
+}
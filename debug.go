@@ -0,0 +1,172 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultDebugBodyLimit is the number of bytes of each body logged when
+// DebugBodies is set without a DebugBodyLimit
+const defaultDebugBodyLimit = 4096
+
+// debugRequestHeader asks for debugInfoHeader when the handler's Debug is set
+const debugRequestHeader = "X-Serverless-Debug"
+
+// debugInfoHeader carries a request's debugInfo as JSON
+const debugInfoHeader = "X-Serverless-Debug-Info"
+
+// debugInfo describes how a request was executed
+type debugInfo struct {
+	Function    string `json:"function"`
+	Image       string `json:"image"`
+	RequestID   string `json:"request_id"`
+	ContainerID string `json:"container_id"`
+	// Start is "cold" or "warm"; containers are not reused yet, so every
+	// start is cold
+	Start         string  `json:"start"`
+	StartMillis   float64 `json:"start_ms"`
+	ReadyMillis   float64 `json:"ready_ms"`
+	ElapsedMillis float64 `json:"elapsed_ms"`
+}
+
+// setDebugInfo adds debugInfoHeader to the response, with timings measured
+// up to the container passing its readiness check
+func setDebugInfo(w http.ResponseWriter, function *FunctionConfig, container *Container, timeline *Timeline) {
+	info := debugInfo{
+		Function:      function.Path,
+		Image:         function.Image,
+		RequestID:     timeline.RequestID,
+		ContainerID:   container.ID,
+		Start:         "cold",
+		StartMillis:   millis(timeline.ContainerStarted.Sub(*timeline.ContainerStartCalled)),
+		ReadyMillis:   millis(timeline.ReadyCheckPassed.Sub(*timeline.ContainerStarted)),
+		ElapsedMillis: millis(timeline.ReadyCheckPassed.Sub(*timeline.RequestReceived)),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	w.Header().Set(debugInfoHeader, string(data))
+}
+
+// millis converts d to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// redactedValue replaces sensitive values in debug logs
+const redactedValue = "[REDACTED]"
+
+// alwaysRedactedHeaders are never logged in clear text
+var alwaysRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// bodyCapture keeps the first limit bytes written to it and counts the rest
+type bodyCapture struct {
+	limit int
+	data  []byte
+	total int64
+}
+
+func newBodyCapture(limit int) *bodyCapture {
+	return &bodyCapture{limit: limit}
+}
+
+// Write never fails, so that capturing does not disturb the copy it observes
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	if room := c.limit - len(c.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		c.data = append(c.data, p[:room]...)
+	}
+	c.total += int64(len(p))
+	return len(p), nil
+}
+
+func (c *bodyCapture) truncated() bool {
+	return c.total > int64(len(c.data))
+}
+
+// teeBody returns a body that copies what is read from body into c
+func teeBody(body io.ReadCloser, c *bodyCapture) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, c), body}
+}
+
+// compileRedactFields returns a regex matching the values of the given JSON
+// fields, or nil if there are none
+func compileRedactFields(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	// Strings are matched up to their closing quote and other values up to
+	// the next delimiter, which also works on truncated bodies
+	return regexp.MustCompile(`("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+}
+
+// redactBody replaces the values of sensitive JSON fields in body
+func redactBody(body string, fields *regexp.Regexp) string {
+	if fields == nil {
+		return body
+	}
+	return fields.ReplaceAllString(body, `${1}"`+redactedValue+`"`)
+}
+
+// redactHeaders returns a copy of header with sensitive values replaced
+func redactHeaders(header http.Header, names []string) http.Header {
+	redacted := header.Clone()
+	for _, list := range [][]string{alwaysRedactedHeaders, names} {
+		for _, name := range list {
+			if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+				redacted.Set(name, redactedValue)
+			}
+		}
+	}
+	return redacted
+}
+
+// logDebugBodies logs a proxied exchange with bodies truncated to the
+// function's DebugBodyLimit and sensitive values redacted
+func (h *Handler) logDebugBodies(function *FunctionConfig, req *http.Request, reqBody *bodyCapture, resp *http.Response, respBody *bodyCapture) {
+	h.logger.Debug("proxied request",
+		zap.String("path", function.Path),
+		zap.String("method", req.Method),
+		zap.String("uri", req.URL.RequestURI()),
+		zap.Any("headers", redactHeaders(req.Header, function.DebugRedact)),
+		zap.String("body", redactBody(string(reqBody.data), function.redactFields)),
+		zap.Int64("body_size", reqBody.total),
+		zap.Bool("body_truncated", reqBody.truncated()))
+	h.logger.Debug("proxied response",
+		zap.String("path", function.Path),
+		zap.Int("status", resp.StatusCode),
+		zap.Any("headers", redactHeaders(resp.Header, function.DebugRedact)),
+		zap.String("body", redactBody(string(respBody.data), function.redactFields)),
+		zap.Int64("body_size", respBody.total),
+		zap.Bool("body_truncated", respBody.truncated()))
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBodyCapture(t *testing.T) {
+	c := newBodyCapture(5)
+	for _, chunk := range []string{"abc", "defg", "hi"} {
+		if n, err := c.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if string(c.data) != "abcde" || c.total != 9 || !c.truncated() {
+		t.Errorf("expected 5 of 9 bytes captured, got %q of %d (truncated=%v)", c.data, c.total, c.truncated())
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	fields := compileRedactFields([]string{"password", "api.key"})
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{
+			body:     `{"user":"bob","password":"s3cr\"et","age":3}`,
+			expected: `{"user":"bob","password":"[REDACTED]","age":3}`,
+		},
+		{
+			body:     `{"api.key": 12345, "apixkey": 1}`,
+			expected: `{"api.key": "[REDACTED]", "apixkey": 1}`,
+		},
+		{
+			body:     `{"password":"truncat`,
+			expected: `{"password":"[REDACTED]"`,
+		},
+		{
+			body:     `not json at all`,
+			expected: `not json at all`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := redactBody(tt.body, fields); got != tt.expected {
+			t.Errorf("redactBody(%q) = %q, want %q", tt.body, got, tt.expected)
+		}
+	}
+	if got := redactBody(`{"password":"x"}`, nil); got != `{"password":"x"}` {
+		t.Errorf("expected no redaction without fields, got %q", got)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Api-Key":     {"key"},
+		"Accept":        {"*/*"},
+	}
+	redacted := redactHeaders(header, []string{"x-api-key"})
+	if redacted.Get("Authorization") != redactedValue || redacted.Get("X-Api-Key") != redactedValue {
+		t.Errorf("expected sensitive headers to be redacted, got %v", redacted)
+	}
+	if redacted.Get("Accept") != "*/*" {
+		t.Errorf("expected other headers to be kept, got %v", redacted)
+	}
+	if header.Get("Authorization") != "Bearer token" {
+		t.Error("expected the original headers to be left unchanged")
+	}
+}
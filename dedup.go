@@ -0,0 +1,147 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// DedupConfig acknowledges repeated webhook deliveries without running the
+// function again. Deliveries are identified by the value of a request header.
+type DedupConfig struct {
+	// Header holds the delivery ID, e.g. X-Webhook-ID.
+	Header string `json:"header,omitempty"`
+
+	// Window is how long a delivery ID is remembered.
+	Window caddy.Duration `json:"window,omitempty"`
+}
+
+// webhookDedup remembers recently processed delivery IDs until they expire,
+// and the IDs of deliveries still being processed.
+type webhookDedup struct {
+	window     time.Duration
+	seen       sync.Map // delivery ID -> expiry time.Time
+	inProgress sync.Map // delivery ID -> struct{}
+	lastPrune  atomic.Int64
+}
+
+func newWebhookDedup(window time.Duration) *webhookDedup {
+	d := &webhookDedup{window: window}
+	d.lastPrune.Store(time.Now().UnixNano())
+	return d
+}
+
+// deliveryState is what begin found out about a delivery ID
+type deliveryState int
+
+const (
+	// deliveryNew is a delivery to process; finish must be called once it is
+	deliveryNew deliveryState = iota
+
+	// deliveryDuplicate was already processed within the window
+	deliveryDuplicate
+
+	// deliveryInProgress is being processed by an earlier attempt
+	deliveryInProgress
+)
+
+// begin claims id for processing unless it was processed within the window
+// or an earlier attempt is still in progress.
+func (d *webhookDedup) begin(id string, now time.Time) deliveryState {
+	d.prune(now)
+
+	if _, loaded := d.inProgress.LoadOrStore(id, struct{}{}); loaded {
+		return deliveryInProgress
+	}
+	if expiry, ok := d.seen.Load(id); ok && now.Before(expiry.(time.Time)) {
+		d.inProgress.Delete(id)
+		return deliveryDuplicate
+	}
+	return deliveryNew
+}
+
+// finish releases id after begin claimed it. Processed deliveries are
+// remembered for the window; failed ones are not, so that their retries are
+// processed.
+func (d *webhookDedup) finish(id string, now time.Time, processed bool) {
+	// Record the ID before releasing it so that no retry sees neither
+	if processed {
+		d.seen.Store(id, now.Add(d.window))
+	}
+	d.inProgress.Delete(id)
+}
+
+// prune drops expired IDs at most once per window.
+func (d *webhookDedup) prune(now time.Time) {
+	last := d.lastPrune.Load()
+	if now.UnixNano()-last < int64(d.window) || !d.lastPrune.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	d.seen.Range(func(key, value any) bool {
+		if !now.Before(value.(time.Time)) {
+			d.seen.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// statusRecorder records the status of the response written through it,
+// which is 0 until the first WriteHeader, Write or Flush.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying writer if it supports flushing
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Push pushes through the underlying writer if it supports HTTP/2 push
+func (r *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := r.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookDedup_Expiry(t *testing.T) {
+	d := newWebhookDedup(time.Minute)
+	now := time.Now()
+
+	if d.begin("id", now) != deliveryNew {
+		t.Fatal("first delivery not reported as new")
+	}
+	d.finish("id", now, true)
+	if d.begin("id", now.Add(30*time.Second)) != deliveryDuplicate {
+		t.Error("expected delivery within the window to be a duplicate")
+	}
+	if d.begin("id", now.Add(2*time.Minute)) != deliveryNew {
+		t.Error("expected delivery after the window not to be a duplicate")
+	}
+	d.finish("id", now.Add(2*time.Minute), false)
+
+	// Expired IDs are pruned
+	d.begin("other", now.Add(10*time.Minute))
+	if _, ok := d.seen.Load("id"); ok {
+		t.Error("expected expired ID to be pruned")
+	}
+}
+
+func TestWebhookDedup_InProgress(t *testing.T) {
+	d := newWebhookDedup(time.Minute)
+	now := time.Now()
+
+	if d.begin("id", now) != deliveryNew {
+		t.Fatal("first delivery not reported as new")
+	}
+	if d.begin("id", now) != deliveryInProgress {
+		t.Error("expected a retry during the first attempt to be in progress")
+	}
+
+	// A failed attempt is not remembered
+	d.finish("id", now, false)
+	if d.begin("id", now) != deliveryNew {
+		t.Fatal("expected a retry of a failed delivery to be new")
+	}
+	d.finish("id", now, true)
+	if d.begin("id", now) != deliveryDuplicate {
+		t.Error("expected a retry of a processed delivery to be a duplicate")
+	}
+}
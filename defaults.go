@@ -0,0 +1,71 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// uninheritedFields identify a function, so they are never taken from the
+// handler's DefaultFunctionConfig
+var uninheritedFields = map[string]bool{"Name": true, "Path": true}
+
+// imageFields choose what a function runs. A function setting any of them
+// inherits none, so that a default image does not clash with the function's
+// own versions or compose file.
+var imageFields = []string{"Image", "Versions", "ComposeFile", "ComposeService"}
+
+// mergeFunctionConfig returns specific with each of its exported fields that
+// holds the zero value set from defaults. Fields are copied deeply, so the
+// functions sharing defaults do not share slices or maps. A bool set in
+// defaults cannot be turned off again by a function.
+func mergeFunctionConfig(defaults, specific FunctionConfig) (FunctionConfig, error) {
+	data, err := json.Marshal(defaults)
+	if err != nil {
+		return specific, err
+	}
+	var fresh FunctionConfig
+	if err := json.Unmarshal(data, &fresh); err != nil {
+		return specific, err
+	}
+
+	merged := reflect.ValueOf(&specific).Elem()
+	skip := make(map[string]bool, len(uninheritedFields)+len(imageFields))
+	for name := range uninheritedFields {
+		skip[name] = true
+	}
+	for _, name := range imageFields {
+		if !merged.FieldByName(name).IsZero() {
+			for _, name := range imageFields {
+				skip[name] = true
+			}
+			break
+		}
+	}
+
+	source := reflect.ValueOf(fresh)
+	fields := merged.Type()
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" || skip[field.Name] {
+			continue
+		}
+		if value := merged.Field(i); value.IsZero() {
+			value.Set(source.Field(i))
+		}
+	}
+	return specific, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMergeFunctionConfig(t *testing.T) {
+	defaults := FunctionConfig{
+		Name:        "shared",
+		Path:        "/shared",
+		Image:       "base:latest",
+		Timeout:     caddy.Duration(5 * time.Second),
+		Memory:      "256m",
+		Environment: map[string]string{"REGION": "eu"},
+	}
+	merged, err := mergeFunctionConfig(defaults, FunctionConfig{Path: "/api", Memory: "512m"})
+	if err != nil {
+		t.Fatalf("mergeFunctionConfig failed: %v", err)
+	}
+	if merged.Name != "" || merged.Path != "/api" {
+		t.Errorf("expected name and path not to be inherited, got %q and %q", merged.Name, merged.Path)
+	}
+	if merged.Image != "base:latest" || merged.Timeout != defaults.Timeout || merged.Memory != "512m" {
+		t.Errorf("expected unset fields to be inherited and set ones kept, got %+v", merged)
+	}
+	merged.Environment["REGION"] = "us"
+	if defaults.Environment["REGION"] != "eu" {
+		t.Error("expected inherited maps to be copies")
+	}
+
+	versioned, err := mergeFunctionConfig(defaults, FunctionConfig{Path: "/canary", Versions: []FunctionVersion{{Image: "app:v2", Weight: 1}}})
+	if err != nil {
+		t.Fatalf("mergeFunctionConfig failed: %v", err)
+	}
+	if versioned.Image != "" {
+		t.Errorf("expected a function with versions not to inherit the default image, got %q", versioned.Image)
+	}
+}
+
+func TestHandler_DefaultFunctionConfig(t *testing.T) {
+	h := &Handler{
+		DefaultFunctionConfig: &FunctionConfig{
+			Methods: []string{"GET"},
+			Timeout: caddy.Duration(5 * time.Second),
+			Port:    9000,
+		},
+		Functions: []FunctionConfig{
+			{Path: "/api/inherits", Image: "app:latest"},
+			{Path: "/api/overrides", Image: "app:latest", Timeout: caddy.Duration(time.Minute)},
+		},
+		containerManager: NewMockContainerManager(),
+		logger:           zaptest.NewLogger(t),
+	}
+	if err := h.provision(); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	if got := time.Duration(h.Functions[0].Timeout); got != 5*time.Second {
+		t.Errorf("expected the default timeout to be inherited, got %v", got)
+	}
+	if got := time.Duration(h.Functions[1].Timeout); got != time.Minute {
+		t.Errorf("expected the function's own timeout to be kept, got %v", got)
+	}
+	if h.Functions[0].Port != 9000 || h.Functions[0].ReadyPort != 9000 {
+		t.Errorf("expected the default port to be inherited before ready_port defaults to it, got %d and %d", h.Functions[0].Port, h.Functions[0].ReadyPort)
+	}
+	if h.findMatchingFunction(fakeRequest("GET", "/api/inherits")) == nil {
+		t.Error("expected the function to be routed for the default methods")
+	}
+	if err := h.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// imageDigestRegex matches the digest of an image pinned as name@digest
+var imageDigestRegex = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// imageDigest returns the digest of an image pinned as name@sha256:..., and
+// whether the image is pinned at all
+func imageDigest(image string) (string, bool) {
+	i := strings.LastIndex(image, "@")
+	if i < 0 {
+		return "", false
+	}
+	return image[i+1:], true
+}
+
+// verifyImageDigest checks that the image the container runs was pulled by
+// the expected digest, guarding against a locally retagged or replaced image
+func (cm *ContainerManager) verifyImageDigest(ctx context.Context, containerID, digest string) error {
+	output, err := exec.CommandContext(ctx, "docker", "inspect", "--format", "{{.Image}}", containerID).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect container image: %v", err)
+	}
+	imageID := strings.TrimSpace(string(output))
+	output, err = exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{json .RepoDigests}}", imageID).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect image %s: %v", imageID, err)
+	}
+	return matchRepoDigests(output, digest)
+}
+
+// matchRepoDigests checks the JSON list of repo digests printed by docker
+// image inspect for the expected digest
+func matchRepoDigests(output []byte, digest string) error {
+	var repoDigests []string
+	if err := json.Unmarshal(output, &repoDigests); err != nil {
+		return fmt.Errorf("failed to parse image inspect output: %v", err)
+	}
+	for _, repoDigest := range repoDigests {
+		if got, ok := imageDigest(repoDigest); ok && got == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("image digest mismatch: expected %s, image has %v", digest, repoDigests)
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const (
+	testDigest  = "sha256:9b2c1d0f3a8e4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"
+	otherDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+func TestValidateContainerConfig_ImageDigest(t *testing.T) {
+	tests := []struct {
+		image   string
+		wantErr bool
+	}{
+		{image: "alpine:3.19"},
+		{image: "alpine@" + testDigest},
+		{image: "registry.example.com:5000/team/app:1.2@" + testDigest},
+		{image: "alpine@sha256:abc", wantErr: true},
+		{image: "alpine@md5:" + strings.Repeat("a", 32), wantErr: true},
+		{image: "alpine@" + strings.ToUpper(testDigest), wantErr: true},
+	}
+	for _, tt := range tests {
+		err := validateContainerConfig(ContainerConfig{Image: tt.image})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.image, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchRepoDigests(t *testing.T) {
+	output := []byte(`["alpine@` + testDigest + `","mirror.example.com/alpine@` + otherDigest + `"]`)
+	if err := matchRepoDigests(output, testDigest); err != nil {
+		t.Errorf("expected match: %v", err)
+	}
+	if err := matchRepoDigests([]byte(`["alpine@`+otherDigest+`"]`), testDigest); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected mismatch, got %v", err)
+	}
+	// Locally built images have no repo digests
+	if err := matchRepoDigests([]byte(`[]`), testDigest); err == nil {
+		t.Error("expected an image without repo digests to fail")
+	}
+}
+
+// fakeDocker puts a docker script on PATH that runs containers of an image
+// whose only repo digest is repoDigest, and logs each invocation
+func fakeDocker(t *testing.T, repoDigest string) (calls func() string) {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+case "$1 $2" in
+"run "*) echo 0123456789ab ;;
+"inspect --format") echo sha256:feedface ;;
+"inspect "*) echo '[{}]' ;;
+"image inspect") echo '["alpine@` + repoDigest + `"]' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func() string {
+		data, _ := os.ReadFile(log)
+		return string(data)
+	}
+}
+
+func TestStartContainer_ImageDigest(t *testing.T) {
+	t.Run("match", func(t *testing.T) {
+		fakeDocker(t, testDigest)
+		cm := NewContainerManager(zap.NewNop())
+		container, err := cm.StartContainer(context.Background(), ContainerConfig{Image: "alpine@" + testDigest, Port: 8080})
+		if err != nil {
+			t.Fatalf("StartContainer: %v", err)
+		}
+		if container.ID != "0123456789ab" {
+			t.Errorf("container ID = %q", container.ID)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		calls := fakeDocker(t, otherDigest)
+		cm := NewContainerManager(zap.NewNop())
+		_, err := cm.StartContainer(context.Background(), ContainerConfig{Image: "alpine@" + testDigest, Port: 8080})
+		if err == nil || !strings.Contains(err.Error(), "image digest mismatch") {
+			t.Fatalf("expected a digest mismatch, got %v", err)
+		}
+		if !strings.Contains(calls(), "stop 0123456789ab") {
+			t.Errorf("expected the container to be stopped, docker calls:\n%s", calls())
+		}
+		if len(cm.containers) != 0 {
+			t.Errorf("container should not be tracked")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultEventQueueSize is the number of lifecycle events that may be
+// waiting for delivery before new events are dropped.
+const defaultEventQueueSize = 256
+
+// eventFlushTimeout bounds how long Cleanup waits for queued events to be
+// delivered before dropping the rest.
+const eventFlushTimeout = 5 * time.Second
+
+// Container lifecycle event types sent to the event webhook.
+const (
+	eventContainerStarted = "container_started"
+	eventContainerStopped = "container_stopped"
+	eventContainerFailed  = "container_failed"
+)
+
+// lifecycleEvent is the JSON payload POSTed to the event webhook.
+type lifecycleEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// eventEmitter delivers lifecycle events to a webhook in the background.
+// Events are queued without blocking; when the queue is full they are
+// dropped and counted in the events_dropped_total metric.
+type eventEmitter struct {
+	url     string
+	client  *http.Client
+	logger  *zap.Logger
+	queue   chan lifecycleEvent
+	dropped atomic.Uint64
+	wg      sync.WaitGroup
+
+	// ctx is cancelled when close gives up on delivering queued events
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// validWebhookURL reports whether rawURL is an absolute http(s) URL.
+func validWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// newEventEmitter creates an emitter and starts its delivery goroutine.
+func newEventEmitter(url string, queueSize int, logger *zap.Logger) *eventEmitter {
+	e := &eventEmitter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		queue:  make(chan lifecycleEvent, queueSize),
+	}
+	initServerlessMetrics()
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// emit queues an event for delivery. It is safe to call on a nil emitter.
+func (e *eventEmitter) emit(eventType string, function *FunctionConfig, containerID string, err error) {
+	if e == nil {
+		return
+	}
+	ev := lifecycleEvent{
+		Type:        eventType,
+		Time:        time.Now().UTC(),
+		ContainerID: containerID,
+		Image:       function.Image,
+		Path:        function.Path,
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- ev:
+	default:
+		dropped := e.dropped.Add(1)
+		observeDroppedEvents(1)
+		e.logger.Warn("event queue full, dropping lifecycle event",
+			zap.String("type", eventType),
+			zap.Uint64("dropped_total", dropped))
+	}
+}
+
+// droppedEvents returns the number of events dropped because the queue was full.
+func (e *eventEmitter) droppedEvents() uint64 {
+	if e == nil {
+		return 0
+	}
+	return e.dropped.Load()
+}
+
+// close stops accepting events and waits for queued events to be delivered
+// until ctx is done. Events still queued then are dropped and logged.
+func (e *eventEmitter) close(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	defer e.cancel()
+
+	delivered := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		// Abort the delivery in flight; run drops what is still queued
+		e.cancel()
+		<-delivered
+	}
+}
+
+func (e *eventEmitter) run() {
+	defer e.wg.Done()
+	lost := 0
+	for ev := range e.queue {
+		if e.ctx.Err() != nil {
+			lost++
+			continue
+		}
+		if err := e.send(ev); err != nil {
+			if e.ctx.Err() != nil {
+				lost++
+				continue
+			}
+			e.logger.Warn("failed to deliver lifecycle event",
+				zap.String("type", ev.Type),
+				zap.String("url", e.url),
+				zap.Error(err))
+		}
+	}
+	if lost > 0 {
+		observeDroppedEvents(lost)
+		e.logger.Warn("dropped lifecycle events that were not delivered before shutdown",
+			zap.String("url", e.url),
+			zap.Int("lost", lost))
+	}
+}
+
+func (e *eventEmitter) send(ev lifecycleEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestEventWebhook_StartStop(t *testing.T) {
+	var mu sync.Mutex
+	var events []lifecycleEvent
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev lifecycleEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	handler := &Handler{
+		EventWebhook: webhook.URL,
+		Functions: []FunctionConfig{
+			{
+				Methods: []string{"GET"},
+				Path:    "/api/events",
+				Image:   "test:latest",
+			},
+		},
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+	handler.containerManager = NewMockContainerManager()
+	handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("ok")),
+			Header:     make(http.Header),
+		},
+	}}
+
+	req := fakeRequest("GET", "/api/events")
+	w := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	if err := handler.ServeHTTP(w, req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Cleanup waits for queued events to be delivered
+	if err := handler.Cleanup(); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(events), events)
+	}
+	for i, wantType := range []string{eventContainerStarted, eventContainerStopped} {
+		ev := events[i]
+		if ev.Type != wantType {
+			t.Errorf("event %d: expected type %s, got %s", i, wantType, ev.Type)
+		}
+		if ev.ContainerID != "mock-container-id" {
+			t.Errorf("event %d: expected container ID mock-container-id, got %s", i, ev.ContainerID)
+		}
+		if ev.Image != "test:latest" || ev.Path != "/api/events" {
+			t.Errorf("event %d: unexpected function fields: %+v", i, ev)
+		}
+		if ev.Time.IsZero() {
+			t.Errorf("event %d: missing time", i)
+		}
+	}
+}
+
+func TestEventEmitter_DropsWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-block
+	}))
+	defer webhook.Close()
+
+	e := newEventEmitter(webhook.URL, 1, zap.NewNop())
+	function := &FunctionConfig{Image: "test:latest", Path: "/api"}
+
+	// One event may be in flight and one queued; the rest must be dropped
+	// without blocking.
+	for i := 0; i < 10; i++ {
+		e.emit(eventContainerStarted, function, "id", nil)
+	}
+	if dropped := e.droppedEvents(); dropped < 8 {
+		t.Errorf("expected at least 8 dropped events, got %d", dropped)
+	}
+
+	close(block)
+	e.close(context.Background())
+
+	// Emitting after close is a no-op
+	e.emit(eventContainerStopped, function, "id", nil)
+}
+
+func TestEventEmitter_CloseDeadline(t *testing.T) {
+	block := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-block
+	}))
+	defer webhook.Close()
+	defer close(block)
+
+	core, logs := observer.New(zap.WarnLevel)
+	e := newEventEmitter(webhook.URL, 8, zap.New(core))
+	function := &FunctionConfig{Image: "test:latest", Path: "/api"}
+	for i := 0; i < 4; i++ {
+		e.emit(eventContainerStarted, function, "id", nil)
+	}
+
+	// An unreachable webhook must not hold up closing past the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	e.close(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("close took %v despite its deadline", elapsed)
+	}
+
+	entries := logs.FilterMessage("dropped lifecycle events that were not delivered before shutdown").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one log entry for the lost events, got %d", len(entries))
+	}
+	if lost := entries[0].ContextMap()["lost"]; lost != int64(4) {
+		t.Errorf("expected 4 lost events, got %v", lost)
+	}
+}
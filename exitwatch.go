@@ -0,0 +1,68 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// exitPollInterval is how often a container is checked for having exited
+// while it serves a request
+const exitPollInterval = 500 * time.Millisecond
+
+// serverlessErrorHeader tells clients why the plugin failed their request
+const serverlessErrorHeader = "X-Serverless-Error"
+
+// errContainerExited cancels a proxied request whose container exited
+var errContainerExited = errors.New("container exited before responding")
+
+// watchContainerExit polls whether the container is still running until ctx
+// is done, and cancels ctx with errContainerExited when it is not. Errors
+// checking the container are logged and polling goes on, except for managers
+// that cannot tell, which are not polled.
+func (h *Handler) watchContainerExit(ctx context.Context, cancel context.CancelCauseFunc, manager ContainerManagerInterface, container *Container, function *FunctionConfig) {
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		running, err := manager.ContainerRunning(ctx, container.ID)
+		switch {
+		case errors.Is(err, errRunningNotSupported):
+			return
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			h.logger.Debug("failed to check whether container is running",
+				zap.String("container_id", container.ID), zap.Error(err))
+		case !running:
+			h.errorLogFor(function).Error("container exited before responding",
+				zap.String("event", "container_exited"),
+				zap.String("path", function.Path),
+				zap.String("container_id", container.ID))
+			h.events.emit(eventContainerFailed, function, container.ID, errContainerExited)
+			cancel(errContainerExited)
+			return
+		}
+	}
+}
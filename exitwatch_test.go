@@ -0,0 +1,62 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestContainerManager_ContainerRunning(t *testing.T) {
+	// A fake docker stands in for docker inspect, answering by container ID
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$4" in
+running) echo true ;;
+exited) echo false ;;
+gone) echo "Error: No such object: gone" >&2; exit 1 ;;
+*) echo "Cannot connect to the Docker daemon" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm := NewContainerManager(zap.NewNop())
+	tests := []struct {
+		id      string
+		running bool
+		wantErr bool
+	}{
+		{id: "running", running: true},
+		{id: "exited"},
+		{id: "gone"},
+		{id: "daemon-down", wantErr: true},
+	}
+	for _, tt := range tests {
+		running, err := cm.ContainerRunning(context.Background(), tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.id, err)
+		}
+		if running != tt.running {
+			t.Errorf("%s: expected running %v, got %v", tt.id, tt.running, running)
+		}
+	}
+}
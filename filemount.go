@@ -0,0 +1,141 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileMount mounts a single host file into the container after checking
+// that its content matches an expected SHA-256 digest. This keeps stale or
+// tampered scripts and config files from being deployed.
+type FileMount struct {
+	// HostPath is the absolute path of the file on the host.
+	HostPath string `json:"host_path,omitempty"`
+
+	// ContainerPath is the absolute path the file is mounted at.
+	ContainerPath string `json:"container_path,omitempty"`
+
+	// SHA256 is the expected hex-encoded SHA-256 digest of the file.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// ReadOnly mounts the file read-only.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// validate checks that the mount is fully specified.
+func (m FileMount) validate() error {
+	if !filepath.IsAbs(m.HostPath) {
+		return fmt.Errorf("host path must be absolute")
+	}
+	if !filepath.IsAbs(m.ContainerPath) {
+		return fmt.Errorf("container path must be absolute")
+	}
+	if sum, err := hex.DecodeString(m.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("sha256 must be a %d-character hex digest", sha256.Size*2)
+	}
+	return nil
+}
+
+// verify hashes the host file and compares it with the expected digest.
+func (m FileMount) verify() error {
+	f, err := os.Open(m.HostPath)
+	if err != nil {
+		return fmt.Errorf("file mount %s: %v", m.HostPath, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("file mount %s: %v", m.HostPath, err)
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, m.SHA256) {
+		return fmt.Errorf("file mount %s: checksum mismatch (expected sha256 %s, got %s)",
+			m.HostPath, strings.ToLower(m.SHA256), actual)
+	}
+	return nil
+}
+
+// containerVolumes verifies the function's file mounts and returns them
+// together with its volumes and the inline script and localtime mounts, if
+// any, ready to pass to the container.
+func containerVolumes(function *FunctionConfig) ([]VolumeMount, error) {
+	if len(function.FileMounts) == 0 && !function.MountLocaltime && function.InlineScript == "" {
+		return function.Volumes, nil
+	}
+
+	volumes := make([]VolumeMount, 0, len(function.Volumes)+len(function.FileMounts)+2)
+	volumes = append(volumes, function.Volumes...)
+	if function.InlineScript != "" {
+		volumes = append(volumes, function.inlineScript)
+	}
+	if function.MountLocaltime {
+		volumes = append(volumes, VolumeMount{
+			Source:   zoneinfoPath(function.Timezone),
+			Target:   "/etc/localtime",
+			ReadOnly: true,
+		})
+	}
+	for _, m := range function.FileMounts {
+		if err := m.verify(); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, VolumeMount{
+			Source:   m.HostPath,
+			Target:   m.ContainerPath,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return volumes, nil
+}
+
+// writeInlineScript writes script to a temporary file on the host and
+// returns a read-only mount placing it at /tmp/<hash>.sh in the container.
+func writeInlineScript(script string) (VolumeMount, error) {
+	sum := sha256.Sum256([]byte(script))
+	hash := hex.EncodeToString(sum[:])[:16]
+
+	f, err := os.CreateTemp("", "serverless-"+hash+"-*.sh")
+	if err != nil {
+		return VolumeMount{}, err
+	}
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return VolumeMount{}, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return VolumeMount{}, err
+	}
+	// The container may run as any user, so the script must be world-readable.
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return VolumeMount{}, err
+	}
+
+	return VolumeMount{
+		Source:   f.Name(),
+		Target:   "/tmp/" + hash + ".sh",
+		ReadOnly: true,
+	}, nil
+}
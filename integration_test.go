@@ -16,18 +16,31 @@ package serverless
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // fakeRequest is a helper to create mock HTTP requests for testing
@@ -44,6 +57,23 @@ func fakeRequest(method, path string) *http.Request {
 	return r.WithContext(ctx)
 }
 
+// newTestHandler is serverlesstest.NewTestHandler for the package's own
+// tests, which cannot import it
+func newTestHandler(t testing.TB, functions []FunctionConfig, cm ContainerManagerInterface, client *http.Client) (*Handler, error) {
+	if cm == nil {
+		cm = NewMockContainerManager()
+	}
+	h := &Handler{
+		Functions:  append([]FunctionConfig(nil), functions...),
+		HTTPClient: client,
+	}
+	if err := h.ProvisionWith(cm, zaptest.NewLogger(t)); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = h.Cleanup() })
+	return h, nil
+}
+
 // MockRoundTripper is a custom http.RoundTripper for mocking HTTP responses
 type MockRoundTripper struct {
 	Response    *http.Response
@@ -62,75 +92,6 @@ func (m *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	return m.Response, nil
 }
 
-// MockContainerManager is a mock implementation for testing
-type MockContainerManager struct {
-	startContainerFn  func(ctx context.Context, config ContainerConfig) (*Container, error)
-	containers        map[string]*Container
-	shouldFail        bool
-}
-
-func NewMockContainerManager() *MockContainerManager {
-	m := &MockContainerManager{
-		containers: make(map[string]*Container),
-	}
-
-	// Set default StartContainer implementation
-	m.startContainerFn = func(_ context.Context, _ ContainerConfig) (*Container, error) {
-		if m.shouldFail {
-			return nil, &MockError{message: "mock container start failure"}
-		}
-
-		container := &Container{
-			ID:   "mock-container-id",
-			IP:   "127.0.0.1",
-			Port: 8080,
-		}
-
-		m.containers[container.ID] = container
-		return container, nil
-	}
-
-	return m
-}
-
-// StartContainer implements ContainerManagerInterface by calling the function field
-func (m *MockContainerManager) StartContainer(ctx context.Context, config ContainerConfig) (*Container, error) {
-	return m.startContainerFn(ctx, config)
-}
-
-// SetStartContainerFunc allows overriding the StartContainer behavior
-func (m *MockContainerManager) SetStartContainerFunc(fn func(ctx context.Context, config ContainerConfig) (*Container, error)) {
-	m.startContainerFn = fn
-}
-
-// Ensure MockContainerManager implements ContainerManagerInterface
-var _ ContainerManagerInterface = (*MockContainerManager)(nil)
-
-func (m *MockContainerManager) WaitForReady(_ context.Context, _ *Container, timeout time.Duration, port int) error {
-	if m.shouldFail {
-		return &MockError{message: "mock container not ready"}
-	}
-	return nil
-}
-
-func (m *MockContainerManager) StopContainer(_ context.Context, containerID string) error {
-	delete(m.containers, containerID)
-	return nil
-}
-
-func (m *MockContainerManager) Cleanup() error {
-	m.containers = make(map[string]*Container)
-	return nil
-}
-
-type MockError struct {
-	message string
-}
-
-func (e *MockError) Error() string {
-	return e.message
-}
-
 // TestHandler_Integration tests the complete flow with mocked Docker
 func TestHandler_Integration(t *testing.T) {
 	// Create handler with mock container manager
@@ -202,9 +163,8 @@ func TestHandler_Integration(t *testing.T) {
 	}
 
 	// Check that container was started and stopped
-	if len(mockCM.containers) != 0 {
-		t.Errorf("expected containers to be cleaned up, but %d remain", len(mockCM.containers))
-	}
+	mockCM.AssertCalled(t, "StartContainer", 1)
+	mockCM.AssertCalled(t, "StopContainer", 1)
 }
 
 // TestHandler_FullProxyIntegration tests the complete proxy flow with a real backend server
@@ -247,15 +207,12 @@ func TestHandler_FullProxyIntegration(t *testing.T) {
 	mockCM := NewMockContainerManager()
 
 	// Override StartContainer to return a container pointing to our test server
-	originalStartContainer := mockCM.startContainerFn
 	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
-		container := &Container{
+		return &Container{
 			ID:   "test-container-id",
 			IP:   backendHost,
 			Port: backendPortInt,
-		}
-		mockCM.containers[container.ID] = container
-		return container, nil
+		}, nil
 	})
 
 	// Create handler with the mock container manager
@@ -396,12 +353,8 @@ func TestHandler_FullProxyIntegration(t *testing.T) {
 	})
 
 	// Verify container cleanup
-	if len(mockCM.containers) != 0 {
-		t.Errorf("expected containers to be cleaned up, but %d remain", len(mockCM.containers))
-	}
-
-	// Restore original StartContainer method
-	mockCM.startContainerFn = originalStartContainer
+	mockCM.AssertCalled(t, "StartContainer", 2)
+	mockCM.AssertCalled(t, "StopContainer", 2)
 }
 
 // TestHandler_Integration_ProxyFailure tests the proxy failure path
@@ -459,9 +412,8 @@ func TestHandler_Integration_ProxyFailure(t *testing.T) {
 	}
 
 	// Check that container was started and stopped
-	if len(mockCM.containers) != 0 {
-		t.Errorf("expected containers to be cleaned up, but %d remain", len(mockCM.containers))
-	}
+	mockCM.AssertCalled(t, "StartContainer", 1)
+	mockCM.AssertCalled(t, "StopContainer", 1)
 }
 
 // TestHandler_NoMatchPassesToNext tests that unmatched requests pass to next handler
@@ -533,7 +485,8 @@ func TestHandler_ContainerStartFailure(t *testing.T) {
 
 	// Use a mock container manager that fails
 	mockCM := NewMockContainerManager()
-	mockCM.shouldFail = true
+	failAll := 0
+	mockCM.FailAfterNStarts = &failAll
 	handler.containerManager = mockCM
 
 	req := fakeRequest("GET", "/api/test")
@@ -558,6 +511,11 @@ func TestHandler_ContainerStartFailure(t *testing.T) {
 	} else {
 		t.Error("expected HandlerError")
 	}
+
+	// A container that failed to start is neither probed nor stopped
+	mockCM.AssertCalled(t, "StartContainer", 1)
+	mockCM.AssertNotCalled(t, "WaitForReady")
+	mockCM.AssertNotCalled(t, "StopContainer")
 }
 
 // TestHandler_JSONConfiguration tests JSON configuration parsing
@@ -646,24 +604,99 @@ func TestHandler_JSONConfiguration(t *testing.T) {
 	}
 }
 
+// TestDurationJSONRoundTrip checks that duration fields accept Go duration
+// strings and keep their values through marshalling
+func TestDurationJSONRoundTrip(t *testing.T) {
+	input := `{
+		"timeout": "1m30s",
+		"post_start_timeout": "1.5s",
+		"cold_start_budget": "250ms",
+		"webhook_dedup": {"header": "X-Webhook-ID", "window": "1h"},
+		"transport": {"dial_timeout": "2s", "tls_handshake_timeout": "10s", "response_header_timeout": "1m0.001s"}
+	}`
+	var fn FunctionConfig
+	if err := json.Unmarshal([]byte(input), &fn); err != nil {
+		t.Fatalf("failed to unmarshal durations: %v", err)
+	}
+	want := map[string]caddy.Duration{
+		"timeout":                 caddy.Duration(90 * time.Second),
+		"post_start_timeout":      caddy.Duration(1500 * time.Millisecond),
+		"cold_start_budget":       caddy.Duration(250 * time.Millisecond),
+		"window":                  caddy.Duration(time.Hour),
+		"dial_timeout":            caddy.Duration(2 * time.Second),
+		"tls_handshake_timeout":   caddy.Duration(10 * time.Second),
+		"response_header_timeout": caddy.Duration(time.Minute + time.Millisecond),
+	}
+	got := map[string]caddy.Duration{
+		"timeout":                 fn.Timeout,
+		"post_start_timeout":      fn.PostStartTimeout,
+		"cold_start_budget":       fn.ColdStartBudget,
+		"window":                  fn.WebhookDedup.Window,
+		"dial_timeout":            fn.Transport.DialTimeout,
+		"tls_handshake_timeout":   fn.Transport.TLSHandshakeTimeout,
+		"response_header_timeout": fn.Transport.ResponseHeaderTimeout,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected durations:\ngot  %v\nwant %v", got, want)
+	}
+
+	data, err := json.Marshal(fn)
+	if err != nil {
+		t.Fatalf("failed to marshal durations: %v", err)
+	}
+	var roundTripped FunctionConfig
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("failed to unmarshal marshalled durations: %v", err)
+	}
+	if !reflect.DeepEqual(roundTripped, fn) {
+		t.Errorf("durations changed through a round trip:\ngot  %+v\nwant %+v", roundTripped, fn)
+	}
+
+	// time.Duration would only accept nanoseconds, so every duration in the
+	// configuration must be a caddy.Duration
+	var check func(typ reflect.Type, path string)
+	check = func(typ reflect.Type, path string) {
+		for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			if field.Type == reflect.TypeOf(time.Duration(0)) {
+				t.Errorf("%s.%s is a time.Duration; use caddy.Duration", path, field.Name)
+			}
+			check(field.Type, path+"."+field.Name)
+		}
+	}
+	check(reflect.TypeOf(Handler{}), "Handler")
+}
+
 // TestHandler_Cleanup tests the cleanup functionality
 func TestHandler_Cleanup(t *testing.T) {
 	handler := &Handler{}
 	mockCM := NewMockContainerManager()
 	handler.containerManager = mockCM
 
-	// Add some mock containers
-	mockCM.containers["container1"] = &Container{ID: "container1"}
-	mockCM.containers["container2"] = &Container{ID: "container2"}
+	// Start some mock containers
+	for i := 0; i < 2; i++ {
+		if _, err := mockCM.StartContainer(context.Background(), ContainerConfig{Image: "test:latest"}); err != nil {
+			t.Fatalf("failed to start mock container: %v", err)
+		}
+	}
 
 	err := handler.Cleanup()
 	if err != nil {
 		t.Errorf("unexpected error during cleanup: %v", err)
 	}
 
-	if len(mockCM.containers) != 0 {
-		t.Errorf("expected all containers to be cleaned up, but %d remain", len(mockCM.containers))
-	}
+	mockCM.AssertCalled(t, "StartContainer", 2)
+	mockCM.AssertCalled(t, "Cleanup", 1)
+	mockCM.AssertNotCalled(t, "StopContainer")
 }
 
 // TestHandler_MethodCaseInsensitive tests case-insensitive method matching
@@ -713,3 +746,2788 @@ func TestHandler_MethodCaseInsensitive(t *testing.T) {
 		})
 	}
 }
+
+// TestHandler_NoMatchStatus tests the response for a handled method with an unknown path
+func TestHandler_NoMatchStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		noMatchStatus  int
+		noMatchBody    string
+		method         string
+		expectNext     bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "fallthrough by default",
+			method:         "GET",
+			expectNext:     true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "configured status with default body",
+			noMatchStatus:  http.StatusNotFound,
+			method:         "GET",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"no function matches the requested path"}`,
+		},
+		{
+			name:           "configured status with custom body",
+			noMatchStatus:  http.StatusNotFound,
+			noMatchBody:    "nothing here",
+			method:         "GET",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "nothing here",
+		},
+		{
+			name:           "unhandled method still falls through",
+			noMatchStatus:  http.StatusNotFound,
+			method:         "PUT",
+			expectNext:     true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				NoMatchStatus: tt.noMatchStatus,
+				NoMatchBody:   tt.noMatchBody,
+				Functions: []FunctionConfig{
+					{
+						Methods: []string{"GET"},
+						Path:    "^/api/test$",
+						Image:   "test:latest",
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+
+			req := fakeRequest(tt.method, "/api/unknown")
+			w := httptest.NewRecorder()
+
+			nextCalled := false
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) error {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+
+			if err := handler.ServeHTTP(w, req, next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if nextCalled != tt.expectNext {
+				t.Errorf("expected next called to be %v, got %v", tt.expectNext, nextCalled)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body '%s', got '%s'", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_MethodNotAllowed(t *testing.T) {
+	functions := []FunctionConfig{
+		{Methods: []string{"GET", "POST"}, Path: "^/api/items$", Image: "test:latest"},
+		{Methods: []string{"DELETE"}, Path: "^/api/.*", Image: "test:latest"},
+		{Methods: []string{"PUT"}, Path: "^/api/other$", Image: "test:latest"},
+	}
+	tests := []struct {
+		name             string
+		methodNotAllowed bool
+		noMatchStatus    int
+		method           string
+		path             string
+		expectNext       bool
+		expectedStatus   int
+		expectedAllow    string
+	}{
+		{
+			name:           "fallthrough by default",
+			method:         "PATCH",
+			path:           "/api/items",
+			expectNext:     true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "methods of every function matching the path",
+			methodNotAllowed: true,
+			method:           "PATCH",
+			path:             "/api/items",
+			expectedStatus:   http.StatusMethodNotAllowed,
+			expectedAllow:    "DELETE, GET, POST",
+		},
+		{
+			name:             "handled method without a match for the path",
+			methodNotAllowed: true,
+			method:           "GET",
+			path:             "/api/other",
+			expectedStatus:   http.StatusMethodNotAllowed,
+			expectedAllow:    "DELETE, PUT",
+		},
+		{
+			name:             "takes precedence over no_match",
+			methodNotAllowed: true,
+			noMatchStatus:    http.StatusNotFound,
+			method:           "PUT",
+			path:             "/api/items",
+			expectedStatus:   http.StatusMethodNotAllowed,
+			expectedAllow:    "DELETE, GET, POST",
+		},
+		{
+			name:             "unknown path falls through",
+			methodNotAllowed: true,
+			method:           "GET",
+			path:             "/unknown",
+			expectNext:       true,
+			expectedStatus:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, err := newTestHandler(t, functions, nil, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+			handler.MethodNotAllowed = tt.methodNotAllowed
+			handler.NoMatchStatus = tt.noMatchStatus
+
+			w := httptest.NewRecorder()
+			nextCalled := false
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) error {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+			if err := handler.ServeHTTP(w, fakeRequest(tt.method, tt.path), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if nextCalled != tt.expectNext {
+				t.Errorf("expected next called to be %v, got %v", tt.expectNext, nextCalled)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Allow"); got != tt.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", tt.expectedAllow, got)
+			}
+		})
+	}
+}
+
+// TestHandler_MaxHeaderBytes tests that oversized headers are refused before a container is started
+func TestHandler_MaxHeaderBytes(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Token")))
+	}))
+	defer backendServer.Close()
+
+	backendURL := strings.TrimPrefix(backendServer.URL, "http://")
+	host, portStr, _ := strings.Cut(backendURL, ":")
+	port, err := json.Number(portStr).Int64()
+	if err != nil {
+		t.Fatalf("failed to parse backend port: %v", err)
+	}
+
+	mockCM := NewMockContainerManager()
+	startCalled := false
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		startCalled = true
+		return &Container{ID: "header-container", IP: host, Port: int(port)}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:        []string{"GET"},
+			Path:           "/api/headers",
+			Image:          "test:latest",
+			Port:           int(port),
+			MaxHeaderBytes: 64,
+		},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+	tests := []struct {
+		name           string
+		token          string
+		expectStart    bool
+		expectedStatus int
+	}{
+		{"within limit", "abc", true, http.StatusOK},
+		{"over limit", strings.Repeat("x", 100), false, http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startCalled = false
+			req := httptest.NewRequest("GET", "/api/headers", nil)
+			req.Header.Set("X-Token", tt.token)
+			w := httptest.NewRecorder()
+
+			err := handler.ServeHTTP(w, req, next)
+
+			if startCalled != tt.expectStart {
+				t.Errorf("expected StartContainer called to be %v, got %v", tt.expectStart, startCalled)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if w.Body.String() != tt.token {
+					t.Errorf("expected body '%s', got '%s'", tt.token, w.Body.String())
+				}
+				return
+			}
+			herr, ok := err.(caddyhttp.HandlerError)
+			if !ok {
+				t.Fatalf("expected HandlerError, got %T: %v", err, err)
+			}
+			if herr.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, herr.StatusCode)
+			}
+		})
+	}
+}
+
+// scratchMock records whether the request's scratch directory still exists
+// when its container is stopped
+type scratchMock struct {
+	*MockContainerManager
+	scratch       string
+	existedAtStop bool
+}
+
+func (m *scratchMock) StopContainer(ctx context.Context, containerID string) error {
+	_, err := os.Stat(m.scratch)
+	m.existedAtStop = err == nil
+	return m.MockContainerManager.StopContainer(ctx, containerID)
+}
+
+// TestHandler_RequestScratch tests that each request gets its own scratch
+// directory, mounted into the container and removed after it stops
+func TestHandler_RequestScratch(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+	host, portStr, _ := strings.Cut(strings.TrimPrefix(backendServer.URL, "http://"), ":")
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := &scratchMock{MockContainerManager: NewMockContainerManager()}
+	var scratches []string
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		for _, volume := range config.Volumes {
+			if volume.Target != "/scratch" {
+				continue
+			}
+			info, err := os.Stat(volume.Source)
+			if err != nil || !info.IsDir() {
+				t.Errorf("expected scratch directory %s to exist at start: %v", volume.Source, err)
+			}
+			scratches = append(scratches, volume.Source)
+			mockCM.scratch = volume.Source
+		}
+		return &Container{ID: "scratch-container", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:        []string{"GET"},
+			Path:           "/api/scratch",
+			Image:          "test:latest",
+			Port:           port,
+			Volumes:        []VolumeMount{{Source: "/srv/data", Target: "/data"}},
+			RequestScratch: "/scratch",
+		},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for i := 0; i < 2; i++ {
+		if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/scratch"), next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !mockCM.existedAtStop {
+			t.Errorf("expected the scratch directory to exist until the container stopped")
+		}
+		if _, err := os.Stat(mockCM.scratch); !os.IsNotExist(err) {
+			t.Errorf("expected scratch directory %s to be removed, got %v", mockCM.scratch, err)
+		}
+	}
+
+	if len(scratches) != 2 || scratches[0] == scratches[1] {
+		t.Errorf("expected a different scratch directory per request, got %v", scratches)
+	}
+	if len(handler.Functions[0].Volumes) != 1 {
+		t.Errorf("expected the function's volumes to be left unchanged, got %+v", handler.Functions[0].Volumes)
+	}
+
+	invalid := Handler{Functions: []FunctionConfig{{Path: "/x", Image: "x", RequestScratch: "scratch"}}}
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "request_scratch") {
+		t.Errorf("expected a relative scratch path to be rejected, got %v", err)
+	}
+
+	// Swarm tasks may run on a node without the host's scratch directory
+	swarm := Handler{UseSwarm: true, Functions: []FunctionConfig{{Methods: []string{"POST"}, Path: "/x", Image: "x", RequestScratch: "/scratch"}}}
+	if err := swarm.Validate(); err == nil || !strings.Contains(err.Error(), "functions[0].request_scratch: not supported for swarm services") {
+		t.Errorf("expected request_scratch to be rejected with use_swarm, got %v", err)
+	}
+}
+
+// TestHandler_MatchContentType tests that functions on the same path and
+// method are selected by the request's Content-Type
+func TestHandler_MatchContentType(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	var started []string
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		started = append(started, config.Image)
+		return nil, errors.New("not started")
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"POST"}, Path: "/api/submit", Image: "uploads:latest", MatchContentType: []string{"multipart/*"}},
+		{Methods: []string{"POST"}, Path: "/api/submit", Image: "json:latest", MatchContentType: []string{"application/json"}},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	nextCalled := false
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error {
+		nextCalled = true
+		return nil
+	})
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"multipart/form-data; boundary=abc", "uploads:latest"},
+		{"application/json; charset=utf-8", "json:latest"},
+		{"text/plain", ""},
+	}
+	for _, tt := range tests {
+		started, nextCalled = nil, false
+		req := httptest.NewRequest("POST", "/api/submit", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", tt.contentType)
+		_ = handler.ServeHTTP(httptest.NewRecorder(), req, next)
+
+		if tt.expected == "" {
+			if len(started) != 0 || !nextCalled {
+				t.Errorf("%s: expected no function to match, started %v", tt.contentType, started)
+			}
+			continue
+		}
+		if len(started) == 0 || started[0] != tt.expected {
+			t.Errorf("%s: expected %s to be started, got %v", tt.contentType, tt.expected, started)
+		}
+	}
+}
+
+// TestHandler_TimingHeader tests that the time to the container's first
+// response byte is reported in the configured header
+func TestHandler_TimingHeader(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Server-Timing", "app;dur=1")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+
+	backendURL := strings.TrimPrefix(backendServer.URL, "http://")
+	host, portStr, _ := strings.Cut(backendURL, ":")
+	port, err := json.Number(portStr).Int64()
+	if err != nil {
+		t.Fatalf("failed to parse backend port: %v", err)
+	}
+
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "timing-container", IP: host, Port: int(port)}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/server-timing", Image: "test:latest", Port: int(port), TimingHeader: "server-timing"},
+		{Methods: []string{"GET"}, Path: "/api/custom", Image: "test:latest", Port: int(port), TimingHeader: "X-Upstream-Time"},
+		{Methods: []string{"GET"}, Path: "/api/none", Image: "test:latest", Port: int(port)},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	serve := func(path string) http.Header {
+		w := httptest.NewRecorder()
+		if err := handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil), next); err != nil {
+			t.Fatalf("unexpected error for %s: %v", path, err)
+		}
+		return w.Header()
+	}
+	plausible := func(value string) {
+		t.Helper()
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("invalid duration %q: %v", value, err)
+		}
+		if ms < millis(delay) || ms > 5000 {
+			t.Errorf("expected a duration of at least %v, got %vms", delay, ms)
+		}
+	}
+
+	timings := serve("/api/server-timing").Values("Server-Timing")
+	if len(timings) != 2 || timings[0] != "app;dur=1" {
+		t.Fatalf("expected the container's metric followed by upstream, got %q", timings)
+	}
+	duration, ok := strings.CutPrefix(timings[1], "upstream;dur=")
+	if !ok {
+		t.Fatalf("expected an upstream metric, got %q", timings[1])
+	}
+	plausible(duration)
+
+	header := serve("/api/custom")
+	plausible(header.Get("X-Upstream-Time"))
+	if len(header.Values("Server-Timing")) != 1 {
+		t.Errorf("expected Server-Timing to be left to the container, got %q", header.Values("Server-Timing"))
+	}
+
+	header = serve("/api/none")
+	if header.Get("X-Upstream-Time") != "" || len(header.Values("Server-Timing")) != 1 {
+		t.Errorf("expected no timing header without timing_header, got %v", header)
+	}
+
+	invalid := Handler{Functions: []FunctionConfig{{Path: "/x", Image: "x", TimingHeader: "Upstream Time"}}}
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "timing_header") {
+		t.Errorf("expected an invalid header name to be rejected, got %v", err)
+	}
+}
+
+// TestHandler_MaxBodySize tests early and streaming rejection of oversized request bodies
+func TestHandler_MaxBodySize(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backendServer.Close()
+
+	backendURL := strings.TrimPrefix(backendServer.URL, "http://")
+	host, portStr, _ := strings.Cut(backendURL, ":")
+	port, err := json.Number(portStr).Int64()
+	if err != nil {
+		t.Fatalf("failed to parse backend port: %v", err)
+	}
+
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{
+				Methods:     []string{"POST"},
+				Path:        "/api/upload",
+				Image:       "test:latest",
+				Port:        int(port),
+				MaxBodySize: 5,
+			},
+		},
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+
+	mockCM := NewMockContainerManager()
+	startCalled := false
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		startCalled = true
+		return &Container{ID: "body-container", IP: host, Port: int(port)}, nil
+	})
+	handler.containerManager = mockCM
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+	tests := []struct {
+		name           string
+		body           string
+		contentLength  int64
+		expectStart    bool
+		expectedStatus int
+	}{
+		{"declared length within limit", "1234", 4, true, http.StatusOK},
+		{"declared length over limit", "0123456789", 10, false, http.StatusRequestEntityTooLarge},
+		{"undeclared length over limit", "0123456789", -1, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startCalled = false
+			req := httptest.NewRequest("POST", "/api/upload", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+
+			err := handler.ServeHTTP(w, req, next)
+
+			if startCalled != tt.expectStart {
+				t.Errorf("expected StartContainer called to be %v, got %v", tt.expectStart, startCalled)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if w.Body.String() != tt.body {
+					t.Errorf("expected body '%s', got '%s'", tt.body, w.Body.String())
+				}
+				return
+			}
+			herr, ok := err.(caddyhttp.HandlerError)
+			if !ok {
+				t.Fatalf("expected HandlerError, got %T: %v", err, err)
+			}
+			if herr.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, herr.StatusCode)
+			}
+		})
+	}
+}
+
+// TestHandler_UserAgent tests User-Agent rewriting on requests to the container
+func TestHandler_UserAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		userAgent  string
+		clientUA   string
+		expectedUA string
+	}{
+		{"client agent preserved by default", "", "client-agent/1.0", "client-agent/1.0"},
+		{"configured agent overrides client", "function-agent/2.0", "client-agent/1.0", "function-agent/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				Functions: []FunctionConfig{
+					{
+						Methods:   []string{"GET"},
+						Path:      "/api/agent",
+						Image:     "test:latest",
+						UserAgent: tt.userAgent,
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+			handler.containerManager = NewMockContainerManager()
+
+			var receivedUA string
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+				},
+				RequestFunc: func(req *http.Request) {
+					receivedUA = req.Header.Get("User-Agent")
+				},
+			}}
+
+			req := fakeRequest("GET", "/api/agent")
+			req.Header.Set("User-Agent", tt.clientUA)
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+			if err := handler.ServeHTTP(w, req, next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if receivedUA != tt.expectedUA {
+				t.Errorf("expected container to receive User-Agent '%s', got '%s'", tt.expectedUA, receivedUA)
+			}
+		})
+	}
+}
+
+// TestHandler_InheritEnv tests that host environment variables are merged into the container config
+func TestHandler_InheritEnv(t *testing.T) {
+	t.Setenv("SERVERLESS_TEST_INHERITED", "from-host")
+	t.Setenv("SERVERLESS_TEST_OVERRIDDEN", "host-value")
+
+	tests := []struct {
+		name     string
+		global   map[string]string
+		function FunctionConfig
+		expected map[string]string
+		absent   []string
+	}{
+		{
+			name: "selected keys",
+			function: FunctionConfig{
+				Environment: map[string]string{"STATIC": "static", "SERVERLESS_TEST_OVERRIDDEN": "config-value"},
+				InheritEnv:  []string{"SERVERLESS_TEST_INHERITED", "SERVERLESS_TEST_OVERRIDDEN", "SERVERLESS_TEST_UNSET"},
+			},
+			expected: map[string]string{
+				"STATIC":                     "static",
+				"SERVERLESS_TEST_INHERITED":  "from-host",
+				"SERVERLESS_TEST_OVERRIDDEN": "host-value",
+			},
+			absent: []string{"SERVERLESS_TEST_UNSET"},
+		},
+		{
+			name:     "entire environment",
+			function: FunctionConfig{InheritAllEnv: true},
+			expected: map[string]string{
+				"SERVERLESS_TEST_INHERITED":  "from-host",
+				"SERVERLESS_TEST_OVERRIDDEN": "host-value",
+			},
+		},
+		{
+			name:     "nothing inherited by default",
+			function: FunctionConfig{Environment: map[string]string{"STATIC": "static"}},
+			expected: map[string]string{"STATIC": "static"},
+			absent:   []string{"SERVERLESS_TEST_INHERITED"},
+		},
+		{
+			name: "timezone and locale",
+			function: FunctionConfig{
+				Environment: map[string]string{"TZ": "UTC"},
+				Timezone:    "America/New_York",
+				Locale:      "de_DE.UTF-8",
+			},
+			expected: map[string]string{
+				"TZ":     "America/New_York",
+				"LANG":   "de_DE.UTF-8",
+				"LC_ALL": "de_DE.UTF-8",
+			},
+		},
+		{
+			name:     "global environment",
+			global:   map[string]string{"LOG_LEVEL": "info", "STATIC": "global"},
+			function: FunctionConfig{Environment: map[string]string{"STATIC": "static"}},
+			expected: map[string]string{"LOG_LEVEL": "info", "STATIC": "static"},
+		},
+		{
+			name:     "no timezone or locale by default",
+			function: FunctionConfig{},
+			absent:   []string{"TZ", "LANG", "LC_ALL"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := tt.function
+			fn.Methods = []string{"GET"}
+			fn.Path = "/api/env"
+			fn.Image = "test:latest"
+			handler := &Handler{Functions: []FunctionConfig{fn}, GlobalEnvironment: tt.global}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+
+			var started ContainerConfig
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+				started = config
+				return &Container{ID: "env-container", IP: "127.0.0.1", Port: 8080}, nil
+			})
+			handler.containerManager = mockCM
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+				},
+			}}
+
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			if err := handler.ServeHTTP(w, fakeRequest("GET", "/api/env"), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for key, value := range tt.expected {
+				if started.Environment[key] != value {
+					t.Errorf("expected env %s=%s, got '%s'", key, value, started.Environment[key])
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := started.Environment[key]; ok {
+					t.Errorf("expected env %s to be absent", key)
+				}
+			}
+			if tt.global != nil && (len(handler.GlobalEnvironment) != 2 || handler.GlobalEnvironment["STATIC"] != "global") {
+				t.Errorf("expected the global environment to be left unchanged, got %v", handler.GlobalEnvironment)
+			}
+			if handler.Functions[0].Environment["SERVERLESS_TEST_INHERITED"] != "" {
+				t.Error("expected the function's static environment not to be modified")
+			}
+		})
+	}
+}
+
+func TestHandler_FileMounts(t *testing.T) {
+	dir := t.TempDir()
+	hostPath := filepath.Join(dir, "app.conf")
+	content := []byte("listen 8080\n")
+	if err := os.WriteFile(hostPath, content, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	goodHash := hex.EncodeToString(sum[:])
+	badHash := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name         string
+		hash         string
+		expectStart  bool
+		expectStatus int
+	}{
+		{"matching checksum starts container", goodHash, true, http.StatusOK},
+		{"mismatched checksum is rejected", badHash, false, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				Functions: []FunctionConfig{
+					{
+						Methods: []string{"GET"},
+						Path:    "/api/conf",
+						Image:   "test:latest",
+						FileMounts: []FileMount{
+							{HostPath: hostPath, ContainerPath: "/etc/app.conf", SHA256: tt.hash, ReadOnly: true},
+						},
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+			if err := handler.Validate(); err != nil {
+				t.Fatalf("failed to validate handler: %v", err)
+			}
+
+			var started bool
+			var gotVolumes []VolumeMount
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+				started = true
+				gotVolumes = config.Volumes
+				return &Container{ID: "mock-container-id", IP: "127.0.0.1", Port: 8080}, nil
+			})
+			handler.containerManager = mockCM
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+				},
+			}}
+
+			req := fakeRequest("GET", "/api/conf")
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+			err := handler.ServeHTTP(w, req, next)
+			if started != tt.expectStart {
+				t.Fatalf("expected container started=%v, got %v", tt.expectStart, started)
+			}
+			if !tt.expectStart {
+				herr, ok := err.(caddyhttp.HandlerError)
+				if !ok {
+					t.Fatalf("expected HandlerError, got %T: %v", err, err)
+				}
+				if herr.StatusCode != tt.expectStatus {
+					t.Errorf("expected status %d, got %d", tt.expectStatus, herr.StatusCode)
+				}
+				if !strings.Contains(err.Error(), "checksum mismatch") {
+					t.Errorf("expected checksum mismatch error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := VolumeMount{Source: hostPath, Target: "/etc/app.conf", ReadOnly: true}
+			if len(gotVolumes) != 1 || gotVolumes[0] != want {
+				t.Errorf("expected volumes [%+v], got %+v", want, gotVolumes)
+			}
+		})
+	}
+}
+
+func TestHandler_PostStartCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		execErr  error
+	}{
+		{"successful command", 0, nil},
+		{"failing command is not fatal", 3, nil},
+		{"exec error is not fatal", -1, fmt.Errorf("docker unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				Functions: []FunctionConfig{
+					{
+						Methods:          []string{"GET"},
+						Path:             "/api/hook",
+						Image:            "test:latest",
+						PostStartCommand: []string{"/app/init.sh", "--seed"},
+						PostStartTimeout: caddy.Duration(5 * time.Second),
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+
+			core, logs := observer.New(zap.DebugLevel)
+			handler.logger = zap.New(core)
+
+			var calls []string
+			mockCM := NewMockContainerManager()
+			mockCM.SetExecFunc(func(_ context.Context, containerID string, command []string) (string, string, int, error) {
+				// The post-start command runs after the readiness check
+				mockCM.AssertCalled(t, "WaitForReady", 1)
+				calls = append(calls, "exec "+strings.Join(command, " "))
+				return "seeded\n", "warning: slow disk\n", tt.exitCode, tt.execErr
+			})
+			handler.containerManager = mockCM
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+				},
+				RequestFunc: func(_ *http.Request) {
+					calls = append(calls, "proxy")
+				},
+			}}
+
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			if err := handler.ServeHTTP(w, fakeRequest("GET", "/api/hook"), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+
+			expected := []string{"exec /app/init.sh --seed", "proxy"}
+			if strings.Join(calls, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected calls %v, got %v", expected, calls)
+			}
+			execCalls := mockCM.ExecCalls()
+			if len(execCalls) != 1 || execCalls[0].ContainerID != "mock-container-id" {
+				t.Errorf("expected one exec in mock-container-id, got %+v", execCalls)
+			}
+
+			failures := logs.FilterMessage("post-start command failed").All()
+			switch {
+			case tt.execErr != nil:
+				if len(failures) != 1 || failures[0].ContextMap()["error"] != tt.execErr.Error() {
+					t.Errorf("expected the exec error to be logged, got %v", failures)
+				}
+			case tt.exitCode != 0:
+				if len(failures) != 1 {
+					t.Fatalf("expected one failure log, got %v", failures)
+				}
+				fields := failures[0].ContextMap()
+				if fields["exit_code"] != int64(tt.exitCode) || fields["stdout"] != "seeded\n" || fields["stderr"] != "warning: slow disk\n" {
+					t.Errorf("expected exit code and output to be logged, got %v", fields)
+				}
+			default:
+				if len(failures) != 0 {
+					t.Errorf("expected no failure log, got %v", failures)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerManager_ExecInContainer(t *testing.T) {
+	// A fake docker stands in for docker exec
+	dir := t.TempDir()
+	script := `#!/bin/sh
+shift 2
+echo "out: $*"
+echo "err: $*" >&2
+exit $1
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm := NewContainerManager(zap.NewNop())
+	stdout, stderr, exitCode, err := cm.ExecInContainer(context.Background(), "abc", []string{"0", "ok"})
+	if err != nil || exitCode != 0 || stdout != "out: 0 ok\n" || stderr != "err: 0 ok\n" {
+		t.Errorf("unexpected result: stdout %q, stderr %q, exit code %d, err %v", stdout, stderr, exitCode, err)
+	}
+
+	stdout, _, exitCode, err = cm.ExecInContainer(context.Background(), "abc", []string{"7"})
+	if err != nil {
+		t.Fatalf("a non-zero exit should not be an error: %v", err)
+	}
+	if exitCode != 7 || stdout != "out: 7\n" {
+		t.Errorf("expected exit code 7 with output, got %d and %q", exitCode, stdout)
+	}
+
+	sm := &SwarmContainerManager{}
+	if _, _, _, err := sm.ExecInContainer(context.Background(), "svc", []string{"true"}); !errors.Is(err, errExecNotSupported) {
+		t.Errorf("expected errExecNotSupported from swarm, got %v", err)
+	}
+}
+
+// trackingReader records whether the request body was read to the end
+type trackingReader struct {
+	r    io.Reader
+	done bool
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err == io.EOF {
+		t.done = true
+	}
+	return n, err
+}
+
+func TestHandler_PrebufferRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectStart    bool
+		expectedStatus int
+	}{
+		{"body buffered before start and replayed", "hello world", true, http.StatusOK},
+		{"oversized body rejected before start", strings.Repeat("x", 64), false, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				Functions: []FunctionConfig{
+					{
+						Methods:          []string{"POST"},
+						Path:             "/api/prebuffer",
+						Image:            "test:latest",
+						MaxBodySize:      32,
+						PrebufferRequest: true,
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+
+			body := &trackingReader{r: strings.NewReader(tt.body)}
+			mockCM := NewMockContainerManager()
+			startCalled := false
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				startCalled = true
+				if !body.done {
+					t.Error("expected request body to be fully read before StartContainer")
+				}
+				return &Container{ID: "prebuffer-container", IP: "127.0.0.1", Port: 8080}, nil
+			})
+			handler.containerManager = mockCM
+
+			var received string
+			var receivedLength int64
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+				},
+				RequestFunc: func(req *http.Request) {
+					data, _ := io.ReadAll(req.Body)
+					received = string(data)
+					receivedLength = req.ContentLength
+				},
+			}}
+
+			// Unknown length, as with a chunked upload
+			req := httptest.NewRequest("POST", "/api/prebuffer", body)
+			req.ContentLength = -1
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+			err := handler.ServeHTTP(w, req, next)
+			if startCalled != tt.expectStart {
+				t.Fatalf("expected StartContainer called=%v, got %v", tt.expectStart, startCalled)
+			}
+			if !tt.expectStart {
+				herr, ok := err.(caddyhttp.HandlerError)
+				if !ok {
+					t.Fatalf("expected HandlerError, got %T: %v", err, err)
+				}
+				if herr.StatusCode != tt.expectedStatus {
+					t.Errorf("expected status %d, got %d", tt.expectedStatus, herr.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if received != tt.body {
+				t.Errorf("expected container to receive body %q, got %q", tt.body, received)
+			}
+			if receivedLength != int64(len(tt.body)) {
+				t.Errorf("expected Content-Length %d, got %d", len(tt.body), receivedLength)
+			}
+		})
+	}
+}
+
+func TestHandler_StatusMap(t *testing.T) {
+	tests := []struct {
+		name           string
+		upstreamStatus int
+		expectedStatus int
+	}{
+		{"mapped teapot becomes ok", http.StatusTeapot, http.StatusOK},
+		{"mapped server error becomes bad gateway", http.StatusInternalServerError, http.StatusBadGateway},
+		{"unmapped status passes through", http.StatusNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &Handler{
+				Functions: []FunctionConfig{
+					{
+						Methods: []string{"GET"},
+						Path:    "/api/legacy",
+						Image:   "test:latest",
+						StatusMap: map[int]int{
+							http.StatusTeapot:              http.StatusOK,
+							http.StatusInternalServerError: http.StatusBadGateway,
+						},
+					},
+				},
+			}
+
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			if err := handler.Provision(ctx); err != nil {
+				t.Fatalf("failed to provision handler: %v", err)
+			}
+			if err := handler.Validate(); err != nil {
+				t.Fatalf("failed to validate handler: %v", err)
+			}
+			handler.containerManager = NewMockContainerManager()
+			handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+				Response: &http.Response{
+					StatusCode: tt.upstreamStatus,
+					Body:       io.NopCloser(strings.NewReader("legacy")),
+					Header:     make(http.Header),
+				},
+			}}
+
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			if err := handler.ServeHTTP(w, fakeRequest("GET", "/api/legacy"), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Body.String() != "legacy" {
+				t.Errorf("expected body to be passed through, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_WebhookDedup(t *testing.T) {
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{
+				Methods:      []string{"POST"},
+				Path:         "/api/webhook",
+				Image:        "test:latest",
+				WebhookDedup: &DedupConfig{Header: "X-Webhook-ID", Window: caddy.Duration(time.Minute)},
+			},
+		},
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+
+	starts := 0
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		starts++
+		return &Container{ID: "webhook-container", IP: "127.0.0.1", Port: 8080}, nil
+	})
+	handler.containerManager = mockCM
+	handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusAccepted,
+			Body:       io.NopCloser(strings.NewReader("processed")),
+			Header:     make(http.Header),
+		},
+	}}
+
+	deliver := func(id string) (*httptest.ResponseRecorder, error) {
+		req := fakeRequest("POST", "/api/webhook")
+		if id != "" {
+			req.Header.Set("X-Webhook-ID", id)
+		}
+		w := httptest.NewRecorder()
+		next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+		return w, handler.ServeHTTP(w, req, next)
+	}
+
+	// The original delivery is processed normally
+	w, err := deliver("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusAccepted || starts != 1 {
+		t.Fatalf("expected original delivery to be processed, got status %d and %d starts", w.Code, starts)
+	}
+
+	// A retry within the window is acknowledged without starting a container
+	w, err = deliver("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusOK || starts != 1 {
+		t.Errorf("expected duplicate to be acknowledged with 200 and no start, got status %d and %d starts", w.Code, starts)
+	}
+
+	// Other deliveries and requests without an ID are processed
+	if _, err := deliver("delivery-2"); err != nil || starts != 2 {
+		t.Errorf("expected new delivery to be processed, got err %v and %d starts", err, starts)
+	}
+	if _, err := deliver(""); err != nil || starts != 3 {
+		t.Errorf("expected request without ID to be processed, got err %v and %d starts", err, starts)
+	}
+
+	// A failed delivery is forgotten so its retry is processed
+	failAfter := 3
+	mockCM.FailAfterNStarts = &failAfter
+	if _, err := deliver("delivery-3"); err == nil {
+		t.Fatal("expected start failure")
+	}
+	mockCM.FailAfterNStarts = nil
+	mockCM.AssertCalled(t, "StartContainer", 4)
+	if _, err := deliver("delivery-3"); err != nil || starts != 4 {
+		t.Errorf("expected retry of failed delivery to be processed, got err %v and %d starts", err, starts)
+	}
+
+	// So is a delivery the container answered with a server error
+	accepted := handler.HTTPClient
+	handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       io.NopCloser(strings.NewReader("try again")),
+			Header:     make(http.Header),
+		},
+	}}
+	if w, err := deliver("delivery-4"); err != nil || w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the container's 503 to be proxied, got status %d and err %v", w.Code, err)
+	}
+	handler.HTTPClient = accepted
+	if w, err := deliver("delivery-4"); err != nil || w.Code != http.StatusAccepted || starts != 6 {
+		t.Errorf("expected retry after a server error to be processed, got status %d, err %v and %d starts", w.Code, err, starts)
+	}
+}
+
+func TestHandler_WebhookDedupInProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		started <- struct{}{}
+		<-release
+		return &Container{ID: "webhook-container", IP: "127.0.0.1", Port: 8080}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:      []string{"POST"},
+			Path:         "/api/webhook",
+			Image:        "test:latest",
+			WebhookDedup: &DedupConfig{Header: "X-Webhook-ID", Window: caddy.Duration(time.Minute)},
+		},
+	}, mockCM, &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusAccepted,
+			Body:       io.NopCloser(strings.NewReader("processed")),
+			Header:     make(http.Header),
+		},
+	}})
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	deliver := func() (*httptest.ResponseRecorder, error) {
+		req := fakeRequest("POST", "/api/webhook")
+		req.Header.Set("X-Webhook-ID", "delivery-1")
+		w := httptest.NewRecorder()
+		next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+		return w, handler.ServeHTTP(w, req, next)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := deliver()
+		done <- err
+	}()
+	<-started
+
+	// A retry while the first attempt runs is not acknowledged as processed
+	_, err = deliver()
+	herr, ok := err.(caddyhttp.HandlerError)
+	if !ok || herr.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a delivery still in progress, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("first attempt failed: %v", err)
+	}
+	if w, err := deliver(); err != nil || w.Code != http.StatusOK {
+		t.Errorf("expected a retry after the first attempt to be acknowledged, got status %d and err %v", w.Code, err)
+	}
+	mockCM.AssertCalled(t, "StartContainer", 1)
+}
+
+func TestHandler_ValidateMemoryLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		function    FunctionConfig
+		expectError bool
+	}{
+		{"memory with swap and oom kill disabled", FunctionConfig{Memory: "256m", MemorySwap: "512m", OOMKillDisable: true}, false},
+		{"unlimited swap", FunctionConfig{Memory: "1g", MemorySwap: "-1"}, false},
+		{"oom kill disable without memory", FunctionConfig{OOMKillDisable: true}, true},
+		{"swap without memory", FunctionConfig{MemorySwap: "512m"}, true},
+		{"invalid memory size", FunctionConfig{Memory: "lots"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := tt.function
+			fn.Methods = []string{"GET"}
+			fn.Path = "/api/memory"
+			fn.Image = "test:latest"
+			handler := Handler{Functions: []FunctionConfig{fn}}
+
+			err := handler.Validate()
+			if tt.expectError && err == nil {
+				t.Error("expected validation error")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandler_PerRequestIsolation(t *testing.T) {
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:   []string{"POST"},
+			Path:      "/api/untrusted",
+			Image:     "sandbox:latest",
+			Isolation: IsolationPerRequest,
+		},
+	}, nil, &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       http.NoBody,
+			Header:     make(http.Header),
+		},
+	}})
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatalf("failed to validate handler: %v", err)
+	}
+
+	var started, stopped []string
+	mockCM := &isolationMock{MockContainerManager: NewMockContainerManager(), stopped: &stopped}
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		id := fmt.Sprintf("sandbox-%d", len(started)+1)
+		started = append(started, id)
+		return &Container{ID: id, IP: "127.0.0.1", Port: 8080}, nil
+	})
+	handler.containerManager = mockCM
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for i := 0; i < 2; i++ {
+		if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("POST", "/api/untrusted"), next); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if len(started) != 2 || started[0] == started[1] {
+		t.Errorf("expected two distinct containers, got %v", started)
+	}
+	if strings.Join(stopped, ",") != strings.Join(started, ",") {
+		t.Errorf("expected each container to be stopped after its request, started %v, stopped %v", started, stopped)
+	}
+
+	handler.Functions[0].Isolation = "pooled"
+	if err := handler.Validate(); err == nil {
+		t.Error("expected validation error for unknown isolation mode")
+	}
+}
+
+// isolationMock records the containers stopped by the handler
+type isolationMock struct {
+	*MockContainerManager
+	stopped *[]string
+}
+
+func (m *isolationMock) StopContainer(ctx context.Context, containerID string) error {
+	*m.stopped = append(*m.stopped, containerID)
+	return m.MockContainerManager.StopContainer(ctx, containerID)
+}
+
+func TestHandler_FallbackResponse(t *testing.T) {
+	tests := []struct {
+		name           string
+		fallback       *FallbackResponse
+		expectedStatus int
+		expectedBody   string
+		expectError    bool
+	}{
+		{
+			name: "fallback served when start fails",
+			fallback: &FallbackResponse{
+				StatusCode: http.StatusServiceUnavailable,
+				Headers:    http.Header{"Retry-After": []string{"30"}},
+				Body:       "temporarily unavailable",
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "temporarily unavailable",
+		},
+		{
+			name:           "fallback status defaults to 503",
+			fallback:       &FallbackResponse{Body: "degraded"},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "degraded",
+		},
+		{
+			name:           "no fallback yields the normal error",
+			expectedStatus: http.StatusInternalServerError,
+			expectError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCM := NewMockContainerManager()
+			failAll := 0
+			mockCM.FailAfterNStarts = &failAll
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{
+					Methods:          []string{"GET"},
+					Path:             "/api/fallback",
+					Image:            "test:latest",
+					FallbackResponse: tt.fallback,
+				},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			err = handler.ServeHTTP(w, fakeRequest("GET", "/api/fallback"), next)
+
+			if tt.expectError {
+				herr, ok := err.(caddyhttp.HandlerError)
+				if !ok {
+					t.Fatalf("expected HandlerError, got %T: %v", err, err)
+				}
+				if herr.StatusCode != tt.expectedStatus {
+					t.Errorf("expected status %d, got %d", tt.expectedStatus, herr.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			for name, values := range tt.fallback.Headers {
+				if got := w.Header().Get(name); got != values[0] {
+					t.Errorf("expected header %s: %s, got %q", name, values[0], got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_ReadyPort(t *testing.T) {
+	// The backend serves the function; a separate listener stands in for its health port
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "proxied")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	health, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on health port: %v", err)
+	}
+	defer health.Close()
+	readyPort := health.Addr().(*net.TCPAddr).Port
+
+	mockCM := &readyPortMock{
+		MockContainerManager: NewMockContainerManager(),
+		checker:              &ContainerManager{logger: zap.NewNop()},
+	}
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "ready-port", IP: host, Port: port}, nil
+	})
+
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/ready", Image: "test:latest", Port: port, ReadyPort: readyPort, Timeout: caddy.Duration(time.Second)},
+		{Methods: []string{"GET"}, Path: "/api/default", Image: "test:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	if handler.Functions[1].ReadyPort != port {
+		t.Errorf("expected ready_port to default to port %d, got %d", port, handler.Functions[1].ReadyPort)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	w := httptest.NewRecorder()
+	if err := handler.ServeHTTP(w, fakeRequest("GET", "/api/ready"), next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Body.String() != "proxied" {
+		t.Errorf("expected response from the proxy port, got %q", w.Body.String())
+	}
+	if len(mockCM.ports) != 1 || mockCM.ports[0] != readyPort {
+		t.Errorf("expected readiness check on port %d, got %v", readyPort, mockCM.ports)
+	}
+
+	// Readiness is gated on the health port even though the proxy port is up
+	_ = health.Close()
+	err = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/ready"), next)
+	herr, ok := err.(caddyhttp.HandlerError)
+	if !ok || herr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500 when the ready port is closed, got %v", err)
+	}
+
+	handler.Functions[0].ReadyPort = 70000
+	if err := handler.Validate(); err == nil {
+		t.Error("expected validation error for out of range ready_port")
+	}
+}
+
+func TestHandler_ReadinessUsesContainerPort(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "proxied")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	hostPort, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name          string
+		internalPort  int
+		containerPort int
+	}{
+		// With host networking the container serves on its internal port
+		{name: "host", internalPort: hostPort, containerPort: hostPort},
+		// In bridge mode the internal port is published on another host port
+		{name: "bridge", internalPort: 8080, containerPort: hostPort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCM := &readyPortMock{
+				MockContainerManager: NewMockContainerManager(),
+				checker:              &ContainerManager{logger: zap.NewNop()},
+			}
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: tt.name, IP: host, Port: tt.containerPort}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"GET"}, Path: "/api/ready", Image: "test:latest", Port: tt.internalPort, Timeout: caddy.Duration(time.Second)},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			w := httptest.NewRecorder()
+			if err := handler.ServeHTTP(w, fakeRequest("GET", "/api/ready"), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Body.String() != "proxied" {
+				t.Errorf("expected proxied response, got %q", w.Body.String())
+			}
+			if len(mockCM.ports) != 1 || mockCM.ports[0] != tt.containerPort {
+				t.Errorf("expected readiness check on port %d, got %v", tt.containerPort, mockCM.ports)
+			}
+		})
+	}
+}
+
+// wedgedMock starts containers c1, c2, ... of which the first wedged never
+// become ready
+type wedgedMock struct {
+	*reloadMock
+	wedged  int
+	started []string
+}
+
+func (m *wedgedMock) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error {
+	if len(m.started) <= m.wedged {
+		return fmt.Errorf("container %s did not become ready", container.ID)
+	}
+	return m.reloadMock.WaitForReady(ctx, container, timeout, port, maxAttempts)
+}
+
+func TestHandler_ReadyFailureRetries(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "proxied")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name            string
+		retries         int
+		wedged          int
+		expectedStatus  int
+		expectedStopped []string
+	}{
+		{"fresh container after a wedged one", 1, 1, http.StatusOK, []string{"c1", "c2"}},
+		{"several wedged containers", 3, 2, http.StatusOK, []string{"c1", "c2", "c3"}},
+		{"retries exhausted", 1, 2, http.StatusInternalServerError, []string{"c1", "c2"}},
+		{"no retries by default", 0, 1, http.StatusInternalServerError, []string{"c1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCM := &wedgedMock{reloadMock: &reloadMock{MockContainerManager: NewMockContainerManager()}, wedged: tt.wedged}
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				// Each container is stopped before the next one starts
+				if len(mockCM.stopped) != len(mockCM.started) {
+					t.Errorf("started a container while %v was still running", mockCM.started)
+				}
+				id := fmt.Sprintf("c%d", len(mockCM.started)+1)
+				mockCM.started = append(mockCM.started, id)
+				return &Container{ID: id, IP: host, Port: port}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"GET"}, Path: "/api/wedge", Image: "test:latest", Port: port, ReadyFailureRetries: tt.retries},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			w := httptest.NewRecorder()
+			err = handler.ServeHTTP(w, fakeRequest("GET", "/api/wedge"), next)
+			if tt.expectedStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if w.Body.String() != "proxied" {
+					t.Errorf("expected proxied response, got %q", w.Body.String())
+				}
+			} else if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %v", tt.expectedStatus, err)
+			}
+			if strings.Join(mockCM.stopped, ",") != strings.Join(tt.expectedStopped, ",") {
+				t.Errorf("expected stopped containers %v, got %v", tt.expectedStopped, mockCM.stopped)
+			}
+		})
+	}
+
+	handler := Handler{Functions: []FunctionConfig{{Path: "/x", Image: "x", ReadyFailureRetries: -1}}}
+	if err := handler.Validate(); err == nil {
+		t.Error("expected validation error for negative ready_failure_retries")
+	}
+}
+
+// readyPortMock checks readiness by dialing the requested port
+type readyPortMock struct {
+	*MockContainerManager
+	checker *ContainerManager
+	ports   []int
+}
+
+func (m *readyPortMock) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error {
+	m.ports = append(m.ports, port)
+	return m.checker.WaitForReady(ctx, container, timeout, port, maxAttempts)
+}
+
+func TestHandler_AutoOptions(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name            string
+		methods         []string
+		autoOptions     *bool
+		expectedStarts  int
+		expectedStatus  int
+		expectedAllow   string
+		expectNextCalls bool
+	}{
+		{
+			name:           "listed OPTIONS is proxied by default",
+			methods:        []string{"GET", "OPTIONS"},
+			expectedStarts: 1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "unlisted OPTIONS passes to next handler",
+			methods:         []string{"GET"},
+			expectNextCalls: true,
+		},
+		{
+			name:           "auto_options off answers without a container",
+			methods:        []string{"get", "POST"},
+			autoOptions:    &disabled,
+			expectedStatus: http.StatusNoContent,
+			expectedAllow:  "GET, POST, OPTIONS",
+		},
+		{
+			name:           "auto_options off overrides listed OPTIONS",
+			methods:        []string{"GET", "OPTIONS"},
+			autoOptions:    &disabled,
+			expectedStatus: http.StatusNoContent,
+			expectedAllow:  "GET, OPTIONS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodOptions {
+					t.Errorf("expected OPTIONS request at the container, got %s", r.Method)
+				}
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}))
+			defer backend.Close()
+			host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to parse backend address: %v", err)
+			}
+			port, _ := strconv.Atoi(portStr)
+
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: "options", IP: host, Port: port}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: tt.methods, Path: "/api/cors", Image: "test:latest", AutoOptions: tt.autoOptions},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			nextCalled := false
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error {
+				nextCalled = true
+				return nil
+			})
+			w := httptest.NewRecorder()
+			if err := handler.ServeHTTP(w, fakeRequest("OPTIONS", "/api/cors"), next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if nextCalled != tt.expectNextCalls {
+				t.Errorf("expected next handler called: %v, got %v", tt.expectNextCalls, nextCalled)
+			}
+			mockCM.AssertCalled(t, "StartContainer", tt.expectedStarts)
+			if tt.expectNextCalls {
+				return
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Allow"); got != tt.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", tt.expectedAllow, got)
+			}
+		})
+	}
+}
+
+func TestHandler_GRPCTrailers(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend http.HandlerFunc
+	}{
+		{
+			name: "announced trailers",
+			backend: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set("Grpc-Status", "5")
+				w.Header().Set("Grpc-Message", "user not found")
+			},
+		},
+		{
+			name: "unannounced trailers",
+			backend: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc")
+				w.WriteHeader(http.StatusOK)
+				// Force chunking, as an HTTP/1.1 response without a body
+				// would otherwise end before the trailers
+				w.(http.Flusher).Flush()
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+				w.Header().Set(http.TrailerPrefix+"Grpc-Message", "user not found")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(tt.backend)
+			defer backend.Close()
+			host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to parse backend address: %v", err)
+			}
+			port, _ := strconv.Atoi(portStr)
+
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: "grpc", IP: host, Port: port}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"POST"}, Path: "^/users.UserService/", Image: "grpc:latest"},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			// Serve the handler over HTTP/2, as gRPC clients require
+			front := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+				if err := handler.ServeHTTP(w, r, next); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}))
+			front.EnableHTTP2 = true
+			front.StartTLS()
+			defer front.Close()
+
+			req, _ := http.NewRequest("POST", front.URL+"/users.UserService/GetUser", strings.NewReader(""))
+			req.Header.Set("Content-Type", "application/grpc")
+			resp, err := front.Client().Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.ProtoMajor != 2 {
+				t.Errorf("expected HTTP/2, got %s", resp.Proto)
+			}
+			if resp.StatusCode != http.StatusOK || len(body) != 0 {
+				t.Errorf("expected empty 200 response, got %d %q", resp.StatusCode, body)
+			}
+			if got := resp.Trailer.Get("Grpc-Status"); got != "5" {
+				t.Errorf("expected grpc-status trailer 5, got %q", got)
+			}
+			if got := resp.Trailer.Get("Grpc-Message"); got != "user not found" {
+				t.Errorf("expected grpc-message trailer, got %q", got)
+			}
+		})
+	}
+}
+
+func TestHandler_FirstRequestRetry(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "ok "+string(body))
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name           string
+		refusals       int
+		resets         int
+		method         string
+		body           string
+		prebuffer      bool
+		expectedDials  int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "first dial refused, retry succeeds",
+			refusals:       1,
+			method:         "GET",
+			expectedDials:  2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok ",
+		},
+		{
+			name:           "only one retry",
+			refusals:       2,
+			method:         "GET",
+			expectedDials:  2,
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "prebuffered body is resent",
+			refusals:       1,
+			method:         "POST",
+			body:           "payload",
+			prebuffer:      true,
+			expectedDials:  2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok payload",
+		},
+		{
+			name:           "streamed body is not resent",
+			refusals:       1,
+			method:         "POST",
+			body:           "payload",
+			expectedDials:  1,
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "reset idempotent request is resent",
+			resets:         1,
+			method:         "PUT",
+			body:           "payload",
+			prebuffer:      true,
+			expectedDials:  2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok payload",
+		},
+		{
+			name:           "reset non-idempotent request is not resent",
+			resets:         1,
+			method:         "POST",
+			body:           "payload",
+			prebuffer:      true,
+			expectedDials:  1,
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Refuse the first dials as an app still opening its listener would
+			dials := 0
+			dialer := &net.Dialer{}
+			client := &http.Client{Transport: &http.Transport{
+				DisableKeepAlives: true,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					dials++
+					if dials <= tt.refusals {
+						return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+					}
+					conn, err := dialer.DialContext(ctx, network, addr)
+					if err == nil && dials <= tt.refusals+tt.resets {
+						// The request is written, then the connection is reset
+						conn = resetConn{conn}
+					}
+					return conn, err
+				},
+			}}
+
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: "retry", IP: host, Port: port}, nil
+			})
+			fn := FunctionConfig{Methods: []string{tt.method}, Path: "/api/retry", Image: "test:latest"}
+			if tt.prebuffer {
+				fn.PrebufferRequest = true
+				fn.MaxBodySize = 1024
+			}
+			handler, err := newTestHandler(t, []FunctionConfig{fn}, mockCM, client)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, "/api/retry", body)
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			err = handler.ServeHTTP(w, req, next)
+
+			if dials != tt.expectedDials {
+				t.Errorf("expected %d dials, got %d", tt.expectedDials, dials)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				herr, ok := err.(caddyhttp.HandlerError)
+				if !ok || herr.StatusCode != tt.expectedStatus {
+					t.Errorf("expected status %d, got %v", tt.expectedStatus, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+// resetConn is a connection reset by the peer before it sends a response
+type resetConn struct {
+	net.Conn
+}
+
+func (c resetConn) Read([]byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+}
+
+func TestHandler_DebugBodies(t *testing.T) {
+	requestBody := `{"user":"bob","password":"hunter2","padding":"` + strings.Repeat("x", 100) + `"}`
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		_, _ = io.WriteString(w, `{"token":"abc","result":"`+strings.Repeat("y", 100)+`"}`)
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "debug", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:        []string{"POST"},
+			Path:           "/api/debug",
+			Image:          "test:latest",
+			DebugBodies:    true,
+			DebugBodyLimit: 40,
+			DebugRedact:    []string{"password", "token", "X-Api-Key"},
+		},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	core, logs := observer.New(zap.DebugLevel)
+	handler.logger = zap.New(core)
+
+	req := httptest.NewRequest("POST", "/api/debug", strings.NewReader(requestBody))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	w := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	if err := handler.ServeHTTP(w, req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Capturing must not alter what is proxied
+	if received != requestBody {
+		t.Errorf("backend received %d bytes, expected the full %d byte body", len(received), len(requestBody))
+	}
+	if len(w.Body.String()) <= 40 {
+		t.Errorf("client received a truncated response: %q", w.Body.String())
+	}
+
+	expected := map[string]string{
+		// The first 40 bytes, redacted after truncation
+		"proxied request":  `{"user":"bob","password":"[REDACTED]","padd`,
+		"proxied response": `{"token":"[REDACTED]","result":"yyyyyyyyyyyyyyy`,
+	}
+	for message, body := range expected {
+		entries := logs.FilterMessage(message).All()
+		if len(entries) != 1 {
+			t.Fatalf("expected one %q log entry, got %d", message, len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["body"] != body {
+			t.Errorf("%s: expected body %q, got %q", message, body, fields["body"])
+		}
+		if fields["body_truncated"] != true {
+			t.Errorf("%s: expected body to be marked truncated", message)
+		}
+		if strings.Contains(fmt.Sprint(fields["headers"]), "secret") {
+			t.Errorf("%s: sensitive header logged: %v", message, fields["headers"])
+		}
+	}
+	if size := logs.FilterMessage("proxied request").All()[0].ContextMap()["body_size"]; size != int64(len(requestBody)) {
+		t.Errorf("expected request body_size %d, got %v", len(requestBody), size)
+	}
+}
+
+func TestHandler_LazyPathRegex(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return nil, fmt.Errorf("container start failed")
+	})
+	functions := []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/api/literal$", Image: "test:latest"},
+		{Methods: []string{"GET"}, Path: "^/api/broken/(", Image: "test:latest"},
+		{Methods: []string{"GET"}, Path: "^/api/items/[0-9]+$", Image: "test:latest"},
+	}
+
+	// A path that does not parse is rejected by validation rather than at request time
+	handler, err := newTestHandler(t, functions, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	err = handler.Validate()
+	if err == nil || !strings.Contains(err.Error(), "functions[1].path") || !strings.Contains(err.Error(), "invalid path regex") {
+		t.Fatalf("expected functions[1].path to be reported as an invalid regex, got %v", err)
+	}
+	if handler.Functions[1].pathRegex != nil || handler.Functions[1].compileErr != nil {
+		t.Error("expected validation not to compile the path regex")
+	}
+
+	handler, err = newTestHandler(t, []FunctionConfig{functions[0], functions[2]}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	if err := handler.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	for i := range handler.Functions {
+		if handler.Functions[i].pathRegex != nil {
+			t.Errorf("expected %q not to be compiled during provisioning", handler.Functions[i].Path)
+		}
+	}
+	if fn := handler.findMatchingFunction(fakeRequest("GET", "/api/literal")); fn != &handler.Functions[0] {
+		t.Fatalf("expected the literal function to match, got %v", fn)
+	}
+	if handler.Functions[0].pathRegex != nil {
+		t.Error("expected a literal path never to be compiled")
+	}
+
+	// Compilation happens once, even under concurrent lookups
+	handler, err = newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/api/items/[0-9]+$", Image: "test:latest"},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if fn := handler.findMatchingFunction(fakeRequest("GET", "/api/items/42")); fn != &handler.Functions[0] {
+				t.Errorf("expected the items function to match, got %v", fn)
+			}
+		}()
+	}
+	wg.Wait()
+	if handler.Functions[0].pathRegex == nil {
+		t.Error("expected the path regex to be compiled on first use")
+	}
+}
+
+func TestHandler_LogConfigDefaults(t *testing.T) {
+	var started ContainerConfig
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		started = config
+		return nil, fmt.Errorf("container start failed")
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/logs", Image: "test:latest", LogConfig: &ContainerLogConfig{Compress: true}},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	_ = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/logs"), next)
+	expected := ContainerLogConfig{MaxSize: "10m", MaxFile: 3, Compress: true}
+	if started.LogConfig == nil || *started.LogConfig != expected {
+		t.Errorf("expected log config %+v, got %+v", expected, started.LogConfig)
+	}
+
+	handler.Functions[0].LogConfig.MaxSize = "lots"
+	if err := handler.Validate(); err == nil {
+		t.Error("expected validation error for an invalid max_size")
+	}
+
+	// Rotation only applies to drivers writing local log files
+	handler.Functions[0].LogConfig.MaxSize = "10m"
+	handler.Functions[0].LogDriver = "journald"
+	if err := handler.Validate(); err == nil || !strings.Contains(err.Error(), "functions[0].log_config") {
+		t.Errorf("expected log_config to be rejected with the journald driver, got %v", err)
+	}
+	handler.Functions[0].LogDriver = "local"
+	if err := handler.Validate(); err != nil {
+		t.Errorf("unexpected error with the local driver: %v", err)
+	}
+}
+
+func TestHandler_SeccompProfile(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(profile, []byte(`{"defaultAction": "SCMP_ACT_ALLOW"}`), 0o644); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		wantErr bool
+	}{
+		{"profile file", profile, false},
+		{"unconfined", "unconfined", false},
+		{"missing file", filepath.Join(t.TempDir(), "missing.json"), true},
+		{"directory", t.TempDir(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var started ContainerConfig
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+				started = config
+				return nil, fmt.Errorf("container start failed")
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"GET"}, Path: "/api/seccomp", Image: "test:latest", SeccompProfile: tt.profile},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+			if err := handler.Validate(); tt.wantErr {
+				if err == nil {
+					t.Fatal("expected validation to fail")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			_ = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/seccomp"), next)
+			if started.SeccompProfile != tt.profile {
+				t.Errorf("expected seccomp profile %q, got %q", tt.profile, started.SeccompProfile)
+			}
+		})
+	}
+}
+
+func TestHandler_DebugInfo(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name     string
+		debug    bool
+		header   string
+		expected bool
+	}{
+		{name: "toggle and header", debug: true, header: "1", expected: true},
+		{name: "toggle only", debug: true, expected: false},
+		{name: "header only", debug: false, header: "1", expected: false},
+		{name: "other header value", debug: true, header: "true", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: "debug-container", IP: host, Port: port}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"GET"}, Path: "/api/debug", Image: "debug:latest", Port: port},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+			handler.Debug = tt.debug
+
+			req := fakeRequest("GET", "/api/debug")
+			req.Header.Set("X-Request-ID", "req-1")
+			if tt.header != "" {
+				req.Header.Set("X-Serverless-Debug", tt.header)
+			}
+			w := httptest.NewRecorder()
+			next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+			if err := handler.ServeHTTP(w, req, next); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			value := w.Header().Get("X-Serverless-Debug-Info")
+			if !tt.expected {
+				if value != "" {
+					t.Errorf("expected no debug info, got %s", value)
+				}
+				return
+			}
+			var info debugInfo
+			if err := json.Unmarshal([]byte(value), &info); err != nil {
+				t.Fatalf("failed to parse debug info %q: %v", value, err)
+			}
+			if info.Function != "/api/debug" || info.Image != "debug:latest" || info.ContainerID != "debug-container" ||
+				info.RequestID != "req-1" || info.Start != "cold" {
+				t.Errorf("unexpected debug info: %+v", info)
+			}
+			if info.StartMillis < 0 || info.ReadyMillis < 0 || info.ElapsedMillis < info.StartMillis+info.ReadyMillis {
+				t.Errorf("inconsistent timings: %+v", info)
+			}
+			if w.Body.String() != "ok" {
+				t.Errorf("expected the response body to be unchanged, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Namespaces(t *testing.T) {
+	var started []ContainerConfig
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		started = append(started, config)
+		return nil, fmt.Errorf("container start failed")
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/prod", Image: "app:latest", Namespace: "production"},
+		{Methods: []string{"GET"}, Path: "/api/default", Image: "app:latest"},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	handler.DefaultNamespace = "staging"
+	if err := handler.provision(); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for _, path := range []string{"/api/prod", "/api/default"} {
+		_ = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", path), next)
+	}
+	if len(started) != 2 || started[0].Namespace != "production" || started[1].Namespace != "staging" {
+		t.Fatalf("expected namespaces production and staging, got %+v", started)
+	}
+	if started[0].FunctionPath != "/api/prod" {
+		t.Errorf("expected the function path to be passed, got %q", started[0].FunctionPath)
+	}
+
+	// Both namespaces run the same image; the admin API keeps them apart
+	handler.inflight.add(&Container{ID: "prod-1"}, &handler.Functions[0], mockCM)
+	handler.inflight.add(&Container{ID: "staging-1"}, &handler.Functions[1], mockCM)
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "?namespace=production", expected: []string{"prod-1"}},
+		{query: "?namespace=staging", expected: []string{"staging-1"}},
+		{query: "?namespace=other", expected: nil},
+		{query: "", expected: []string{"prod-1", "staging-1"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := new(adminAPI).handleContainers(w, httptest.NewRequest("GET", "/serverless/containers"+tt.query, nil)); err != nil {
+			t.Fatalf("unexpected admin error: %v", err)
+		}
+		var containers []ContainerInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &containers); err != nil {
+			t.Fatalf("failed to parse containers JSON: %v", err)
+		}
+		var ids []string
+		for _, c := range containers {
+			if c.Path == "/api/prod" || c.Path == "/api/default" {
+				ids = append(ids, c.ID)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%s: expected containers %v, got %v", tt.query, tt.expected, ids)
+		}
+	}
+
+	handler.Functions[0].Namespace = "bad namespace"
+	if err := handler.Validate(); err == nil {
+		t.Error("expected validation error for an invalid namespace")
+	}
+}
+
+func TestAdmin_PauseResume(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/pausable", Image: "app:latest"},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	handler.inflight.add(&Container{ID: "pause-1"}, &handler.Functions[0], mockCM)
+
+	steps := []struct {
+		action         string
+		expectedStatus int
+		expectedState  string
+	}{
+		{"resume", http.StatusConflict, ContainerStatusRunning},
+		{"pause", http.StatusOK, ContainerStatusPaused},
+		{"pause", http.StatusConflict, ContainerStatusPaused},
+		{"resume", http.StatusOK, ContainerStatusRunning},
+	}
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		err := new(adminAPI).handleContainer(w, httptest.NewRequest("POST", "/serverless/containers/pause-1/"+step.action, nil))
+		if step.expectedStatus == http.StatusOK {
+			if err != nil {
+				t.Fatalf("%s: unexpected admin error: %v", step.action, err)
+			}
+			var info ContainerInfo
+			if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+				t.Fatalf("%s: failed to parse container JSON: %v", step.action, err)
+			}
+			if info.ID != "pause-1" || info.Status != step.expectedState {
+				t.Errorf("%s: expected pause-1 to be %s, got %+v", step.action, step.expectedState, info)
+			}
+		} else if apiErr, ok := err.(caddy.APIError); !ok || apiErr.HTTPStatus != step.expectedStatus {
+			t.Errorf("%s: expected status %d, got %v", step.action, step.expectedStatus, err)
+		}
+		if status := handler.inflight.snapshot()["pause-1"].status; status != step.expectedState {
+			t.Errorf("%s: expected status %s, got %s", step.action, step.expectedState, status)
+		}
+	}
+
+	if calls := mockCM.PauseCalls(); len(calls) != 1 || calls[0] != "pause-1" {
+		t.Errorf("expected one pause of pause-1, got %v", calls)
+	}
+	if calls := mockCM.ResumeCalls(); len(calls) != 1 || calls[0] != "pause-1" {
+		t.Errorf("expected one resume of pause-1, got %v", calls)
+	}
+
+	err = new(adminAPI).handleContainer(httptest.NewRecorder(), httptest.NewRequest("GET", "/serverless/containers/pause-1/pause", nil))
+	if apiErr, ok := err.(caddy.APIError); !ok || apiErr.HTTPStatus != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected with 405, got %v", err)
+	}
+
+	// Swarm services cannot be paused
+	handler.inflight.add(&Container{ID: "service-1"}, &handler.Functions[0], &SwarmContainerManager{})
+	err = new(adminAPI).handleContainer(httptest.NewRecorder(), httptest.NewRequest("POST", "/serverless/containers/service-1/pause", nil))
+	if apiErr, ok := err.(caddy.APIError); !ok || apiErr.HTTPStatus != http.StatusNotImplemented {
+		t.Errorf("expected 501 for a swarm service, got %v", err)
+	}
+}
+
+func TestHandler_ClientDisconnect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "too late")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockCM := &reloadMock{MockContainerManager: NewMockContainerManager()}
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		// The client goes away while the container starts
+		cancel()
+		return &Container{ID: "disconnect", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/slow", Image: "test:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	core, logs := observer.New(zap.DebugLevel)
+	handler.logger = zap.New(core)
+
+	req := fakeRequest("GET", "/api/slow").WithContext(ctx)
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	err = handler.ServeHTTP(httptest.NewRecorder(), req, next)
+
+	if !errors.Is(err, errClientDisconnected) {
+		t.Fatalf("expected errClientDisconnected, got %v", err)
+	}
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != statusClientClosedRequest {
+		t.Errorf("expected status %d, got %v", statusClientClosedRequest, err)
+	}
+	entries := logs.FilterMessage("client disconnected").All()
+	if len(entries) != 1 || entries[0].ContextMap()["event"] != "client_disconnected" {
+		t.Errorf("expected one client_disconnected log entry, got %v", entries)
+	}
+	if n := logs.FilterMessage("failed to proxy request to container").Len(); n != 0 {
+		t.Errorf("expected no generic proxy error log, got %d", n)
+	}
+	mockCM.AssertCalled(t, "StopContainer", 1)
+	for _, call := range mockCM.CallLog() {
+		if call.Method == "StopContainer" && call.Args != "disconnect" {
+			t.Errorf("expected the disconnected request's container to be stopped, got %v", call.Args)
+		}
+	}
+}
+
+func TestHandler_ContainerExited(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			_, _ = io.WriteString(w, "too late")
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := &reloadMock{MockContainerManager: NewMockContainerManager()}
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "crashing", IP: host, Port: port}, nil
+	})
+	// The container crashes while the backend is still handling the request
+	mockCM.SetRunningFunc(func(_ context.Context, containerID string) (bool, error) {
+		if containerID != "crashing" {
+			t.Errorf("unexpected container checked: %s", containerID)
+		}
+		return false, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/crash", Image: "test:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	core, logs := observer.New(zap.DebugLevel)
+	handler.logger = zap.New(core)
+
+	w := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	start := time.Now()
+	err = handler.ServeHTTP(w, fakeRequest("GET", "/api/crash"), next)
+
+	if !errors.Is(err, errContainerExited) {
+		t.Fatalf("expected errContainerExited, got %v", err)
+	}
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %v", http.StatusBadGateway, err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the request to fail before the backend responded, took %v", elapsed)
+	}
+	if got := w.Header().Get(serverlessErrorHeader); got != "container-exited-unexpectedly" {
+		t.Errorf("expected %s: container-exited-unexpectedly, got %q", serverlessErrorHeader, got)
+	}
+	entries := logs.FilterMessage("container exited before responding").All()
+	if len(entries) != 1 || entries[0].ContextMap()["event"] != "container_exited" {
+		t.Errorf("expected one container_exited log entry, got %v", entries)
+	}
+	if n := logs.FilterMessage("failed to proxy request to container").Len(); n != 0 {
+		t.Errorf("expected no generic proxy error log, got %d", n)
+	}
+}
+
+func TestHandler_ErrorLogger(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	failAll := 0
+	mockCM.FailAfterNStarts = &failAll
+	core, logs := observer.New(zap.DebugLevel)
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{Methods: []string{"GET"}, Path: "/api/payments", Image: "test:latest", ErrorLogger: "payments"},
+			{Methods: []string{"GET"}, Path: "/api/other", Image: "test:latest"},
+		},
+		containerManager: mockCM,
+		logger:           zap.New(core).Named("serverless"),
+	}
+	if err := handler.provision(); err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+	t.Cleanup(func() { _ = handler.Cleanup() })
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for _, path := range []string{"/api/payments", "/api/other"} {
+		if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", path), next); err == nil {
+			t.Fatalf("%s: expected the container start to fail", path)
+		}
+	}
+
+	byLogger := make(map[string]int)
+	for _, entry := range logs.FilterMessage("failed to start container").All() {
+		byLogger[entry.LoggerName]++
+	}
+	expected := map[string]int{"serverless.payments": 1, "serverless": 1}
+	if !reflect.DeepEqual(byLogger, expected) {
+		t.Errorf("expected start errors by logger %v, got %v", expected, byLogger)
+	}
+
+	handler.Functions[0].ErrorLogger = "payments..errors"
+	if err := handler.Validate(); err == nil || !strings.Contains(err.Error(), "error_logger") {
+		t.Errorf("expected validation error for an invalid logger name, got %v", err)
+	}
+}
+
+func TestHandler_ResourceBudget(t *testing.T) {
+	serving := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			serving <- struct{}{}
+			<-release
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		return &Container{ID: "budget" + config.FunctionPath, IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/slow", Image: "app:latest", Port: port, Memory: "256m"},
+		{Methods: []string{"GET"}, Path: "/api/big", Image: "app:latest", Port: port, Memory: "256m"},
+		{Methods: []string{"GET"}, Path: "/api/small", Image: "app:latest", Port: port, Memory: "128m"},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	handler.MaxTotalMemory = "384m"
+	if err := handler.provision(); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/slow"), next)
+	}()
+	<-serving
+
+	// 256m are in use, leaving room for the small function only
+	err = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/big"), next)
+	if !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("expected errBudgetExceeded, got %v", err)
+	}
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %v", err)
+	}
+	mockCM.AssertCalled(t, "StartContainer", 1)
+	if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/small"), next); err != nil {
+		t.Errorf("expected the small function to fit the budget, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("slow request failed: %v", err)
+	}
+	if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/big"), next); err != nil {
+		t.Errorf("expected the budget to be released after the slow request, got %v", err)
+	}
+
+	handler.MaxTotalMemory = "128m"
+	report := handler.ValidationReport()
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "exceeds the handler's max_total_memory") {
+		t.Errorf("expected an error for a function larger than the budget, got %v", err)
+	}
+
+	// A function without memory would escape the budget
+	handler.MaxTotalMemory = "1g"
+	handler.Functions = append(handler.Functions, FunctionConfig{Methods: []string{"GET"}, Path: "/api/unbounded", Image: "app:latest"})
+	if err := handler.Validate(); err == nil || !strings.Contains(err.Error(), "functions[3].memory: required") {
+		t.Errorf("expected memory to be required with max_total_memory, got %v", err)
+	}
+
+	// Compose projects have no memory limit to count
+	handler.Functions = append(handler.Functions[:3], FunctionConfig{Methods: []string{"GET"}, Path: "/api/compose", ComposeFile: "/srv/app/compose.yml", ComposeService: "web"})
+	if err := handler.Validate(); err == nil || !strings.Contains(err.Error(), "functions[3].compose_file: cannot be combined with the handler's max_total_memory") {
+		t.Errorf("expected compose functions to be rejected with max_total_memory, got %v", err)
+	}
+}
+
+func TestHandler_Versions(t *testing.T) {
+	images := make(map[string]int)
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		images[config.Image]++
+		return nil, fmt.Errorf("container start failed")
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/canary", Versions: []FunctionVersion{
+			{Image: "app:v1", Weight: 90},
+			{Image: "app:v2", Weight: 10},
+		}},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	const requests = 2000
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for i := 0; i < requests; i++ {
+		_ = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/canary"), next)
+	}
+	if images["app:v1"]+images["app:v2"] != requests {
+		t.Fatalf("expected every request to start one of the versions, got %v", images)
+	}
+	// 200 expected; the bounds are over 4 standard deviations away
+	if n := images["app:v2"]; n < 140 || n > 260 {
+		t.Errorf("expected about 10%% of requests on app:v2, got %d of %d", n, requests)
+	}
+	if timelines := handler.timelines.list(); len(timelines) == 0 || timelines[len(timelines)-1].Image == "" {
+		t.Errorf("expected timelines to record the version's image, got %+v", timelines)
+	}
+
+	handler.Functions[0].Image = "app:v1"
+	handler.Functions[0].Versions[1].Weight = 0
+	err = handler.Validate()
+	for _, field := range []string{"functions[0].versions:", "functions[0].versions[1].weight:"} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("expected a validation error for %s, got %v", field, err)
+		}
+	}
+}
+
+func TestHandler_WarmupDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	client := &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("ok")),
+			Header:     make(http.Header),
+		},
+	}}
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/warmup", Image: "app:latest", WarmupDelay: caddy.Duration(delay)},
+		{Methods: []string{"GET"}, Path: "/api/immediate", Image: "app:latest"},
+	}, nil, client)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for _, path := range []string{"/api/warmup", "/api/immediate"} {
+		if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", path), next); err != nil {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+	}
+	timelines := handler.timelines.list()
+	if len(timelines) != 2 {
+		t.Fatalf("expected 2 timelines, got %d", len(timelines))
+	}
+	if waited := timelines[0].ProxyStarted.Sub(*timelines[0].ReadyCheckPassed); waited < delay {
+		t.Errorf("expected the request to wait %v after readiness, waited %v", delay, waited)
+	}
+	if waited := timelines[1].ProxyStarted.Sub(*timelines[1].ReadyCheckPassed); waited >= delay {
+		t.Errorf("expected no wait without warmup_delay, waited %v", waited)
+	}
+
+	// The delay counts toward the function's timeout
+	handler.Functions[0].Timeout = caddy.Duration(10 * time.Millisecond)
+	err = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/warmup"), next)
+	if herr, ok := err.(caddyhttp.HandlerError); !ok || herr.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 when the delay outlasts the timeout, got %v", err)
+	}
+}
+
+func TestHandler_PreservesPathEncoding(t *testing.T) {
+	var requestURI string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "encoding", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/api/files/", Image: "app:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	for _, uri := range []string{
+		"/api/files/a%2Fb",
+		"/api/files/with%20space/%E2%9C%93",
+		"/api/files/100%25?name=a%2Fb&x=1",
+		"/api/files/plain/path",
+	} {
+		requestURI = ""
+		if err := handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", uri), next); err != nil {
+			t.Fatalf("%s: unexpected error: %v", uri, err)
+		}
+		if requestURI != uri {
+			t.Errorf("expected the backend to receive %s, got %s", uri, requestURI)
+		}
+	}
+}
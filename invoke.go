@@ -0,0 +1,125 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Invoke runs the function with the given Name for req and returns the
+// container's response, bypassing the Caddy middleware chain. The container
+// is stopped before Invoke returns, so the response body is buffered.
+// Failures are returned as caddyhttp.HandlerError, as from ServeHTTP.
+func (h *Handler) Invoke(ctx context.Context, functionName string, req *http.Request) (*http.Response, error) {
+	function := h.functionNamed(functionName)
+	if function == nil {
+		return nil, caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no function named %q", functionName))
+	}
+
+	req = req.WithContext(ctx)
+	w := newBufferedResponse()
+	if err := h.executeFunction(w, req, function); err != nil {
+		if herr, ok := err.(caddyhttp.HandlerError); ok {
+			observeResponse(function, herr.StatusCode)
+		}
+		return nil, err
+	}
+	return w.response(req), nil
+}
+
+// functionNamed returns the function with the given Name, or nil
+func (h *Handler) functionNamed(name string) *FunctionConfig {
+	if name == "" {
+		return nil
+	}
+	for i := range h.Functions {
+		if h.Functions[i].Name == name {
+			return &h.Functions[i]
+		}
+	}
+	return nil
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in
+// memory for Invoke
+type bufferedResponse struct {
+	header      http.Header
+	sent        http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.wroteHeader {
+		return
+	}
+	b.status = status
+	b.sent = b.header.Clone()
+	b.wroteHeader = true
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// Flush is a no-op, as the response is only read once complete
+func (b *bufferedResponse) Flush() {}
+
+// response returns the buffered response to req. Headers added after the
+// status was written are returned as trailers, as net/http would send them.
+func (b *bufferedResponse) response(req *http.Request) *http.Response {
+	b.WriteHeader(http.StatusOK)
+	resp := &http.Response{
+		Status:        strconv.Itoa(b.status) + " " + http.StatusText(b.status),
+		StatusCode:    b.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        b.sent,
+		Body:          io.NopCloser(bytes.NewReader(b.body.Bytes())),
+		ContentLength: int64(b.body.Len()),
+		Request:       req,
+	}
+	for name, values := range b.header {
+		if trailer, ok := strings.CutPrefix(name, http.TrailerPrefix); ok {
+			name = http.CanonicalHeaderKey(trailer)
+		} else if _, ok := b.sent[name]; ok {
+			continue
+		}
+		if resp.Trailer == nil {
+			resp.Trailer = make(http.Header)
+		}
+		resp.Trailer[name] = values
+	}
+	return resp
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestHandler_Invoke(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := NewMockContainerManager()
+	var images []string
+	mockCM.SetStartContainerFunc(func(_ context.Context, config ContainerConfig) (*Container, error) {
+		images = append(images, config.Image)
+		return &Container{ID: "invoke", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Name: "greeter", Methods: []string{"GET"}, Path: "^/greet$", Image: "greeter:latest", Port: port},
+		{Name: "uploader", Methods: []string{"PUT"}, Path: "^/upload$", Image: "uploader:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	// The function is chosen by name, not by matching the request's path or method
+	req, err := http.NewRequest("POST", "http://internal/any/path?x=1", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := handler.Invoke(context.Background(), "uploader", req)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", resp.StatusCode)
+	}
+	if string(body) != "POST /any/path?x=1 payload" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the container's headers, got %v", resp.Header)
+	}
+	if resp.Trailer.Get("X-Checksum") != "abc" {
+		t.Errorf("expected the container's trailer, got %v", resp.Trailer)
+	}
+	if len(images) != 1 || images[0] != "uploader:latest" {
+		t.Errorf("expected the uploader container to start, got %v", images)
+	}
+
+	_, err = handler.Invoke(context.Background(), "missing", httptest.NewRequest("GET", "/", nil))
+	var herr caddyhttp.HandlerError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown function, got %v", err)
+	}
+
+	// Container failures are returned like ServeHTTP's
+	failAll := 0
+	mockCM.FailAfterNStarts = &failAll
+	_, err = handler.Invoke(context.Background(), "greeter", httptest.NewRequest("GET", "/greet", nil))
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500 when the container fails, got %v", err)
+	}
+}
+
+func TestHandler_InvokeDuplicateNames(t *testing.T) {
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Name: "same", Methods: []string{"GET"}, Path: "/a", Image: "a:latest"},
+		{Name: "same", Methods: []string{"GET"}, Path: "/b", Image: "b:latest"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	if err := handler.Validate(); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("expected a duplicate name error, got %v", err)
+	}
+}
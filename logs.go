@@ -0,0 +1,89 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is a line a container wrote to its stdout or stderr
+type LogEntry struct {
+	// Stream is "stdout" or "stderr"
+	Stream    string    `json:"stream"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+// logsArgs returns the docker logs flags selecting the lines written in the
+// last since, up to the last tail of them. Zero values select all lines.
+func logsArgs(since time.Duration, tail int) []string {
+	args := []string{"--timestamps"}
+	if since > 0 {
+		args = append(args, "--since", since.String())
+	}
+	if tail > 0 {
+		args = append(args, "--tail", strconv.Itoa(tail))
+	}
+	return args
+}
+
+// runLogsCommand runs a docker logs command and returns the lines of both
+// streams, oldest first. docker writes the container's stdout and stderr to
+// its own, which tells the streams apart.
+func runLogsCommand(ctx context.Context, args ...string) ([]LogEntry, error) {
+	stdout, stderr, exitCode, err := runCommand(ctx, "docker", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container logs: %v", err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("failed to get container logs: exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+	return mergeLogs(parseDockerLogs(stdout, "stdout"), parseDockerLogs(stderr, "stderr")), nil
+}
+
+// parseDockerLogs parses the output of docker logs --timestamps, where each
+// line starts with an RFC 3339 timestamp followed by a space. Lines without
+// a timestamp are kept with a zero Timestamp.
+func parseDockerLogs(output, stream string) []LogEntry {
+	var entries []LogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := LogEntry{Stream: stream, Message: line}
+		if stamp, message, ok := strings.Cut(line, " "); ok {
+			if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				entry.Timestamp = t
+				entry.Message = message
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// mergeLogs interleaves the entries of both streams by timestamp
+func mergeLogs(stdout, stderr []LogEntry) []LogEntry {
+	entries := append(stdout, stderr...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	return entries
+}
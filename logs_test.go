@@ -0,0 +1,178 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// dockerLogsStdout and dockerLogsStderr are docker logs --timestamps output
+const (
+	dockerLogsStdout = `2024-05-01T10:00:00.000000001Z listening on :8080
+2024-05-01T10:00:02.500000000Z GET /api/hello 200
+2024-05-01T10:00:03Z   indented message
+`
+	dockerLogsStderr = `2024-05-01T10:00:01.250000000Z warning: cache disabled
+continued line without a timestamp
+`
+)
+
+func TestParseDockerLogs(t *testing.T) {
+	stamp := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	got := mergeLogs(parseDockerLogs(dockerLogsStdout, "stdout"), parseDockerLogs(dockerLogsStderr, "stderr"))
+	want := []LogEntry{
+		{Stream: "stderr", Message: "continued line without a timestamp"},
+		{Stream: "stdout", Timestamp: stamp("2024-05-01T10:00:00.000000001Z"), Message: "listening on :8080"},
+		{Stream: "stderr", Timestamp: stamp("2024-05-01T10:00:01.25Z"), Message: "warning: cache disabled"},
+		{Stream: "stdout", Timestamp: stamp("2024-05-01T10:00:02.5Z"), Message: "GET /api/hello 200"},
+		{Stream: "stdout", Timestamp: stamp("2024-05-01T10:00:03Z"), Message: "  indented message"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected entries:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	if entries := parseDockerLogs("", "stdout"); len(entries) != 0 {
+		t.Errorf("expected no entries for empty output, got %+v", entries)
+	}
+}
+
+func TestLogsArgs(t *testing.T) {
+	tests := []struct {
+		since time.Duration
+		tail  int
+		want  []string
+	}{
+		{0, 0, []string{"--timestamps"}},
+		{5 * time.Minute, 0, []string{"--timestamps", "--since", "5m0s"}},
+		{0, 100, []string{"--timestamps", "--tail", "100"}},
+		{90 * time.Second, 10, []string{"--timestamps", "--since", "1m30s", "--tail", "10"}},
+	}
+	for _, tt := range tests {
+		if got := logsArgs(tt.since, tt.tail); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("logsArgs(%v, %d) = %v, want %v", tt.since, tt.tail, got, tt.want)
+		}
+	}
+}
+
+func TestContainerManager_GetContainerLogs(t *testing.T) {
+	// A fake docker stands in for docker logs, writing each stream to its own
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@" > ` + filepath.Join(dir, "args") + `
+if [ "$2" = "--fail" ]; then
+	echo "Error: No such container: gone" >&2
+	exit 1
+fi
+printf '%s' '` + dockerLogsStdout + `'
+printf '%s' '` + dockerLogsStderr + `' >&2
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cm := NewContainerManager(zap.NewNop())
+	entries, err := cm.GetContainerLogs(context.Background(), "abc", 5*time.Minute, 100)
+	if err != nil {
+		t.Fatalf("GetContainerLogs failed: %v", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "logs --timestamps --since 5m0s --tail 100 abc\n" {
+		t.Errorf("unexpected docker arguments: %q", args)
+	}
+	if len(entries) != 5 || entries[2].Stream != "stderr" || entries[2].Message != "warning: cache disabled" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	// The fake fails when its second argument is --fail
+	if _, err := runLogsCommand(context.Background(), "logs", "--fail"); err == nil {
+		t.Error("expected an error when docker logs fails")
+	}
+}
+
+func TestAdmin_ContainerLogs(t *testing.T) {
+	mockCM := NewMockContainerManager()
+	var gotSince time.Duration
+	var gotTail int
+	mockCM.SetLogsFunc(func(_ context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error) {
+		gotSince, gotTail = since, tail
+		return []LogEntry{{Stream: "stdout", Message: "hello from " + containerID}}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/logs", Image: "app:latest"},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	handler.inflight.add(&Container{ID: "abc"}, &handler.Functions[0], mockCM)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"logs", "GET", "/serverless/containers/abc/logs?since=5m&tail=100", http.StatusOK},
+		{"wrong method", "POST", "/serverless/containers/abc/logs", http.StatusMethodNotAllowed},
+		{"unknown container", "GET", "/serverless/containers/other/logs", http.StatusNotFound},
+		{"no action", "GET", "/serverless/containers/abc", http.StatusNotFound},
+		{"bad since", "GET", "/serverless/containers/abc/logs?since=soon", http.StatusBadRequest},
+		{"negative tail", "GET", "/serverless/containers/abc/logs?tail=-1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			err := new(adminAPI).handleContainer(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if tt.status != http.StatusOK {
+				apiErr, ok := err.(caddy.APIError)
+				if !ok || apiErr.HTTPStatus != tt.status {
+					t.Fatalf("expected status %d, got %v", tt.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected admin error: %v", err)
+			}
+			var entries []LogEntry
+			if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("invalid JSON response: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Message != "hello from abc" {
+				t.Errorf("unexpected entries: %+v", entries)
+			}
+			if gotSince != 5*time.Minute || gotTail != 100 {
+				t.Errorf("expected since 5m and tail 100, got %v and %d", gotSince, gotTail)
+			}
+		})
+	}
+}
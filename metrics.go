@@ -0,0 +1,121 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metric names, shared with the generated alerting rules.
+const (
+	metricsNamespace = "caddy"
+	metricsSubsystem = "serverless"
+
+	metricContainerStarts = metricsNamespace + "_" + metricsSubsystem + "_container_starts_total"
+	metricResponses       = metricsNamespace + "_" + metricsSubsystem + "_responses_total"
+	metricColdStart       = metricsNamespace + "_" + metricsSubsystem + "_cold_start_seconds"
+)
+
+// serverlessMetrics holds the plugin's Prometheus collectors. They are
+// registered with the default registry, which Caddy serves at /metrics.
+var serverlessMetrics = struct {
+	init            sync.Once
+	containerStarts *prometheus.CounterVec
+	responses       *prometheus.CounterVec
+	coldStart       *prometheus.HistogramVec
+	containerAge    *prometheus.HistogramVec
+	eventsDropped   prometheus.Counter
+}{}
+
+func initServerlessMetrics() {
+	serverlessMetrics.init.Do(func() {
+		functionLabels := []string{"function"}
+
+		serverlessMetrics.containerStarts = promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "container_starts_total",
+			Help:      "Number of containers started for each function.",
+		}, functionLabels)
+
+		serverlessMetrics.responses = promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "responses_total",
+			Help:      "Number of responses for each function by status code.",
+		}, []string{"function", "code"})
+
+		serverlessMetrics.coldStart = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "cold_start_seconds",
+			Help:      "Time from starting a container until it passed the readiness check.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		}, functionLabels)
+
+		serverlessMetrics.containerAge = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "container_age_seconds",
+			Help:      "Time from starting a container until it was stopped.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+		}, functionLabels)
+
+		serverlessMetrics.eventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_dropped_total",
+			Help:      "Number of lifecycle events dropped without being delivered to the event webhook.",
+		})
+	})
+}
+
+// observeContainerStart records a started container.
+func observeContainerStart(function *FunctionConfig) {
+	serverlessMetrics.containerStarts.WithLabelValues(function.Path).Inc()
+}
+
+// observeColdStart records how long a container took to become ready.
+func observeColdStart(function *FunctionConfig, d time.Duration) {
+	serverlessMetrics.coldStart.WithLabelValues(function.Path).Observe(d.Seconds())
+}
+
+// observeContainerAge records how long a stopped container lived, if its
+// container manager recorded when it started.
+func observeContainerAge(function *FunctionConfig, container *Container) {
+	if container.StartedAt.IsZero() {
+		return
+	}
+	stoppedAt := time.Now()
+	if container.StoppedAt != nil {
+		stoppedAt = *container.StoppedAt
+	}
+	serverlessMetrics.containerAge.WithLabelValues(function.Path).Observe(stoppedAt.Sub(container.StartedAt).Seconds())
+}
+
+// observeDroppedEvents records lifecycle events that were not delivered.
+func observeDroppedEvents(n int) {
+	serverlessMetrics.eventsDropped.Add(float64(n))
+}
+
+// observeResponse records the status code returned for a function.
+func observeResponse(function *FunctionConfig, status int) {
+	serverlessMetrics.responses.WithLabelValues(function.Path, strconv.Itoa(status)).Inc()
+}
@@ -0,0 +1,327 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MockContainerManager is a ContainerManagerInterface for tests that
+// pretends to start containers without talking to Docker
+type MockContainerManager struct {
+	// FailAfterNStarts, when set, makes StartContainer fail once it has
+	// been called that many times, without calling the function set with
+	// SetStartContainerFunc. Zero fails every start.
+	FailAfterNStarts *int
+
+	// StartDelay and ReadyDelay make StartContainer and WaitForReady wait
+	// before returning, as a slow Docker daemon would. They return the
+	// context's error if it is done first.
+	StartDelay time.Duration
+	ReadyDelay time.Duration
+
+	mutex            sync.Mutex
+	starts           int
+	startContainerFn func(ctx context.Context, config ContainerConfig) (*Container, error)
+	containers       map[string]*Container
+	callLog          []MethodCall
+	execFn           func(ctx context.Context, containerID string, command []string) (string, string, int, error)
+	execCalls        []ExecCall
+	logsFn           func(ctx context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error)
+	pauseCalls       []string
+	resumeCalls      []string
+	runningFn        func(ctx context.Context, containerID string) (bool, error)
+}
+
+// MethodCall records a call to a MockContainerManager method. Args holds the
+// arguments after the context and Result the returned values, each as is if
+// there is one and as a []any if there are several.
+type MethodCall struct {
+	Method string
+	Args   any
+	Result any
+}
+
+// ExecCall records a call to MockContainerManager.ExecInContainer
+type ExecCall struct {
+	ContainerID string
+	Command     []string
+}
+
+// NewMockContainerManager returns a mock whose containers start and become
+// ready immediately
+func NewMockContainerManager() *MockContainerManager {
+	m := &MockContainerManager{
+		containers: make(map[string]*Container),
+	}
+
+	// Set default StartContainer implementation
+	m.startContainerFn = func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		container := &Container{
+			ID:   "mock-container-id",
+			IP:   "127.0.0.1",
+			Port: 8080,
+		}
+
+		m.mutex.Lock()
+		m.containers[container.ID] = container
+		m.mutex.Unlock()
+		return container, nil
+	}
+
+	return m
+}
+
+// StartContainer implements ContainerManagerInterface by calling the function field
+func (m *MockContainerManager) StartContainer(ctx context.Context, config ContainerConfig) (*Container, error) {
+	var container *Container
+	err := sleepContext(ctx, m.StartDelay)
+	if err == nil {
+		m.mutex.Lock()
+		m.starts++
+		start := m.starts
+		failing := m.FailAfterNStarts != nil && start > *m.FailAfterNStarts
+		startFn := m.startContainerFn
+		m.mutex.Unlock()
+		if failing {
+			err = &MockError{message: fmt.Sprintf("mock container start failure (start %d)", start)}
+		} else {
+			container, err = startFn(ctx, config)
+		}
+	}
+	if container != nil {
+		// Containers from SetStartContainerFunc are tracked too, so that
+		// StopContainer sets their StoppedAt
+		m.mutex.Lock()
+		if container.StartedAt.IsZero() {
+			container.StartedAt = time.Now()
+		}
+		m.containers[container.ID] = container
+		m.mutex.Unlock()
+	}
+	m.record("StartContainer", config, []any{container, err})
+	return container, err
+}
+
+// SetStartContainerFunc allows overriding the StartContainer behavior
+func (m *MockContainerManager) SetStartContainerFunc(fn func(ctx context.Context, config ContainerConfig) (*Container, error)) {
+	m.mutex.Lock()
+	m.startContainerFn = fn
+	m.mutex.Unlock()
+}
+
+// Ensure MockContainerManager implements ContainerManagerInterface
+var _ ContainerManagerInterface = (*MockContainerManager)(nil)
+
+func (m *MockContainerManager) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error {
+	err := sleepContext(ctx, m.ReadyDelay)
+	m.record("WaitForReady", []any{container, timeout, port, maxAttempts}, err)
+	return err
+}
+
+func (m *MockContainerManager) StopContainer(_ context.Context, containerID string) error {
+	m.mutex.Lock()
+	if container, ok := m.containers[containerID]; ok {
+		container.markStopped()
+		delete(m.containers, containerID)
+	}
+	m.mutex.Unlock()
+	m.record("StopContainer", containerID, nil)
+	return nil
+}
+
+// ExecInContainer records the call and returns the result of the function
+// set with SetExecFunc, or empty output and a zero exit code
+func (m *MockContainerManager) ExecInContainer(ctx context.Context, containerID string, command []string) (string, string, int, error) {
+	m.mutex.Lock()
+	m.execCalls = append(m.execCalls, ExecCall{ContainerID: containerID, Command: command})
+	fn := m.execFn
+	m.mutex.Unlock()
+	var stdout, stderr string
+	var exitCode int
+	var err error
+	if fn != nil {
+		stdout, stderr, exitCode, err = fn(ctx, containerID, command)
+	}
+	m.record("ExecInContainer", []any{containerID, command}, []any{stdout, stderr, exitCode, err})
+	return stdout, stderr, exitCode, err
+}
+
+// SetExecFunc allows overriding the ExecInContainer behavior
+func (m *MockContainerManager) SetExecFunc(fn func(ctx context.Context, containerID string, command []string) (stdout string, stderr string, exitCode int, err error)) {
+	m.mutex.Lock()
+	m.execFn = fn
+	m.mutex.Unlock()
+}
+
+// ExecCalls returns the ExecInContainer calls made so far
+func (m *MockContainerManager) ExecCalls() []ExecCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]ExecCall(nil), m.execCalls...)
+}
+
+// GetContainerLogs returns the result of the function set with SetLogsFunc,
+// or no entries
+func (m *MockContainerManager) GetContainerLogs(ctx context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error) {
+	m.mutex.Lock()
+	fn := m.logsFn
+	m.mutex.Unlock()
+	var entries []LogEntry
+	var err error
+	if fn != nil {
+		entries, err = fn(ctx, containerID, since, tail)
+	}
+	m.record("GetContainerLogs", []any{containerID, since, tail}, []any{entries, err})
+	return entries, err
+}
+
+// SetLogsFunc allows overriding the GetContainerLogs behavior
+func (m *MockContainerManager) SetLogsFunc(fn func(ctx context.Context, containerID string, since time.Duration, tail int) ([]LogEntry, error)) {
+	m.mutex.Lock()
+	m.logsFn = fn
+	m.mutex.Unlock()
+}
+
+// PauseContainer records the ID of the paused container
+func (m *MockContainerManager) PauseContainer(_ context.Context, containerID string) error {
+	m.mutex.Lock()
+	m.pauseCalls = append(m.pauseCalls, containerID)
+	m.mutex.Unlock()
+	m.record("PauseContainer", containerID, nil)
+	return nil
+}
+
+// ResumeContainer records the ID of the resumed container
+func (m *MockContainerManager) ResumeContainer(_ context.Context, containerID string) error {
+	m.mutex.Lock()
+	m.resumeCalls = append(m.resumeCalls, containerID)
+	m.mutex.Unlock()
+	m.record("ResumeContainer", containerID, nil)
+	return nil
+}
+
+// ContainerRunning returns the result of the function set with
+// SetRunningFunc, or reports every container as running
+func (m *MockContainerManager) ContainerRunning(ctx context.Context, containerID string) (bool, error) {
+	m.mutex.Lock()
+	fn := m.runningFn
+	m.mutex.Unlock()
+	running := true
+	var err error
+	if fn != nil {
+		running, err = fn(ctx, containerID)
+	}
+	m.record("ContainerRunning", containerID, []any{running, err})
+	return running, err
+}
+
+// SetRunningFunc allows overriding the ContainerRunning behavior
+func (m *MockContainerManager) SetRunningFunc(fn func(ctx context.Context, containerID string) (bool, error)) {
+	m.mutex.Lock()
+	m.runningFn = fn
+	m.mutex.Unlock()
+}
+
+// PauseCalls returns the IDs of the containers paused so far
+func (m *MockContainerManager) PauseCalls() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.pauseCalls...)
+}
+
+// ResumeCalls returns the IDs of the containers resumed so far
+func (m *MockContainerManager) ResumeCalls() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.resumeCalls...)
+}
+
+func (m *MockContainerManager) Cleanup() error {
+	m.mutex.Lock()
+	m.containers = make(map[string]*Container)
+	m.mutex.Unlock()
+	m.record("Cleanup", nil, nil)
+	return nil
+}
+
+// record appends a call to the call log
+func (m *MockContainerManager) record(method string, args, result any) {
+	m.mutex.Lock()
+	m.callLog = append(m.callLog, MethodCall{Method: method, Args: args, Result: result})
+	m.mutex.Unlock()
+}
+
+// CallLog returns the calls made so far, in order
+func (m *MockContainerManager) CallLog() []MethodCall {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]MethodCall(nil), m.callLog...)
+}
+
+// ResetCallLog forgets the calls made so far, e.g. between subtests
+func (m *MockContainerManager) ResetCallLog() {
+	m.mutex.Lock()
+	m.callLog = nil
+	m.mutex.Unlock()
+}
+
+// callCount returns how many times method was called
+func (m *MockContainerManager) callCount(method string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	n := 0
+	for _, call := range m.callLog {
+		if call.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// TestingT is the part of testing.TB used by the mock's assertions, so that
+// the package does not depend on the testing package
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// AssertCalled fails the test unless method was called exactly times times
+func (m *MockContainerManager) AssertCalled(t TestingT, method string, times int) {
+	t.Helper()
+	if n := m.callCount(method); n != times {
+		t.Errorf("expected %s to be called %d times, got %d", method, times, n)
+	}
+}
+
+// AssertNotCalled fails the test if method was called
+func (m *MockContainerManager) AssertNotCalled(t TestingT, method string) {
+	t.Helper()
+	if n := m.callCount(method); n != 0 {
+		t.Errorf("expected %s not to be called, got %d calls", method, n)
+	}
+}
+
+// MockError is the error returned by a failing MockContainerManager
+type MockError struct {
+	message string
+}
+
+func (e *MockError) Error() string {
+	return e.message
+}
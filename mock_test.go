@@ -0,0 +1,120 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	serverless "github.com/jose/caddy-serverless"
+)
+
+func TestMockContainerManager_CallLog(t *testing.T) {
+	cm := serverless.NewMockContainerManager()
+	ctx := context.Background()
+	container, err := cm.StartContainer(ctx, serverless.ContainerConfig{Image: "hello:latest"})
+	if err != nil {
+		t.Fatalf("StartContainer failed: %v", err)
+	}
+	if err := cm.StopContainer(ctx, container.ID); err != nil {
+		t.Fatalf("StopContainer failed: %v", err)
+	}
+
+	calls := cm.CallLog()
+	if len(calls) != 2 || calls[0].Method != "StartContainer" || calls[1].Method != "StopContainer" {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	if config, ok := calls[0].Args.(serverless.ContainerConfig); !ok || config.Image != "hello:latest" {
+		t.Errorf("expected the container config as arguments, got %+v", calls[0].Args)
+	}
+	if result, ok := calls[0].Result.([]any); !ok || len(result) != 2 || result[0] != container || result[1] != nil {
+		t.Errorf("expected the container and no error as result, got %+v", calls[0].Result)
+	}
+	if calls[1].Args != container.ID {
+		t.Errorf("expected the container ID as argument, got %+v", calls[1].Args)
+	}
+	cm.AssertCalled(t, "StartContainer", 1)
+	cm.AssertCalled(t, "StopContainer", 1)
+	cm.AssertNotCalled(t, "WaitForReady")
+
+	cm.ResetCallLog()
+	if calls := cm.CallLog(); len(calls) != 0 {
+		t.Errorf("expected an empty call log after a reset, got %+v", calls)
+	}
+	cm.AssertNotCalled(t, "StartContainer")
+}
+
+func TestMockContainerManager_FailureInjection(t *testing.T) {
+	cm := serverless.NewMockContainerManager()
+	failAfter := 2
+	cm.FailAfterNStarts = &failAfter
+	ctx := context.Background()
+	var errs []error
+	for i := 0; i < 3; i++ {
+		_, err := cm.StartContainer(ctx, serverless.ContainerConfig{Image: "hello:latest"})
+		errs = append(errs, err)
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("expected the first two starts to succeed, got %v", errs)
+	}
+	if errs[2] == nil || !strings.Contains(errs[2].Error(), "start 3") {
+		t.Errorf("expected the third start to fail, got %v", errs[2])
+	}
+
+	// Zero fails every start
+	cm = serverless.NewMockContainerManager()
+	failAll := 0
+	cm.FailAfterNStarts = &failAll
+	if _, err := cm.StartContainer(ctx, serverless.ContainerConfig{Image: "hello:latest"}); err == nil || !strings.Contains(err.Error(), "start 1") {
+		t.Errorf("expected the first start to fail, got %v", err)
+	}
+
+	// Delays end early when the context is done
+	cm.StartDelay = time.Hour
+	cm.ReadyDelay = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := cm.StartContainer(ctx, serverless.ContainerConfig{Image: "hello:latest"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the start to be cut short by the context, got %v", err)
+	}
+	if err := cm.WaitForReady(ctx, &serverless.Container{ID: "hello"}, time.Hour, 8080, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the readiness check to be cut short by the context, got %v", err)
+	}
+}
+
+func TestMockContainerManager_StartedStoppedAt(t *testing.T) {
+	cm := serverless.NewMockContainerManager()
+	ctx := context.Background()
+	container, err := cm.StartContainer(ctx, serverless.ContainerConfig{Image: "hello:latest"})
+	if err != nil {
+		t.Fatalf("StartContainer failed: %v", err)
+	}
+	if container.StartedAt.IsZero() {
+		t.Error("expected StartedAt to be set")
+	}
+	if container.StoppedAt != nil {
+		t.Errorf("expected a running container, got StoppedAt %v", container.StoppedAt)
+	}
+
+	if err := cm.StopContainer(ctx, container.ID); err != nil {
+		t.Fatalf("StopContainer failed: %v", err)
+	}
+	if container.StoppedAt == nil || container.StoppedAt.Before(container.StartedAt) {
+		t.Errorf("expected StoppedAt after StartedAt, got %v", container.StoppedAt)
+	}
+}
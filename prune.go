@@ -0,0 +1,146 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// imagePruneTimeout limits how long pruning the images orphaned by a reload
+// may take
+const imagePruneTimeout = 5 * time.Minute
+
+// managedImageLabel marks the images the plugin may prune; only images
+// labelled serverless.managed=true are ever removed
+const managedImageLabel = "serverless.managed"
+
+// configGenerations numbers the configurations handlers are provisioned
+// from. Caddy provisions one configuration at a time, so the handlers of a
+// configuration are numbered in a row and share the Done channel of its
+// context.
+var configGenerations struct {
+	sync.Mutex
+	last     uint64
+	lastDone <-chan struct{}
+}
+
+// configGeneration returns the generation of the configuration whose context
+// is done with done, or a new generation if done is nil
+func configGeneration(done <-chan struct{}) uint64 {
+	configGenerations.Lock()
+	defer configGenerations.Unlock()
+	if done == nil || done != configGenerations.lastDone {
+		configGenerations.last++
+		configGenerations.lastDone = done
+	}
+	return configGenerations.last
+}
+
+// orphanedImages returns the images of h's functions that no function of a
+// newer configuration uses. Without a newer configuration Caddy is shutting
+// down rather than reloading, and no image is orphaned, even if handlers of
+// h's own configuration are still being cleaned up.
+func (h *Handler) orphanedImages() []string {
+	var newer []*Handler
+	for _, other := range registeredHandlers() {
+		if other.generation > h.generation {
+			newer = append(newer, other)
+		}
+	}
+	if len(newer) == 0 {
+		return nil
+	}
+	inUse := make(map[string]bool)
+	for _, other := range newer {
+		for _, fn := range other.Functions {
+			for _, image := range fn.images() {
+				inUse[image] = true
+			}
+		}
+	}
+
+	var images []string
+	for _, fn := range h.Functions {
+		for _, image := range fn.images() {
+			if inUse[image] {
+				continue
+			}
+			inUse[image] = true
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// pruneOrphanedImages removes the orphaned images in the background
+func (h *Handler) pruneOrphanedImages() {
+	images := h.orphanedImages()
+	if len(images) == 0 {
+		return
+	}
+	run := h.imageRunner
+	if run == nil {
+		run = runDocker
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), imagePruneTimeout)
+		defer cancel()
+		pruneImages(ctx, run, images, h.logger)
+	}()
+}
+
+// pruneImages removes images with docker image rm, skipping those without
+// the managedImageLabel and those that any container, running or stopped,
+// was created from. Only images the plugin ran are passed in, and docker
+// refuses to remove an image in use, so images of containers started outside
+// the plugin are never removed.
+func pruneImages(ctx context.Context, run commandRunner, images []string, logger *zap.Logger) {
+	for _, image := range images {
+		output, err := run(ctx, "image", "inspect", "--format", `{{ index .Config.Labels "`+managedImageLabel+`" }}`, image)
+		if err != nil {
+			logger.Warn("failed to inspect image, not pruning it",
+				zap.String("image", image), zap.Error(err), zap.String("output", string(output)))
+			continue
+		}
+		if strings.TrimSpace(string(output)) != "true" {
+			logger.Debug("image is not labelled "+managedImageLabel+"=true, not pruning it", zap.String("image", image))
+			continue
+		}
+
+		output, err = run(ctx, "ps", "--all", "--quiet", "--filter", "ancestor="+image)
+		if err != nil {
+			logger.Warn("failed to check image usage, not pruning it",
+				zap.String("image", image), zap.Error(err), zap.String("output", string(output)))
+			continue
+		}
+		if strings.TrimSpace(string(output)) != "" {
+			logger.Info("image still used by a container, not pruning it", zap.String("image", image))
+			continue
+		}
+		if output, err := run(ctx, "image", "rm", image); err != nil {
+			logger.Warn("failed to prune image",
+				zap.String("image", image), zap.Error(err), zap.String("output", string(output)))
+			continue
+		}
+		logger.Info("pruned image no longer used by any function", zap.String("image", image))
+	}
+}
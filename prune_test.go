@@ -0,0 +1,179 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// fakeImageRunner records docker commands; images listed in used have a
+// container created from them, and those listed in managed are labelled
+// serverless.managed=true
+type fakeImageRunner struct {
+	mutex    sync.Mutex
+	commands []string
+	used     map[string]bool
+	managed  map[string]bool
+	calls    chan struct{}
+}
+
+func (f *fakeImageRunner) run(_ context.Context, args ...string) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.commands = append(f.commands, strings.Join(args, " "))
+	if f.calls != nil {
+		defer func() { f.calls <- struct{}{} }()
+	}
+	switch args[0] {
+	case "ps":
+		if image := strings.TrimPrefix(args[len(args)-1], "ancestor="); f.used[image] {
+			return []byte("0123456789ab\n"), nil
+		}
+		return nil, nil
+	case "image":
+		if args[1] == "inspect" && f.managed[args[len(args)-1]] {
+			return []byte("true\n"), nil
+		}
+		return nil, nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func (f *fakeImageRunner) removed() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var images []string
+	for _, command := range f.commands {
+		if image, ok := strings.CutPrefix(command, "image rm "); ok {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+func TestPruneImages(t *testing.T) {
+	runner := &fakeImageRunner{
+		used:    map[string]bool{"busy:latest": true},
+		managed: map[string]bool{"old:latest": true, "busy:latest": true},
+	}
+	pruneImages(context.Background(), runner.run, []string{"old:latest", "busy:latest", "built-locally:latest"}, zap.NewNop())
+
+	if removed := runner.removed(); strings.Join(removed, ",") != "old:latest" {
+		t.Errorf("expected only old:latest to be removed, got %v", removed)
+	}
+}
+
+func TestHandler_AutoPruneImages(t *testing.T) {
+	old, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/prune-kept", Image: "kept:latest"},
+		{Methods: []string{"GET"}, Path: "/api/prune-changed", Image: "changed:v1"},
+		{Methods: []string{"GET"}, Path: "/api/prune-removed", Image: "removed:latest"},
+		{Methods: []string{"GET"}, Path: "/api/prune-busy", Image: "busy:latest"},
+		{Methods: []string{"GET"}, Path: "/api/prune-unmanaged", Image: "unmanaged:latest"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	old.AutoPruneImages = true
+	runner := &fakeImageRunner{
+		used:    map[string]bool{"busy:latest": true},
+		managed: map[string]bool{"changed:v1": true, "removed:latest": true, "busy:latest": true},
+		calls:   make(chan struct{}, 16),
+	}
+	old.imageRunner = runner.run
+
+	// The reloaded configuration keeps one image and upgrades another
+	if _, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/prune-kept", Image: "kept:latest"},
+		{Methods: []string{"GET"}, Path: "/api/prune-changed", Image: "changed:v2"},
+	}, nil, nil); err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+
+	if err := old.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	// Four orphaned images are inspected, the three managed ones checked for
+	// containers and two of them removed
+	for i := 0; i < 9; i++ {
+		select {
+		case <-runner.calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for pruning, ran %v", runner.commands)
+		}
+	}
+
+	if removed := runner.removed(); strings.Join(removed, ",") != "changed:v1,removed:latest" {
+		t.Errorf("expected the orphaned images to be removed, got %v", removed)
+	}
+}
+
+func TestHandler_OrphanedImagesOnShutdown(t *testing.T) {
+	h, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/shutdown", Image: "app:latest"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	for _, other := range registeredHandlers() {
+		unregisterHandler(other)
+	}
+	if images := h.orphanedImages(); len(images) != 0 {
+		t.Errorf("expected no images to be pruned without a reloaded configuration, got %v", images)
+	}
+}
+
+func TestHandler_OrphanedImagesOnShutdownWithTwoHandlers(t *testing.T) {
+	// Both handlers come from the same configuration
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	first := &Handler{Functions: []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/first", Image: "first:latest"},
+	}, AutoPruneImages: true}
+	second := &Handler{Functions: []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/second", Image: "second:latest"},
+	}, AutoPruneImages: true}
+	runner := &fakeImageRunner{managed: map[string]bool{"first:latest": true, "second:latest": true}}
+	for _, h := range []*Handler{first, second} {
+		if err := h.Provision(ctx); err != nil {
+			t.Fatalf("failed to provision handler: %v", err)
+		}
+		h.imageRunner = runner.run
+	}
+
+	// On shutdown the first handler is cleaned up while the second is
+	// still registered
+	if images := first.orphanedImages(); len(images) != 0 {
+		t.Errorf("expected no images to be orphaned by a handler of the same configuration, got %v", images)
+	}
+	for _, h := range []*Handler{first, second} {
+		if err := h.Cleanup(); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+	}
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if len(runner.commands) != 0 {
+		t.Errorf("expected no docker commands on shutdown, got %v", runner.commands)
+	}
+}
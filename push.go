@@ -0,0 +1,103 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// preloadLinks returns the same-origin paths of the Link header's
+// rel=preload entries, skipping those marked nopush
+func preloadLinks(header http.Header) []string {
+	var paths []string
+	for _, value := range header.Values("Link") {
+		for _, link := range splitLinks(value) {
+			target, params, ok := strings.Cut(link, ">")
+			target = strings.TrimSpace(target)
+			if !ok || !strings.HasPrefix(target, "<") {
+				continue
+			}
+			target = strings.TrimPrefix(target, "<")
+			// Only paths on this host can be pushed
+			if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+				continue
+			}
+			preload, nopush := false, false
+			for _, param := range strings.Split(params, ";") {
+				name, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "rel":
+					for _, rel := range strings.Fields(strings.Trim(val, `"`)) {
+						if strings.EqualFold(rel, "preload") {
+							preload = true
+						}
+					}
+				case "nopush":
+					nopush = true
+				}
+			}
+			if preload && !nopush {
+				paths = append(paths, target)
+			}
+		}
+	}
+	return paths
+}
+
+// splitLinks splits a Link header value on the commas between links,
+// ignoring commas inside a link's <URI>
+func splitLinks(value string) []string {
+	var links []string
+	inURI := false
+	start := 0
+	for i, c := range value {
+		switch {
+		case c == '<':
+			inURI = true
+		case c == '>':
+			inURI = false
+		case c == ',' && !inURI:
+			links = append(links, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(links, value[start:])
+}
+
+// pushPreloads pushes the resources the container's response preloads, if
+// the client supports HTTP/2 push. Failures only skip the push.
+func (h *Handler) pushPreloads(w http.ResponseWriter, header http.Header) {
+	paths := preloadLinks(header)
+	if len(paths) == 0 {
+		return
+	}
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return
+	}
+	for _, path := range paths {
+		if err := pusher.Push(path, nil); err != nil {
+			if errors.Is(err, http.ErrNotSupported) {
+				h.logger.Debug("client does not support HTTP/2 push", zap.String("path", path))
+				return
+			}
+			h.logger.Debug("failed to push resource", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestPreloadLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		links    []string
+		expected []string
+	}{
+		{name: "single preload", links: []string{"</style.css>; rel=preload; as=style"}, expected: []string{"/style.css"}},
+		{name: "several links in one header", links: []string{`</app.js>; rel="preload"; as=script, </next>; rel=next`}, expected: []string{"/app.js"}},
+		{name: "several headers", links: []string{"</a.css>; rel=preload", "</b.css>; rel=preload"}, expected: []string{"/a.css", "/b.css"}},
+		{name: "rel list", links: []string{`</font.woff2>; rel="preload prefetch"; as=font`}, expected: []string{"/font.woff2"}},
+		{name: "comma in uri", links: []string{"</img/a,b.png>; rel=preload; as=image"}, expected: []string{"/img/a,b.png"}},
+		{name: "nopush", links: []string{"</style.css>; rel=preload; nopush"}, expected: nil},
+		{name: "other origin", links: []string{"<https://cdn.example.com/lib.js>; rel=preload", "<//cdn.example.com/x.js>; rel=preload"}, expected: nil},
+		{name: "not preload", links: []string{"</next>; rel=next"}, expected: nil},
+		{name: "malformed", links: []string{"/style.css; rel=preload"}, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Link": tt.links}
+			got := preloadLinks(header)
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// recordingPusher records the pushes attempted on a ResponseWriter and
+// passes them on
+type recordingPusher struct {
+	http.ResponseWriter
+	mutex  sync.Mutex
+	pushed []string
+	errs   []error
+}
+
+func (p *recordingPusher) Push(target string, opts *http.PushOptions) error {
+	err := p.ResponseWriter.(http.Pusher).Push(target, opts)
+	p.mutex.Lock()
+	p.pushed = append(p.pushed, target)
+	p.errs = append(p.errs, err)
+	p.mutex.Unlock()
+	return err
+}
+
+func (p *recordingPusher) Flush() {
+	p.ResponseWriter.(http.Flusher).Flush()
+}
+
+func TestHandler_HTTP2Push(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.Header().Add("Link", "</app.js>; rel=preload; as=script; nopush")
+		_, _ = io.WriteString(w, "<html></html>")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	for _, enabled := range []bool{true, false} {
+		t.Run("enabled="+strconv.FormatBool(enabled), func(t *testing.T) {
+			mockCM := NewMockContainerManager()
+			mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+				return &Container{ID: "push", IP: host, Port: port}, nil
+			})
+			handler, err := newTestHandler(t, []FunctionConfig{
+				{Methods: []string{"GET"}, Path: "^/page$", Image: "web:latest", Port: port, EnableHTTP2Push: enabled},
+			}, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			var pusher *recordingPusher
+			front := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := w.(http.Pusher); !ok {
+					t.Errorf("expected an HTTP/2 ResponseWriter to support push")
+					return
+				}
+				pusher = &recordingPusher{ResponseWriter: w}
+				next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+				if err := handler.ServeHTTP(pusher, r, next); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}))
+			front.EnableHTTP2 = true
+			front.StartTLS()
+			defer front.Close()
+
+			resp, err := front.Client().Get(front.URL + "/page")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Fatalf("expected an HTTP/2 response, got %s", resp.Proto)
+			}
+			// Go's client refuses pushes, so the push fails with
+			// ErrNotSupported and the response must still be served
+			if string(body) != "<html></html>" {
+				t.Errorf("expected the page to be served, got %q", body)
+			}
+
+			if !enabled {
+				if len(pusher.pushed) != 0 {
+					t.Errorf("expected no push when disabled, got %v", pusher.pushed)
+				}
+				return
+			}
+			if strings.Join(pusher.pushed, " ") != "/style.css" {
+				t.Errorf("expected a push of /style.css, got %v", pusher.pushed)
+			}
+			if len(pusher.errs) == 1 && !errors.Is(pusher.errs[0], http.ErrNotSupported) {
+				t.Errorf("expected the client to refuse the push, got %v", pusher.errs[0])
+			}
+		})
+	}
+
+	// Writers without push support are served normally
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "push", IP: host, Port: port}, nil
+	})
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/page$", Image: "web:latest", Port: port, EnableHTTP2Push: true},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	w := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	if err := handler.ServeHTTP(w, fakeRequest("GET", "/page"), next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Body.String() != "<html></html>" {
+		t.Errorf("expected the page to be served, got %q", w.Body.String())
+	}
+}
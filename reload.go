@@ -0,0 +1,172 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// hashFunctionConfig returns a SHA-256 over the function's JSON configuration
+// and the handler's global environment, which its containers also receive.
+// Functions with the same hash run identical containers.
+func hashFunctionConfig(fn FunctionConfig, globalEnv map[string]string) (string, error) {
+	data, err := json.Marshal(struct {
+		Function          FunctionConfig    `json:"function"`
+		GlobalEnvironment map[string]string `json:"global_environment,omitempty"`
+	}{fn, globalEnv})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// configHashesInUse returns the configuration hashes of the functions served
+// by handlers of a newer configuration than h's. Other handlers of h's own
+// configuration, e.g. a site sharing h's functions through an imported
+// snippet, are being cleaned up as well and do not keep containers running.
+func (h *Handler) configHashesInUse() map[string]bool {
+	hashes := make(map[string]bool)
+	for _, other := range registeredHandlers() {
+		if other.generation <= h.generation {
+			continue
+		}
+		for _, fn := range other.Functions {
+			hashes[fn.ConfigHash] = true
+		}
+	}
+	return hashes
+}
+
+// inflightContainers tracks the containers serving requests, so that a
+// reload only stops those of functions whose configuration changed.
+type inflightContainers struct {
+	mutex      sync.Mutex
+	containers map[string]inflightContainer
+}
+
+type inflightContainer struct {
+	function  *FunctionConfig
+	manager   ContainerManagerInterface
+	status    string
+	startedAt time.Time
+}
+
+// Statuses of in-flight containers
+const (
+	ContainerStatusRunning = "running"
+	ContainerStatusPaused  = "paused"
+	ContainerStatusStopped = "stopped"
+)
+
+func newInflightContainers() *inflightContainers {
+	return &inflightContainers{containers: make(map[string]inflightContainer)}
+}
+
+func (c *inflightContainers) add(container *Container, function *FunctionConfig, manager ContainerManagerInterface) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.containers[container.ID] = inflightContainer{
+		function:  function,
+		manager:   manager,
+		status:    ContainerStatusRunning,
+		startedAt: container.StartedAt,
+	}
+	c.mutex.Unlock()
+}
+
+// setStatus updates the status of an in-flight container, reporting whether
+// it is still tracked
+func (c *inflightContainers) setStatus(containerID, status string) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	container, ok := c.containers[containerID]
+	if ok {
+		container.status = status
+		c.containers[containerID] = container
+	}
+	return ok
+}
+
+func (c *inflightContainers) remove(containerID string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	delete(c.containers, containerID)
+	c.mutex.Unlock()
+}
+
+// snapshot returns a copy of the in-flight containers by ID
+func (c *inflightContainers) snapshot() map[string]inflightContainer {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	containers := make(map[string]inflightContainer, len(c.containers))
+	for id, container := range c.containers {
+		containers[id] = container
+	}
+	return containers
+}
+
+// stopChanged stops the in-flight containers of functions that no registered
+// handler serves with the same configuration any more, and returns how many
+// containers were left to finish their requests. If there are none, nothing
+// is stopped, leaving it to the container managers' Cleanup.
+func (c *inflightContainers) stopChanged(ctx context.Context, h *Handler) (draining int, err error) {
+	if c == nil {
+		return 0, nil
+	}
+	inUse := h.configHashesInUse()
+	containers := c.snapshot()
+	for _, container := range containers {
+		if inUse[container.function.ConfigHash] {
+			draining++
+		}
+	}
+	if draining == 0 {
+		return 0, nil
+	}
+
+	for id, container := range containers {
+		if inUse[container.function.ConfigHash] {
+			h.logger.Info("config unchanged, skipping container restart",
+				zap.String("path", container.function.Path),
+				zap.String("container_id", id))
+			continue
+		}
+		if stopErr := container.manager.StopContainer(ctx, id); stopErr != nil {
+			h.logger.Error("failed to stop container during cleanup", zap.String("container_id", id), zap.Error(stopErr))
+			err = stopErr
+			continue
+		}
+		c.setStatus(id, ContainerStatusStopped)
+	}
+	return draining, err
+}
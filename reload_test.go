@@ -0,0 +1,169 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHashFunctionConfig(t *testing.T) {
+	fn := FunctionConfig{
+		Methods:     []string{"GET"},
+		Path:        "/api/hash",
+		Image:       "hash:latest",
+		Environment: map[string]string{"A": "1", "B": "2"},
+	}
+	same := fn
+	same.Environment = map[string]string{"B": "2", "A": "1"}
+	same.ConfigHash = "stale"
+
+	hash, err := hashFunctionConfig(fn, nil)
+	if err != nil {
+		t.Fatalf("hashFunctionConfig failed: %v", err)
+	}
+	sameHash, err := hashFunctionConfig(same, nil)
+	if err != nil {
+		t.Fatalf("hashFunctionConfig failed: %v", err)
+	}
+	if hash != sameHash {
+		t.Errorf("expected identical configs to hash equally, got %s and %s", hash, sameHash)
+	}
+
+	changed := fn
+	changed.Image = "hash:v2"
+	changedHash, err := hashFunctionConfig(changed, nil)
+	if err != nil {
+		t.Fatalf("hashFunctionConfig failed: %v", err)
+	}
+	if changedHash == hash {
+		t.Error("expected a changed image to change the hash")
+	}
+
+	// Containers also receive the handler's global environment
+	globalHash, err := hashFunctionConfig(fn, map[string]string{"REGION": "eu"})
+	if err != nil {
+		t.Fatalf("hashFunctionConfig failed: %v", err)
+	}
+	if globalHash == hash {
+		t.Error("expected a changed global environment to change the hash")
+	}
+}
+
+func TestHandler_ReloadStopsOnlyChangedFunctions(t *testing.T) {
+	functions := []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/reload-kept", Image: "kept:latest"},
+		{Methods: []string{"GET"}, Path: "/api/reload-changed", Image: "changed:latest"},
+	}
+
+	tests := []struct {
+		name            string
+		newImages       []string
+		expectedStopped []string
+		expectCleanup   bool
+	}{
+		{
+			name:            "unchanged function keeps its container",
+			newImages:       []string{"kept:latest", "changed:v2"},
+			expectedStopped: []string{"changed-1"},
+		},
+		{
+			name:          "all functions changed",
+			newImages:     []string{"kept:v2", "changed:v2"},
+			expectCleanup: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCM := &reloadMock{MockContainerManager: NewMockContainerManager()}
+			old, err := newTestHandler(t, functions, mockCM, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+			// Both functions are serving a request
+			old.inflight.add(&Container{ID: "kept-1"}, &old.Functions[0], mockCM)
+			old.inflight.add(&Container{ID: "changed-1"}, &old.Functions[1], mockCM)
+
+			reloaded := append([]FunctionConfig(nil), functions...)
+			for i, image := range tt.newImages {
+				reloaded[i].Image = image
+			}
+			if _, err := newTestHandler(t, reloaded, nil, nil); err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+
+			if err := old.Cleanup(); err != nil {
+				t.Fatalf("Cleanup failed: %v", err)
+			}
+			if strings.Join(mockCM.stopped, ",") != strings.Join(tt.expectedStopped, ",") {
+				t.Errorf("expected stopped containers %v, got %v", tt.expectedStopped, mockCM.stopped)
+			}
+			// Stopped containers are listed as such until their request completes
+			for _, id := range tt.expectedStopped {
+				if status := old.inflight.snapshot()[id].status; status != ContainerStatusStopped {
+					t.Errorf("expected %s to be %s, got %q", id, ContainerStatusStopped, status)
+				}
+			}
+			if (mockCM.cleanups > 0) != tt.expectCleanup {
+				t.Errorf("expected container manager cleanup: %v, got %d cleanups", tt.expectCleanup, mockCM.cleanups)
+			}
+		})
+	}
+}
+
+func TestHandler_ShutdownStopsSharedFunctions(t *testing.T) {
+	functions := []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/shutdown-shared", Image: "shared:latest"},
+	}
+	mockCM := &reloadMock{MockContainerManager: NewMockContainerManager()}
+	site, err := newTestHandler(t, functions, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	// A second site of the same configuration imports the same function
+	other, err := newTestHandler(t, functions, nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	other.generation = site.generation
+	site.inflight.add(&Container{ID: "shared-1"}, &site.Functions[0], mockCM)
+
+	if err := site.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	// Nothing is left draining, so the container manager stops everything
+	if mockCM.cleanups != 1 {
+		t.Errorf("expected the container manager to be cleaned up on shutdown, got %d cleanups", mockCM.cleanups)
+	}
+}
+
+// reloadMock records the containers stopped and cleanups requested by the handler
+type reloadMock struct {
+	*MockContainerManager
+	stopped  []string
+	cleanups int
+}
+
+func (m *reloadMock) StopContainer(ctx context.Context, containerID string) error {
+	m.stopped = append(m.stopped, containerID)
+	return m.MockContainerManager.StopContainer(ctx, containerID)
+}
+
+func (m *reloadMock) Cleanup() error {
+	m.cleanups++
+	return m.MockContainerManager.Cleanup()
+}
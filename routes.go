@@ -0,0 +1,234 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"mime"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// routeTable indexes the functions registered for one HTTP method. Paths that
+// are anchored literals are matched without running a regex: exact paths such
+// as ^/health$ through a map and prefixes such as ^/api/ through a trie. All
+// other paths are matched one by one, in configuration order.
+type routeTable struct {
+	exact    map[string][]*FunctionConfig
+	prefixes *pathTrie
+	regexes  []*FunctionConfig
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		exact:    make(map[string][]*FunctionConfig),
+		prefixes: new(pathTrie),
+	}
+}
+
+// add registers fn. Its pathRegex is compiled on first use, and never for
+// literal paths. If several functions have the same literal path, the first
+// one added that accepts the request's content type wins.
+func (t *routeTable) add(fn *FunctionConfig) {
+	literal, exact, ok := literalPath(fn.Path)
+	switch {
+	case !ok:
+		t.regexes = append(t.regexes, fn)
+	case exact:
+		t.exact[literal] = append(t.exact[literal], fn)
+	default:
+		t.prefixes.insert(literal, fn)
+	}
+}
+
+// match returns the function for path and contentType, preferring an exact
+// path, then the longest literal prefix, then the first matching regex.
+// Validation rejects paths that do not parse, but should compiling a regex
+// still fail, its function is returned as a match so that the request fails
+// instead of silently falling through to another function.
+func (t *routeTable) match(path, contentType string) *FunctionConfig {
+	if fn := firstAccepting(t.exact[path], contentType); fn != nil {
+		return fn
+	}
+	if fn := t.prefixes.longestPrefix(path, contentType); fn != nil {
+		return fn
+	}
+	for _, fn := range t.regexes {
+		if err := fn.ensureCompiled(); err != nil {
+			return fn
+		}
+		if fn.pathRegex != nil && fn.pathRegex.MatchString(path) && fn.acceptsContentType(contentType) {
+			return fn
+		}
+	}
+	return nil
+}
+
+// firstAccepting returns the first of functions accepting contentType, or nil
+func firstAccepting(functions []*FunctionConfig, contentType string) *FunctionConfig {
+	for _, fn := range functions {
+		if fn.acceptsContentType(contentType) {
+			return fn
+		}
+	}
+	return nil
+}
+
+// acceptsContentType reports whether the function serves requests with the
+// given Content-Type header. Functions without MatchContentType accept any,
+// including none.
+func (fn *FunctionConfig) acceptsContentType(contentType string) bool {
+	if len(fn.MatchContentType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range fn.MatchContentType {
+		pattern = strings.ToLower(pattern)
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// routeKeys returns a key for each method of fn that is equal for functions
+// the route tables cannot tell apart
+func routeKeys(fn FunctionConfig) []string {
+	contentTypes := make([]string, len(fn.MatchContentType))
+	for i, mediaType := range fn.MatchContentType {
+		contentTypes[i] = strings.ToLower(mediaType)
+	}
+	sort.Strings(contentTypes)
+
+	seen := make(map[string]bool, len(fn.Methods))
+	keys := make([]string, 0, len(fn.Methods))
+	for _, method := range fn.Methods {
+		method = strings.ToUpper(method)
+		if seen[method] {
+			continue
+		}
+		seen[method] = true
+		keys = append(keys, method+" "+fn.Path+" "+strings.Join(contentTypes, ","))
+	}
+	return keys
+}
+
+// normalizePath applies the handler's path normalization options to a
+// request path before it is matched. Requests are still proxied with their
+// original path.
+func (h *Handler) normalizePath(path string) string {
+	if h.MergeSlashes && strings.Contains(path, "//") {
+		var b strings.Builder
+		b.Grow(len(path))
+		for i := 0; i < len(path); i++ {
+			if path[i] == '/' && i > 0 && path[i-1] == '/' {
+				continue
+			}
+			b.WriteByte(path[i])
+		}
+		path = b.String()
+	}
+	if h.TrimTrailingSlash && len(path) > 1 && strings.HasSuffix(path, "/") {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	if h.CaseInsensitivePaths {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// literalPath reports whether pattern is a literal anchored at the start,
+// like ^/api/users, and whether it is also anchored at the end.
+func literalPath(pattern string) (literal string, exact bool, ok bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false, false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || len(re.Sub) > 3 {
+		return "", false, false
+	}
+	if re.Sub[0].Op != syntax.OpBeginText {
+		return "", false, false
+	}
+	lit := re.Sub[1]
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return "", false, false
+	}
+	if len(re.Sub) == 3 {
+		if re.Sub[2].Op != syntax.OpEndText {
+			return "", false, false
+		}
+		exact = true
+	}
+	return string(lit.Rune), exact, true
+}
+
+// pathTrie is a byte-wise prefix tree of literal path prefixes.
+type pathTrie struct {
+	children  map[byte]*pathTrie
+	functions []*FunctionConfig
+}
+
+// insert adds fn after the functions already registered for prefix.
+func (t *pathTrie) insert(prefix string, fn *FunctionConfig) {
+	node := t
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*pathTrie)
+		}
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = new(pathTrie)
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	node.functions = append(node.functions, fn)
+}
+
+// longestPrefix returns the function accepting contentType with the longest
+// prefix of path, or nil.
+func (t *pathTrie) longestPrefix(path, contentType string) *FunctionConfig {
+	var match *FunctionConfig
+	node := t
+	for i := 0; ; i++ {
+		if fn := firstAccepting(node.functions, contentType); fn != nil {
+			match = fn
+		}
+		if i == len(path) {
+			return match
+		}
+		next, ok := node.children[path[i]]
+		if !ok {
+			return match
+		}
+		node = next
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLiteralPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		literal string
+		exact   bool
+		ok      bool
+	}{
+		{pattern: "^/api/users", literal: "/api/users", ok: true},
+		{pattern: "^/health$", literal: "/health", exact: true, ok: true},
+		{pattern: `^/v1\.0/`, literal: "/v1.0/", ok: true},
+		{pattern: "^/", literal: "/", ok: true},
+		{pattern: "/api/users"},
+		{pattern: "^/api/.*"},
+		{pattern: "^/api/[0-9]+$"},
+		{pattern: "(?i)^/api"},
+		{pattern: "^/a|^/b"},
+		{pattern: "^$"},
+		{pattern: "^/api/("},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			literal, exact, ok := literalPath(tt.pattern)
+			if literal != tt.literal || exact != tt.exact || ok != tt.ok {
+				t.Errorf("expected (%q, %v, %v), got (%q, %v, %v)",
+					tt.literal, tt.exact, tt.ok, literal, exact, ok)
+			}
+		})
+	}
+}
+
+func TestRouteTable_Match(t *testing.T) {
+	paths := []string{
+		"^/api/",
+		"^/api/users",
+		"^/api/users/me$",
+		"/users",
+		"^/api/.*",
+		"^/api/users",
+	}
+	table := newRouteTable()
+	functions := make([]*FunctionConfig, len(paths))
+	for i, path := range paths {
+		functions[i] = &FunctionConfig{Path: path, pathRegex: regexp.MustCompile(path)}
+		table.add(functions[i])
+	}
+
+	tests := []struct {
+		path     string
+		expected *FunctionConfig
+	}{
+		{path: "/api/users/me", expected: functions[2]},
+		{path: "/api/users/42", expected: functions[1]},
+		{path: "/api/orders", expected: functions[0]},
+		{path: "/v2/users", expected: functions[3]},
+		{path: "/other", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// Every match must agree with the function's own regex
+			got := table.match(tt.path, "")
+			if got != tt.expected {
+				t.Errorf("expected function %v, got %v", tt.expected, got)
+			}
+			if got != nil && !got.pathRegex.MatchString(tt.path) {
+				t.Errorf("matched function %q does not match %q", got.Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestFunctionConfig_AcceptsContentType(t *testing.T) {
+	fn := &FunctionConfig{MatchContentType: []string{"multipart/*", "application/json", "application/vnd.*"}}
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"application/json", true},
+		{"Application/JSON; charset=utf-8", true},
+		{"multipart/form-data; boundary=xyz", true},
+		{"application/vnd.api+json", true},
+		{"application/jsonp", false},
+		{"text/plain", false},
+		{"", false},
+		{"application/json;;", true},
+	}
+	for _, tt := range tests {
+		if got := fn.acceptsContentType(tt.contentType); got != tt.expected {
+			t.Errorf("acceptsContentType(%q) = %v, expected %v", tt.contentType, got, tt.expected)
+		}
+	}
+
+	if !new(FunctionConfig).acceptsContentType("") {
+		t.Error("expected a function without match_content_type to accept requests without a Content-Type")
+	}
+}
+
+func TestRouteTable_MatchContentType(t *testing.T) {
+	uploads := &FunctionConfig{Path: "^/api/upload$", MatchContentType: []string{"multipart/*"}}
+	json := &FunctionConfig{Path: "^/api/upload$", MatchContentType: []string{"application/json"}}
+	prefixed := &FunctionConfig{Path: "^/api/", MatchContentType: []string{"text/*"}}
+	fallback := &FunctionConfig{Path: "^/api/upload$"}
+	table := newRouteTable()
+	for _, fn := range []*FunctionConfig{uploads, json, prefixed, fallback} {
+		table.add(fn)
+	}
+
+	tests := []struct {
+		contentType string
+		expected    *FunctionConfig
+	}{
+		{"multipart/form-data; boundary=xyz", uploads},
+		{"application/json", json},
+		{"text/plain", fallback},
+		{"", fallback},
+	}
+	for _, tt := range tests {
+		if got := table.match("/api/upload", tt.contentType); got != tt.expected {
+			t.Errorf("%q: expected function %v, got %v", tt.contentType, tt.expected, got)
+		}
+	}
+
+	if got := table.match("/api/other", "text/csv"); got != prefixed {
+		t.Errorf("expected the prefix function for text/csv, got %v", got)
+	}
+	if got := table.match("/api/other", "application/json"); got != nil {
+		t.Errorf("expected no function for application/json, got %v", got)
+	}
+}
+
+func TestHandler_NormalizePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  Handler
+		path     string
+		expected string
+	}{
+		{"disabled", Handler{}, "/API//test/", "/API//test/"},
+		{"merge slashes", Handler{MergeSlashes: true}, "//api///test//", "/api/test/"},
+		{"trim trailing slash", Handler{TrimTrailingSlash: true}, "/api/test//", "/api/test"},
+		{"trim keeps root", Handler{TrimTrailingSlash: true}, "//", "/"},
+		{"case insensitive", Handler{CaseInsensitivePaths: true}, "/API/Test", "/api/test"},
+		{"all", Handler{MergeSlashes: true, TrimTrailingSlash: true, CaseInsensitivePaths: true}, "/Api//TEST/", "/api/test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.handler.normalizePath(tt.path); got != tt.expected {
+				t.Errorf("normalizePath(%q) = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandler_PathNormalizationMatching(t *testing.T) {
+	functions := []FunctionConfig{{Methods: []string{"GET"}, Path: "^/api/test$", Image: "test:latest"}}
+	tests := []struct {
+		name        string
+		configure   func(h *Handler)
+		path        string
+		shouldMatch bool
+	}{
+		{"duplicate slash without merge_slashes", func(*Handler) {}, "/api//test", false},
+		{"duplicate slash with merge_slashes", func(h *Handler) { h.MergeSlashes = true }, "/api//test", true},
+		{"trailing slash without trim_trailing_slash", func(*Handler) {}, "/api/test/", false},
+		{"trailing slash with trim_trailing_slash", func(h *Handler) { h.TrimTrailingSlash = true }, "/api/test/", true},
+		{"upper case without case_insensitive_paths", func(*Handler) {}, "/API/Test", false},
+		{"upper case with case_insensitive_paths", func(h *Handler) { h.CaseInsensitivePaths = true }, "/API/Test", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, err := newTestHandler(t, functions, nil, nil)
+			if err != nil {
+				t.Fatalf("newTestHandler failed: %v", err)
+			}
+			tt.configure(handler)
+			fn := handler.findMatchingFunction(fakeRequest("GET", tt.path))
+			if (fn != nil) != tt.shouldMatch {
+				t.Errorf("expected match %v for %s, got %v", tt.shouldMatch, tt.path, fn)
+			}
+		})
+	}
+
+	h := Handler{CaseInsensitivePaths: true, Functions: []FunctionConfig{{Path: "^/API/test", Image: "x"}}}
+	if warnings := h.ValidationReport().Warnings(); len(warnings) != 1 || warnings[0].Field != "functions[0].path" {
+		t.Errorf("expected a warning for an upper case path, got %v", warnings)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"fmt"
+	"os"
+)
+
+// scratchDirPattern names the host directories created for RequestScratch
+const scratchDirPattern = "serverless-scratch-"
+
+// createRequestScratch creates an empty host directory for one request and
+// returns the volumes with it mounted at the function's RequestScratch. The
+// directory is writable by all users, as the container may run as any user.
+func createRequestScratch(function *FunctionConfig, volumes []VolumeMount) (string, []VolumeMount, error) {
+	dir, err := os.MkdirTemp("", scratchDirPattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("creating scratch directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("creating scratch directory: %w", err)
+	}
+	// volumes may be the function's own Volumes, which must not change
+	volumes = append(volumes[:len(volumes):len(volumes)], VolumeMount{
+		Source: dir,
+		Target: function.RequestScratch,
+	})
+	return dir, volumes, nil
+}
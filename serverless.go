@@ -15,13 +15,21 @@
 package serverless
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"path/filepath"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -40,46 +48,486 @@ type Handler struct {
 	// Functions defines the serverless function configurations
 	Functions []FunctionConfig `json:"functions,omitempty"`
 
-	// HTTPClient is the client used to make requests to containers.
-	// It can be overridden for testing.
+	// HTTPClient, when set, is the client used to make requests to the
+	// containers of every function, overriding their own clients. It can be
+	// set for testing.
 	HTTPClient *http.Client `json:"-"`
 
+	// NoMatchStatus, when set, is the status code returned for requests whose
+	// method is handled by at least one function but whose path matches none
+	// of them. By default such requests are passed to the next handler.
+	NoMatchStatus int `json:"no_match_status,omitempty"`
+
+	// NoMatchBody is the response body written along with NoMatchStatus.
+	// If empty, a small JSON error body is written instead.
+	NoMatchBody string `json:"no_match_body,omitempty"`
+
+	// MethodNotAllowed answers requests whose path matches functions of
+	// other methods only with 405 Method Not Allowed and an Allow header
+	// listing those methods, instead of passing them to the next handler.
+	MethodNotAllowed bool `json:"method_not_allowed,omitempty"`
+
+	// TimelineBufferSize is the number of recent execution timelines kept
+	// for the admin API (default: 100)
+	TimelineBufferSize int `json:"timeline_buffer_size,omitempty"`
+
+	// UseSwarm runs functions as Docker Swarm services instead of plain
+	// containers, enabling placement constraints. The Docker daemon must be
+	// part of an active swarm.
+	UseSwarm bool `json:"use_swarm,omitempty"`
+
+	// BackendType runs functions on a container backend registered with
+	// RegisterBackend instead of Docker. Functions with a compose_file still
+	// run with Docker Compose.
+	BackendType string `json:"backend_type,omitempty"`
+
+	// BackendConfig is passed as is to the BackendType's factory.
+	BackendConfig json.RawMessage `json:"backend_config,omitempty"`
+
+	// EventWebhook is a URL that receives a JSON POST for each container
+	// lifecycle event (started, stopped, failed). Events are delivered in the
+	// background and dropped if too many are waiting.
+	EventWebhook string `json:"event_webhook,omitempty"`
+
+	// Debug lets clients ask for diagnostic metadata about their request by
+	// sending X-Serverless-Debug: 1. Keep it off in production, as it
+	// reveals container IDs and timings.
+	Debug bool `json:"debug,omitempty"`
+
+	// DefaultNamespace is the namespace of functions that do not set one.
+	DefaultNamespace string `json:"default_namespace,omitempty"`
+
+	// MergeSlashes collapses runs of slashes in request paths before they
+	// are matched, so that /api//test matches like /api/test.
+	MergeSlashes bool `json:"merge_slashes,omitempty"`
+
+	// TrimTrailingSlash removes trailing slashes from request paths before
+	// they are matched, so that /api/test/ matches like /api/test.
+	TrimTrailingSlash bool `json:"trim_trailing_slash,omitempty"`
+
+	// CaseInsensitivePaths lower-cases request paths before they are
+	// matched, so function paths must be written in lower case.
+	CaseInsensitivePaths bool `json:"case_insensitive_paths,omitempty"`
+
+	// AllowPrivileged lets functions set Privileged. Without it, privileged
+	// functions are rejected, so that granting root on the host takes an
+	// explicit decision at the handler level.
+	AllowPrivileged bool `json:"allow_privileged,omitempty"`
+
+	// DuplicateFunctions is how functions with the same method, path and
+	// content types as an earlier function are reported: "warn" (the
+	// default) or "error". Only the earlier function serves such requests.
+	DuplicateFunctions string `json:"duplicate_functions,omitempty"`
+
+	// AutoPruneImages removes, in the background after a reload, the images
+	// of functions that the new configuration no longer uses. Images that
+	// any container was created from are kept.
+	AutoPruneImages bool `json:"auto_prune_images,omitempty"`
+
+	// GlobalEnvironment is set in the containers of every function. A
+	// function's own Environment takes precedence.
+	GlobalEnvironment map[string]string `json:"global_environment,omitempty"`
+
+	// DefaultFunctionConfig holds settings shared by the functions, such as
+	// timeout or memory. Each function inherits those it leaves unset,
+	// except for its name and path.
+	DefaultFunctionConfig *FunctionConfig `json:"default_function_config,omitempty"`
+
+	// MaxContainers limits the containers the handler runs at once. Requests
+	// that would start another container are rejected with 503.
+	MaxContainers int `json:"max_containers,omitempty"`
+
+	// MaxTotalMemory limits the sum of the memory limits of the containers
+	// the handler runs at once, in docker's format (e.g. 4g). Requests that
+	// would exceed it are rejected with 503. Functions without a memory
+	// limit are not counted.
+	MaxTotalMemory string `json:"max_total_memory,omitempty"`
+
 	containerManager ContainerManagerInterface
+	composeManager   ContainerManagerInterface
 	logger           *zap.Logger
 	routeMap         methodMap
+	timelines        *timelineBuffer
+	events           *eventEmitter
+	inflight         *inflightContainers
+	budget           *resourceBudget
+
+	// generation numbers the configuration the handler was provisioned
+	// from, so that a reload can be told apart from a shutdown
+	generation uint64
+
+	// imageRunner runs the docker commands pruning images; nil runs docker
+	imageRunner commandRunner
 }
 
-// methodMap stores a map of HTTP methods to a map of path regexes to function configurations.
-type methodMap map[string]map[*regexp.Regexp]*FunctionConfig
+// FallbackResponse is a static response served when a function's container
+// cannot be started, so that dependent systems degrade gracefully.
+type FallbackResponse struct {
+	// StatusCode is the response status (default: 503).
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Headers are added to the response.
+	Headers http.Header `json:"headers,omitempty"`
+
+	// Body is the response body.
+	Body string `json:"body,omitempty"`
+}
+
+// Isolation modes for FunctionConfig.Isolation
+const (
+	IsolationShared     = "shared"
+	IsolationPerRequest = "per-request"
+)
+
+// memorySizeRegex matches docker memory sizes such as 512m or 1g
+var memorySizeRegex = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+
+// namespaceRegex matches namespaces, which must be valid in container names
+var namespaceRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// loggerNameRegex matches dot-separated logger names such as payments.errors
+var loggerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// headerNameRegex matches HTTP header names
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// methodMap stores a map of HTTP methods to the functions registered for them.
+type methodMap map[string]*routeTable
 
 // FunctionConfig represents the configuration for a single serverless function
 type FunctionConfig struct {
+	// Name identifies the function for Handler.Invoke. Names must be
+	// unique within a handler.
+	Name string `json:"name,omitempty"`
+
 	// Path specifies the URL path pattern this function handles (supports regex)
 	Path string `json:"path,omitempty"`
 
 	// Image specifies the Docker image to run
 	Image string `json:"image,omitempty"`
 
+	// Versions, set instead of Image, split the function's requests between
+	// several images according to their weights, e.g. to send a canary
+	// image a tenth of the traffic.
+	Versions []FunctionVersion `json:"versions,omitempty"`
+
 	// Methods specifies the HTTP methods this function handles (GET, POST, PUT, DELETE, etc.)
 	Methods []string `json:"methods,omitempty"`
 
+	// MatchContentType restricts the function to requests whose Content-Type
+	// has one of these media types, so that functions on the same path and
+	// method can split requests by type. A type may end with a wildcard, as
+	// in multipart/* or application/vnd.*. When several functions share a
+	// path, the first one accepting the request is selected.
+	MatchContentType []string `json:"match_content_type,omitempty"`
+
+	// AutoOptions controls OPTIONS requests to the function's path. When
+	// true (the default), OPTIONS is proxied to the container like any other
+	// method, if listed in Methods. When false, OPTIONS is answered with 204
+	// and an Allow header listing Methods, without starting a container.
+	AutoOptions *bool `json:"auto_options,omitempty"`
+
 	// Command specifies the command to run in the container
 	Command []string `json:"command,omitempty"`
 
+	// AppendArgs specifies extra arguments appended after Command, allowing
+	// per-deploy parameterization without overriding the full command
+	AppendArgs []string `json:"append_args,omitempty"`
+
 	// Environment specifies environment variables to pass to the container
 	Environment map[string]string `json:"environment,omitempty"`
 
+	// Namespace isolates the function's containers from those of other
+	// deployments sharing the Caddy instance: their names and labels are
+	// prefixed with it (default: the handler's DefaultNamespace).
+	Namespace string `json:"namespace,omitempty"`
+
+	// InheritEnv lists host environment variables passed to the container.
+	// Host values take precedence over the same keys in Environment.
+	InheritEnv []string `json:"inherit_env,omitempty"`
+
+	// InheritAllEnv passes the entire host environment to the container.
+	// Intended for development only, since it may leak secrets into functions.
+	InheritAllEnv bool `json:"inherit_all_env,omitempty"`
+
+	// MaxEnvValueLength limits the length of each environment value in bytes
+	// (default: 65536), so a single -e argument stays within OS exec limits
+	MaxEnvValueLength int `json:"max_env_value_length,omitempty"`
+
+	// MaxEnvSize limits the combined size of all KEY=VALUE pairs passed to
+	// the container in bytes (default: 1048576)
+	MaxEnvSize int `json:"max_env_size,omitempty"`
+
 	// Volumes specifies volume mounts for the container
 	Volumes []VolumeMount `json:"volumes,omitempty"`
 
-	// compiled regex for path matching
+	// RequestScratch mounts a fresh, empty host directory at this container
+	// path for every request, as isolated working space. The directory is
+	// created before the container starts and removed after it stops.
+	RequestScratch string `json:"request_scratch,omitempty"`
+
+	// FileMounts mounts individual host files whose SHA-256 digest is
+	// checked before every container start. A mismatch fails the request.
+	FileMounts []FileMount `json:"file_mounts,omitempty"`
+
+	// compiled regex for path matching, compiled on first use by
+	// ensureCompiled
 	pathRegex *regexp.Regexp
 
+	// once guards the compilation of pathRegex; a pointer so that
+	// FunctionConfig stays copyable
+	once *sync.Once
+
+	// compileErr is the error from compiling Path, if any
+	compileErr error
+
+	// recently seen webhook delivery IDs
+	dedup *webhookDedup
+
+	// Isolation is either "shared" (the default) or "per-request". A
+	// per-request function always gets a brand-new container for each
+	// request, and will be exempt from container pooling once it exists.
+	// Today every request gets its own container in both modes.
+	Isolation string `json:"isolation,omitempty"`
+
+	// FallbackResponse is served instead of an error when the function's
+	// container cannot be started, e.g. because Docker is unavailable.
+	FallbackResponse *FallbackResponse `json:"fallback_response,omitempty"`
+
 	// Timeout specifies the maximum execution time for the function
 	Timeout caddy.Duration `json:"timeout,omitempty"`
 
 	// Port specifies the port the container listens on (default: 8080)
 	Port int `json:"port,omitempty"`
+
+	// ReadyPort is the port checked for readiness, for apps that open a
+	// health port before the one they serve on (default: Port). Compose
+	// functions are always checked on the published service port.
+	ReadyPort int `json:"ready_port,omitempty"`
+
+	// ReadyMaxAttempts bounds the readiness check by the number of probes,
+	// which is more predictable than Timeout under variable load. When
+	// both are set, whichever limit is hit first fails the request.
+	ReadyMaxAttempts int `json:"ready_max_attempts,omitempty"`
+
+	// ReadyFailureRetries is the number of times a container that fails its
+	// readiness check is replaced by a fresh one before the request fails,
+	// for apps that occasionally wedge on start. All attempts share Timeout,
+	// so ReadyMaxAttempts should bound each one.
+	ReadyFailureRetries int `json:"ready_failure_retries,omitempty"`
+
+	// MaxBodySize limits the size of request bodies in bytes (0 means unlimited).
+	// Requests declaring a larger Content-Length are rejected before a container is started.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+
+	// MaxHeaderBytes limits the total size of the request headers forwarded
+	// to the container, counting each "Name: value" line (0 means unlimited).
+	// Larger requests get a 431 before a container is started.
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty"`
+
+	// Memory limits the container's memory, in docker's format (e.g. 256m)
+	Memory string `json:"memory,omitempty"`
+
+	// MemorySwap limits the container's memory plus swap (e.g. 512m), or
+	// -1 for unlimited swap. Requires Memory.
+	MemorySwap string `json:"memory_swap,omitempty"`
+
+	// OOMKillDisable keeps the kernel from OOM killing the container when it
+	// exceeds Memory. Docker only allows this together with Memory.
+	OOMKillDisable bool `json:"oom_kill_disable,omitempty"`
+
+	// OOMScoreAdj makes the kernel OOM killer less (down to -1000) or more
+	// (up to 1000) likely to pick the container under memory pressure, e.g.
+	// -500 for critical functions. Zero keeps the default priority.
+	OOMScoreAdj int `json:"oom_score_adj,omitempty"`
+
+	// CgroupParent places the container under a named cgroup, to attribute
+	// the resource usage of all the function's containers to one service.
+	// Use a path such as /function/payments with docker's cgroupfs driver,
+	// or a slice such as payments.slice with the systemd driver.
+	CgroupParent string `json:"cgroup_parent,omitempty"`
+
+	// IPCMode sets the container's IPC namespace: private, none, host,
+	// shareable to let a sidecar join it, or container:<name> to join the
+	// namespace of a shareable sidecar, e.g. for shared memory. Empty keeps
+	// docker's default. host shares the host's IPC namespace and is
+	// reported as a risk.
+	IPCMode string `json:"ipc_mode,omitempty"`
+
+	// PIDMode is "host" to let the container see and signal the host's
+	// processes, e.g. for debugging tools, which is reported as a risk. The
+	// default, "private", gives the container its own PID namespace.
+	PIDMode string `json:"pid_mode,omitempty"`
+
+	// UsernsMode sets the container's user namespace. "host" disables user
+	// namespace remapping for the container when the daemon enables it, so
+	// root in the container is root on the host, which is reported as a
+	// risk. "keep-id" maps the Caddy user into the container and requires
+	// Podman. Empty keeps the daemon's default.
+	UsernsMode string `json:"userns_mode,omitempty"`
+
+	// GroupAdd adds supplementary groups, by name or GID, to the container's
+	// user, e.g. to access a device or socket owned by a group.
+	GroupAdd []string `json:"group_add,omitempty"`
+
+	// Privileged runs the container with all capabilities and access to the
+	// host's devices, which amounts to root on the host. It is rejected
+	// unless the handler sets AllowPrivileged, and reported as a risk.
+	Privileged bool `json:"privileged,omitempty"`
+
+	// SeccompProfile is the path of a JSON seccomp profile filtering the
+	// container's syscalls, or "unconfined" to disable filtering. Empty
+	// keeps docker's default profile.
+	SeccompProfile string `json:"seccomp_profile,omitempty"`
+
+	// LogConfig rotates the container's logs to keep them from filling the
+	// host's disk. Unset fields default to max_size 10m and max_file 3.
+	LogConfig *ContainerLogConfig `json:"log_config,omitempty"`
+
+	// LogDriver ships the container's logs through a docker logging driver,
+	// such as journald, syslog or fluentd, configured by LogOpts. LogConfig
+	// only applies to the json-file and local drivers.
+	LogDriver string            `json:"log_driver,omitempty"`
+	LogOpts   map[string]string `json:"log_opts,omitempty"`
+
+	// PrebufferRequest reads the whole request body before starting the
+	// container, so a slow client cannot hold a started container idle.
+	// Requires MaxBodySize to bound the memory used.
+	PrebufferRequest bool `json:"prebuffer_request,omitempty"`
+
+	// DisablePortCheck skips verifying that Port is free on the host before
+	// starting the container
+	DisablePortCheck bool `json:"disable_port_check,omitempty"`
+
+	// StatusMap translates status codes returned by the container into the
+	// ones sent to the client, e.g. {"418": 200}. Unmapped codes pass through.
+	StatusMap map[int]int `json:"status_map,omitempty"`
+
+	// WebhookDedup acknowledges repeated deliveries of the same webhook with
+	// 200 OK without starting a container. A delivery whose processing
+	// failed is forgotten so the sender's retry is processed.
+	WebhookDedup *DedupConfig `json:"webhook_dedup,omitempty"`
+
+	// UserAgent overrides the User-Agent header sent to the container.
+	// By default the client's User-Agent is passed through unchanged.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// TimingHeader names a response header reporting how long the container
+	// took to respond, from sending it the request to its first response
+	// byte. Server-Timing gets an "upstream" metric appended to those the
+	// container set; any other header is set to the duration in milliseconds.
+	TimingHeader string `json:"timing_header,omitempty"`
+
+	// PlacementConstraints restricts the swarm nodes the function may run on,
+	// using Docker Swarm constraint syntax (e.g. node.labels.region==us-east).
+	// Only used when the handler runs functions as swarm services.
+	PlacementConstraints []string `json:"placement_constraints,omitempty"`
+
+	// ComposeFile runs the function from a docker compose file instead of a
+	// single image, e.g. for an app with sidecars. Each execution brings up
+	// its own compose project, which is torn down afterwards.
+	ComposeFile string `json:"compose_file,omitempty"`
+
+	// ComposeService names the compose service requests are proxied to.
+	// The service must publish Port. Required when ComposeFile is set.
+	ComposeService string `json:"compose_service,omitempty"`
+
+	// InlineScript is a shell script run with /bin/sh in place of Command.
+	// It is written to a host file when the handler is provisioned, mounted
+	// read-only at /tmp/<hash>.sh, and removed when the handler is cleaned
+	// up.
+	InlineScript string `json:"inline_script,omitempty"`
+
+	// inlineScript mounts the file InlineScript was written to
+	inlineScript VolumeMount
+
+	// Timezone sets the container's TZ environment variable, e.g.
+	// America/New_York. Overrides any TZ set through environment options.
+	Timezone string `json:"timezone,omitempty"`
+
+	// MountLocaltime additionally mounts the host's zoneinfo file for
+	// Timezone at /etc/localtime, for programs that ignore TZ.
+	MountLocaltime bool `json:"mount_localtime,omitempty"`
+
+	// Locale sets the container's LANG and LC_ALL environment variables,
+	// e.g. en_US.UTF-8.
+	Locale string `json:"locale,omitempty"`
+
+	// PostStartCommand is run inside the container (docker exec) once it is
+	// ready and before the request is proxied, e.g. to seed data. A failing
+	// command is logged but does not fail the request.
+	PostStartCommand []string `json:"post_start_command,omitempty"`
+
+	// PostStartTimeout limits how long PostStartCommand may run. Defaults
+	// to the remainder of the function timeout.
+	PostStartTimeout caddy.Duration `json:"post_start_timeout,omitempty"`
+
+	// WarmupDelay is waited after the container passed its readiness check
+	// and ran PostStartCommand, before the request is proxied, for apps that
+	// finish setting up their routes after they start listening. It counts
+	// toward Timeout.
+	WarmupDelay caddy.Duration `json:"warmup_delay,omitempty"`
+
+	// ColdStartBudget is the p95 cold start time above which the generated
+	// ColdStartBudgetExceeded alert fires (default: 5s).
+	ColdStartBudget caddy.Duration `json:"cold_start_budget,omitempty"`
+
+	// EnableHTTP2Push pushes the same-origin resources named by the
+	// response's Link rel=preload headers to HTTP/2 clients that accept
+	// push. Off by default, as pushing resources the client has cached
+	// wastes bandwidth.
+	EnableHTTP2Push bool `json:"enable_http2_push,omitempty"`
+
+	// Transport tunes the connection to the function's containers, e.g. to
+	// fail fast for latency-sensitive functions.
+	Transport *TransportConfig `json:"transport,omitempty"`
+
+	// httpClient is built from Transport during provisioning. Each function
+	// has its own, so that a slow function cannot use up the connections of
+	// the others.
+	httpClient *http.Client
+
+	// DebugBodies logs the headers and bodies of requests and responses
+	// proxied to the container at debug level, for troubleshooting.
+	DebugBodies bool `json:"debug_bodies,omitempty"`
+
+	// DebugBodyLimit is the number of bytes of each body logged with
+	// DebugBodies (default: 4096). Bodies are streamed regardless.
+	DebugBodyLimit int `json:"debug_body_limit,omitempty"`
+
+	// DebugRedact lists header names and JSON field names whose values are
+	// replaced with [REDACTED] in debug logs. Authorization,
+	// Proxy-Authorization, Cookie and Set-Cookie are always redacted.
+	DebugRedact []string `json:"debug_redact,omitempty"`
+
+	// redactFields matches the values of the JSON fields in DebugRedact
+	redactFields *regexp.Regexp
+
+	// AllowIPs lists the IP addresses and CIDR ranges of clients allowed to
+	// invoke the function. If empty, all clients not denied are allowed.
+	AllowIPs []string `json:"allow_ips,omitempty"`
+
+	// DenyIPs lists the IP addresses and CIDR ranges of clients refused
+	// with 403. Takes precedence over AllowIPs.
+	DenyIPs []string `json:"deny_ips,omitempty"`
+
+	// ipFilter is built from AllowIPs and DenyIPs during provisioning
+	ipFilter *ipFilter
+
+	// ErrorLogger names the logger receiving the function's error logs,
+	// below the handler's logger, e.g. "payments" logs as
+	// http.handlers.serverless.payments. Caddy's log config can then send
+	// them to their own sink or leave them out of the main log.
+	ErrorLogger string `json:"error_logger,omitempty"`
+
+	// errorLog is derived from ErrorLogger during provisioning; nil means
+	// the handler's logger
+	errorLog *zap.Logger
+
+	// ConfigHash is a SHA-256 of the function's JSON configuration and the
+	// handler's global environment, set during provisioning. On reload, in-flight containers of functions
+	// whose hash is unchanged are left to finish their requests.
+	ConfigHash string `json:"-"`
 }
 
 // CaddyModule returns the Caddy module information.
@@ -93,90 +541,583 @@ func (Handler) CaddyModule() caddy.ModuleInfo {
 // Provision sets up the serverless handler.
 func (h *Handler) Provision(ctx caddy.Context) error {
 	h.logger = ctx.Logger()
-	if h.HTTPClient == nil {
-		h.HTTPClient = &http.Client{
-			Timeout: 30 * time.Second, // Default timeout
+	if ctx.Context != nil {
+		h.generation = configGeneration(ctx.Done())
+	}
+	if h.BackendType != "" {
+		manager, err := h.newBackend()
+		if err != nil {
+			return err
+		}
+		h.containerManager = manager
+	} else if h.UseSwarm {
+		active, err := swarmActive(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to detect swarm mode: %v", err)
+		}
+		if !active {
+			return fmt.Errorf("use_swarm is enabled but the Docker daemon is not part of an active swarm")
 		}
+		h.containerManager = NewSwarmContainerManager(h.logger)
+	} else {
+		h.containerManager = NewContainerManager(h.logger)
 	}
-	h.containerManager = NewContainerManager(h.logger)
+	if err := h.provision(); err != nil {
+		return err
+	}
+
+	report := h.ValidationReport()
+	for _, warning := range report.Warnings() {
+		h.logger.Warn(warning.Message, zap.String("field", warning.Field))
+	}
+	if report.HasErrors() {
+		return report.Err()
+	}
+	return nil
+}
+
+// ProvisionWith provisions the handler without a caddy.Context, running
+// containers with cm and logging to logger. Caddy uses Provision; this is
+// for tests, such as those using the serverlesstest package.
+func (h *Handler) ProvisionWith(cm ContainerManagerInterface, logger *zap.Logger) error {
+	h.containerManager = cm
+	h.logger = logger
+	return h.provision()
+}
+
+// provision sets up everything but the logger and container manager, which
+// must already be set.
+func (h *Handler) provision() error {
+	initServerlessMetrics()
 	h.routeMap = make(methodMap)
+	if h.generation == 0 {
+		h.generation = configGeneration(nil)
+	}
 
-	// Compile regex patterns for path matching and populate routeMap
+	if h.TimelineBufferSize <= 0 {
+		h.TimelineBufferSize = defaultTimelineBufferSize
+	}
+	h.timelines = newTimelineBuffer(h.TimelineBufferSize)
+	h.inflight = newInflightContainers()
+
+	// Invalid settings are reported by ValidationReport; they are skipped here
+	if budget, err := newResourceBudget(h.MaxContainers, h.MaxTotalMemory); err == nil {
+		h.budget = budget
+	}
+	if h.EventWebhook != "" && validWebhookURL(h.EventWebhook) {
+		h.events = newEventEmitter(h.EventWebhook, defaultEventQueueSize, h.logger)
+	}
+
+	// Populate routeMap. Path regexes are compiled on first use, as large
+	// configurations often serve most of their traffic from a few functions.
 	for i := range h.Functions {
 		fn := &h.Functions[i] // Use a pointer to modify the original slice element
 
-		if fn.Path != "" {
-			regex, err := regexp.Compile(fn.Path)
+		if h.DefaultFunctionConfig != nil {
+			merged, err := mergeFunctionConfig(*h.DefaultFunctionConfig, *fn)
+			if err != nil {
+				return fmt.Errorf("function %d: applying default_function_config: %v", i, err)
+			}
+			*fn = merged
+		}
+
+		fn.once = new(sync.Once)
+
+		if fn.InlineScript != "" && fn.inlineScript.Source == "" {
+			mount, err := writeInlineScript(fn.InlineScript)
 			if err != nil {
-				return fmt.Errorf("invalid path regex for function %d: %v", i, err)
+				return fmt.Errorf("function %d: writing inline_script: %v", i, err)
 			}
-			fn.pathRegex = regex
-		} else {
-			return fmt.Errorf("function %d: path is required", i)
+			fn.inlineScript = mount
 		}
 
 		// Set default port if not specified
 		if fn.Port == 0 {
 			fn.Port = 8080
 		}
+		if fn.ReadyPort == 0 {
+			fn.ReadyPort = fn.Port
+		}
+		if fn.Namespace == "" {
+			fn.Namespace = h.DefaultNamespace
+		}
+
+		if fn.LogConfig != nil {
+			if fn.LogConfig.MaxSize == "" {
+				fn.LogConfig.MaxSize = defaultLogMaxSize
+			}
+			if fn.LogConfig.MaxFile == 0 {
+				fn.LogConfig.MaxFile = defaultLogMaxFile
+			}
+		}
 
 		// Set default timeout if not specified
 		if fn.Timeout == 0 {
 			fn.Timeout = caddy.Duration(30 * time.Second)
 		}
 
-		// Validate required fields
-		if fn.Image == "" {
-			return fmt.Errorf("function %d: image is required", i)
+		if fn.ComposeFile != "" && h.composeManager == nil {
+			h.composeManager = NewComposeContainerManager(h.logger)
 		}
-		if len(fn.Methods) == 0 {
-			return fmt.Errorf("function %d: at least one method is required", i)
+		if fn.WebhookDedup != nil && fn.WebhookDedup.Window > 0 {
+			fn.dedup = newWebhookDedup(time.Duration(fn.WebhookDedup.Window))
 		}
 
+		transport := fn.Transport
+		if transport == nil {
+			transport = &TransportConfig{}
+		}
+		fn.httpClient = &http.Client{
+			Timeout:   defaultClientTimeout,
+			Transport: transport.newTransport(),
+		}
+
+		if fn.DebugBodies {
+			if fn.DebugBodyLimit == 0 {
+				fn.DebugBodyLimit = defaultDebugBodyLimit
+			}
+			fn.redactFields = compileRedactFields(fn.DebugRedact)
+		}
+
+		if filter, err := newIPFilter(fn.AllowIPs, fn.DenyIPs); err == nil {
+			fn.ipFilter = filter
+		}
+
+		if fn.ErrorLogger != "" {
+			fn.errorLog = h.logger.Named(fn.ErrorLogger)
+		}
+
+		hash, err := hashFunctionConfig(*fn, h.GlobalEnvironment)
+		if err != nil {
+			return fmt.Errorf("function %d: hashing configuration: %v", i, err)
+		}
+		fn.ConfigHash = hash
+
 		// Populate the routeMap
+		methods := make(map[string]bool, len(fn.Methods)+1)
 		for _, method := range fn.Methods {
-			upperMethod := strings.ToUpper(method)
-			if h.routeMap[upperMethod] == nil {
-				h.routeMap[upperMethod] = make(map[*regexp.Regexp]*FunctionConfig)
+			methods[strings.ToUpper(method)] = true
+		}
+		if !fn.proxiesOptions() {
+			methods[http.MethodOptions] = true
+		}
+		for method := range methods {
+			if h.routeMap[method] == nil {
+				h.routeMap[method] = newRouteTable()
 			}
-			h.routeMap[upperMethod][fn.pathRegex] = fn
+			h.routeMap[method].add(fn)
 		}
 	}
 
+	registerHandler(h)
+
 	return nil
 }
 
-// Validate ensures the configuration is valid.
+// Validate ensures the configuration is valid. It returns every error found
+// by ValidationReport at once rather than stopping at the first.
 func (h Handler) Validate() error {
+	return h.ValidationReport().Err()
+}
+
+// ValidationReport checks the whole configuration and returns all of the
+// errors and warnings found, in configuration order.
+func (h Handler) ValidationReport() ValidationErrors {
+	var report ValidationErrors
+	if h.NoMatchStatus != 0 && (h.NoMatchStatus < 100 || h.NoMatchStatus > 599) {
+		report.addError("no_match_status", "invalid status %d: must be between 100 and 599", h.NoMatchStatus)
+	}
+	if h.Debug {
+		report.addWarning("debug", "any client sending %s gets the container ID, image name and start timings in a response header", debugRequestHeader)
+	}
+
+	if h.BackendType != "" {
+		if _, ok := lookupBackend(h.BackendType); !ok {
+			report.addError("backend_type", "unknown backend '%s': registered backends are %v", h.BackendType, registeredBackends())
+		}
+		if h.UseSwarm {
+			report.addError("backend_type", "cannot be combined with use_swarm")
+		}
+	} else if len(h.BackendConfig) > 0 {
+		report.addError("backend_config", "requires backend_type")
+	}
+
+	switch h.DuplicateFunctions {
+	case "", "warn", "error":
+	default:
+		report.addError("duplicate_functions", "invalid value '%s': expected warn or error", h.DuplicateFunctions)
+	}
+
+	globalKeys := make([]string, 0, len(h.GlobalEnvironment))
+	for key := range h.GlobalEnvironment {
+		globalKeys = append(globalKeys, key)
+	}
+	sort.Strings(globalKeys)
+	for _, key := range globalKeys {
+		if !envVarNameRegex.MatchString(key) {
+			report.addError("global_environment", "invalid environment variable name '%s'", key)
+		}
+	}
+
+	if h.TimelineBufferSize < 0 {
+		report.addError("timeline_buffer_size", "cannot be negative")
+	}
+	if h.EventWebhook != "" && !validWebhookURL(h.EventWebhook) {
+		report.addError("event_webhook", "must be an absolute http(s) URL: %q", h.EventWebhook)
+	}
+	if h.MaxContainers < 0 {
+		report.addError("max_containers", "cannot be negative")
+	}
+	var maxTotalMemory int64
+	if h.MaxTotalMemory != "" {
+		var err error
+		if maxTotalMemory, err = parseMemorySize(h.MaxTotalMemory); err != nil {
+			report.addError("max_total_memory", "%v", err)
+		}
+	}
+
+	names := make(map[string]int)
+	routes := make(map[string]int)
 	for i, fn := range h.Functions {
+		field := func(name string) string {
+			return fmt.Sprintf("functions[%d].%s", i, name)
+		}
+
+		if fn.Name != "" {
+			if j, ok := names[fn.Name]; ok {
+				report.addError(field("name"), "%q is already used by function %d", fn.Name, j)
+			} else {
+				names[fn.Name] = i
+			}
+		}
+
+		// Paths are compiled on first use, so syntax errors are caught here
+		if fn.Path == "" {
+			report.addError(field("path"), "path is required")
+		} else if _, err := syntax.Parse(fn.Path, syntax.Perl); err != nil {
+			report.addError(field("path"), "invalid path regex: %v", err)
+		}
+		if fn.ComposeFile != "" {
+			if fn.ComposeService == "" {
+				report.addError(field("compose_service"), "compose_service is required with compose_file")
+			}
+		} else if fn.Image == "" && len(fn.Versions) == 0 {
+			report.addError(field("image"), "image is required")
+		}
+
+		// Functions routed like an earlier one never serve a request
+		for _, key := range routeKeys(fn) {
+			j, ok := routes[key]
+			if !ok {
+				routes[key] = i
+				continue
+			}
+			method, _, _ := strings.Cut(key, " ")
+			if h.DuplicateFunctions == "error" {
+				report.addError(field("path"), "%s %s is already handled by function %d", method, fn.Path, j)
+			} else {
+				report.addWarning(field("path"), "%s %s is already handled by function %d, so this function never serves it", method, fn.Path, j)
+			}
+		}
+
 		// Validate methods
+		if len(fn.Methods) == 0 {
+			report.addError(field("methods"), "at least one method is required")
+		}
 		for _, method := range fn.Methods {
 			switch strings.ToUpper(method) {
 			case "GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS":
 				// Valid methods
 			default:
-				return fmt.Errorf("function %d: invalid HTTP method '%s'", i, method)
+				report.addError(field("methods"), "invalid HTTP method '%s'", method)
+			}
+		}
+		for _, mediaType := range fn.MatchContentType {
+			if mediaType != "*" && !strings.Contains(mediaType, "/") {
+				report.addError(field("match_content_type"), "invalid media type '%s'", mediaType)
+			}
+		}
+
+		if len(fn.Versions) > 0 {
+			if fn.Image != "" {
+				report.addError(field("versions"), "cannot be combined with image")
+			}
+			if fn.ComposeFile != "" {
+				report.addError(field("versions"), "cannot be combined with compose_file")
+			}
+			for j, version := range fn.Versions {
+				if version.Image == "" {
+					report.addError(field(fmt.Sprintf("versions[%d].image", j)), "image is required")
+				}
+				if version.Weight <= 0 {
+					report.addError(field(fmt.Sprintf("versions[%d].weight", j)), "must be positive")
+				}
 			}
 		}
 
+		if fn.InlineScript != "" && len(fn.Command) > 0 {
+			report.addError(field("inline_script"), "cannot be combined with command")
+		}
+		if fn.InlineScript != "" && h.UseSwarm && fn.ComposeFile == "" {
+			report.addError(field("inline_script"), "not supported for swarm services, whose tasks may run on a node without the script file written on Caddy's host")
+		}
+
+		if fn.MaxBodySize < 0 {
+			report.addError(field("max_body_size"), "cannot be negative")
+		}
+		if fn.PrebufferRequest && fn.MaxBodySize == 0 {
+			report.addError(field("prebuffer_request"), "prebuffer_request requires max_body_size")
+		}
+		if fn.WebhookDedup != nil {
+			if fn.WebhookDedup.Header == "" {
+				report.addError(field("webhook_dedup.header"), "header is required")
+			}
+			if fn.WebhookDedup.Window <= 0 {
+				report.addError(field("webhook_dedup.window"), "must be positive")
+			}
+		}
+		if fn.Transport != nil {
+			if err := fn.Transport.validate(); err != nil {
+				report.addError(field("transport"), "%v", err)
+			}
+		}
+		if _, err := parsePrefixes(fn.AllowIPs); err != nil {
+			report.addError(field("allow_ips"), "%v", err)
+		}
+		if _, err := parsePrefixes(fn.DenyIPs); err != nil {
+			report.addError(field("deny_ips"), "%v", err)
+		}
+		if fn.DebugBodyLimit < 0 {
+			report.addError(field("debug_body_limit"), "cannot be negative")
+		}
+		if fn.PostStartTimeout < 0 {
+			report.addError(field("post_start_timeout"), "cannot be negative")
+		}
+		if fn.WarmupDelay < 0 {
+			report.addError(field("warmup_delay"), "cannot be negative")
+		}
+		if fn.MountLocaltime {
+			if fn.Timezone == "" {
+				report.addError(field("mount_localtime"), "mount_localtime requires timezone")
+			} else if _, err := os.Stat(zoneinfoPath(fn.Timezone)); err != nil {
+				report.addError(field("timezone"), "timezone %q not found on host: %v", fn.Timezone, err)
+			}
+		}
+		if fn.SeccompProfile != "" && fn.SeccompProfile != seccompUnconfined {
+			if info, err := os.Stat(fn.SeccompProfile); err != nil {
+				report.addError(field("seccomp_profile"), "%v", err)
+			} else if !info.Mode().IsRegular() {
+				report.addError(field("seccomp_profile"), "%s is not a regular file", fn.SeccompProfile)
+			}
+		}
+
+		if fn.MaxEnvValueLength < 0 || fn.MaxEnvSize < 0 {
+			report.addError(field("environment"), "environment size limits cannot be negative")
+		}
+
+		if fn.ReadyPort < 0 || fn.ReadyPort > 65535 {
+			report.addError(field("ready_port"), "must be between 1 and 65535")
+		}
+		if fn.Namespace != "" && !namespaceRegex.MatchString(fn.Namespace) {
+			report.addError(field("namespace"), "invalid namespace '%s'", fn.Namespace)
+		}
+		if fn.ReadyMaxAttempts < 0 {
+			report.addError(field("ready_max_attempts"), "cannot be negative")
+		}
+		if fn.ReadyFailureRetries < 0 {
+			report.addError(field("ready_failure_retries"), "cannot be negative")
+		}
+		if fn.ErrorLogger != "" && !loggerNameRegex.MatchString(fn.ErrorLogger) {
+			report.addError(field("error_logger"), "invalid logger name '%s'", fn.ErrorLogger)
+		}
+		if fn.MaxHeaderBytes < 0 {
+			report.addError(field("max_header_bytes"), "cannot be negative")
+		}
+		if fn.TimingHeader != "" && !headerNameRegex.MatchString(fn.TimingHeader) {
+			report.addError(field("timing_header"), "invalid header name '%s'", fn.TimingHeader)
+		}
+
 		// Validate volume mounts
 		for j, vol := range fn.Volumes {
-			if vol.Source == "" {
-				return fmt.Errorf("function %d, volume %d: source path is required", i, j)
+			volume := field(fmt.Sprintf("volumes[%d]", j))
+			switch {
+			case vol.Source == "":
+				report.addError(volume+".source", "source path is required")
+			case !filepath.IsAbs(vol.Source):
+				report.addError(volume+".source", "source path must be absolute")
+			}
+			switch {
+			case vol.Target == "":
+				report.addError(volume+".target", "target path is required")
+			case !filepath.IsAbs(vol.Target):
+				report.addError(volume+".target", "target path must be absolute")
+			}
+			if vol.Propagation != "" && !mountPropagations[vol.Propagation] {
+				report.addError(volume+".propagation", "invalid mount propagation '%s': expected private, rprivate, shared, rshared, slave or rslave", vol.Propagation)
+			}
+		}
+
+		if fn.RequestScratch != "" {
+			if !filepath.IsAbs(fn.RequestScratch) {
+				report.addError(field("request_scratch"), "path must be absolute")
 			}
-			if vol.Target == "" {
-				return fmt.Errorf("function %d, volume %d: target path is required", i, j)
+			if h.UseSwarm && fn.ComposeFile == "" {
+				report.addError(field("request_scratch"), "not supported for swarm services, whose tasks may run on a node without the scratch directory created on Caddy's host")
 			}
-			if !filepath.IsAbs(vol.Source) {
-				return fmt.Errorf("function %d, volume %d: source path must be absolute", i, j)
+			for _, vol := range fn.Volumes {
+				if filepath.Clean(vol.Target) == filepath.Clean(fn.RequestScratch) {
+					report.addError(field("request_scratch"), "path %s is already a volume target", fn.RequestScratch)
+				}
 			}
-			if !filepath.IsAbs(vol.Target) {
-				return fmt.Errorf("function %d, volume %d: target path must be absolute", i, j)
+		}
+
+		// Validate fallback response
+		if fb := fn.FallbackResponse; fb != nil && fb.StatusCode != 0 && (fb.StatusCode < 100 || fb.StatusCode > 599) {
+			report.addError(field("fallback_response.status_code"), "invalid status %d: must be between 100 and 599", fb.StatusCode)
+		}
+
+		// Validate isolation mode
+		switch fn.Isolation {
+		case "", IsolationShared, IsolationPerRequest:
+		default:
+			report.addError(field("isolation"), "invalid isolation '%s' (expected %q or %q)", fn.Isolation, IsolationShared, IsolationPerRequest)
+		}
+
+		// Validate memory limits
+		if fn.Memory != "" && !memorySizeRegex.MatchString(fn.Memory) {
+			report.addError(field("memory"), "invalid memory limit '%s'", fn.Memory)
+		}
+		if maxTotalMemory > 0 {
+			if fn.ComposeFile != "" {
+				report.addError(field("compose_file"), "cannot be combined with the handler's max_total_memory, since compose projects have no memory limit to count toward it")
+			} else if fn.Memory == "" {
+				report.addError(field("memory"), "required when the handler sets max_total_memory, so that every container is counted toward it")
+			} else if memory, err := parseMemorySize(fn.Memory); err == nil && memory > maxTotalMemory {
+				report.addError(field("memory"), "%s exceeds the handler's max_total_memory %s, so no container can start", fn.Memory, h.MaxTotalMemory)
 			}
 		}
+		if fn.MemorySwap != "" {
+			if fn.MemorySwap != "-1" && !memorySizeRegex.MatchString(fn.MemorySwap) {
+				report.addError(field("memory_swap"), "invalid memory_swap limit '%s'", fn.MemorySwap)
+			}
+			if fn.Memory == "" {
+				report.addError(field("memory_swap"), "memory_swap requires memory to be set")
+			}
+		}
+		if fn.LogConfig != nil {
+			if fn.LogConfig.MaxSize != "" && !memorySizeRegex.MatchString(fn.LogConfig.MaxSize) {
+				report.addError(field("log_config.max_size"), "invalid size '%s'", fn.LogConfig.MaxSize)
+			}
+			if fn.LogConfig.MaxFile < 0 {
+				report.addError(field("log_config.max_file"), "cannot be negative")
+			}
+			if !rotatesLogs(fn.LogDriver) {
+				report.addError(field("log_config"), "log rotation requires the json-file or local log driver, not '%s'", fn.LogDriver)
+			}
+		}
+		if fn.LogDriver != "" && !logDrivers[fn.LogDriver] {
+			report.addError(field("log_driver"), "unknown log driver '%s'", fn.LogDriver)
+		}
+		if fn.OOMKillDisable && fn.Memory == "" {
+			report.addError(field("oom_kill_disable"), "oom_kill_disable requires memory to be set")
+		}
+		if fn.OOMScoreAdj < minOOMScoreAdj || fn.OOMScoreAdj > maxOOMScoreAdj {
+			report.addError(field("oom_score_adj"), "must be between %d and %d", minOOMScoreAdj, maxOOMScoreAdj)
+		}
+		if fn.CgroupParent != "" && !cgroupParentRegex.MatchString(fn.CgroupParent) {
+			report.addError(field("cgroup_parent"), "invalid cgroup parent '%s': expected an absolute path or a systemd slice", fn.CgroupParent)
+		}
+		if fn.IPCMode != "" && !validIPCMode(fn.IPCMode) {
+			report.addError(field("ipc_mode"), "invalid IPC mode '%s': expected none, private, shareable, host or container:<name>", fn.IPCMode)
+		}
+		switch fn.PIDMode {
+		case "", "private", "host":
+		default:
+			report.addError(field("pid_mode"), "invalid PID mode '%s': expected private or host", fn.PIDMode)
+		}
+		switch fn.UsernsMode {
+		case "", "host", "keep-id":
+		default:
+			report.addError(field("userns_mode"), "invalid user namespace mode '%s': expected host or keep-id", fn.UsernsMode)
+		}
+		for j, group := range fn.GroupAdd {
+			if !groupRegex.MatchString(group) {
+				report.addError(field(fmt.Sprintf("group_add[%d]", j)), "invalid group '%s': expected a group name or GID", group)
+			}
+		}
+		if fn.Privileged && !h.AllowPrivileged {
+			report.addError(field("privileged"), "privileged containers require allow_privileged on the handler")
+		}
+		if fn.ComposeFile != "" {
+			for _, name := range composeUnsupportedOptions(fn) {
+				report.addError(field(name), "not supported for compose functions; set it in the compose file")
+			}
+			if len(h.GlobalEnvironment) > 0 {
+				report.addWarning(field("compose_file"), "global_environment is not passed to compose services, so they only see the environment set in the compose file")
+			}
+		} else if h.UseSwarm {
+			for _, name := range swarmUnsupportedOptions(fn) {
+				report.addError(field(name), "not supported for swarm services")
+			}
+		}
+
+		// Validate status mappings in order so that the report is deterministic
+		from := make([]int, 0, len(fn.StatusMap))
+		for code := range fn.StatusMap {
+			from = append(from, code)
+		}
+		sort.Ints(from)
+		for _, code := range from {
+			if to := fn.StatusMap[code]; code < 100 || code > 599 || to < 100 || to > 599 {
+				report.addError(field("status_map"), "invalid entry %d -> %d: codes must be between 100 and 599", code, to)
+			}
+		}
+
+		// Validate file mounts
+		for j, m := range fn.FileMounts {
+			if err := m.validate(); err != nil {
+				report.addError(field(fmt.Sprintf("file_mounts[%d]", j)), "%v", err)
+			}
+		}
+
+		// Settings that are accepted but may not do what was meant
+		if fn.DebugBodies {
+			report.addWarning(field("debug_bodies"), "request and response bodies are logged, so credentials and personal data they carry end up in the logs")
+		}
+		if len(fn.PostStartCommand) > 0 && h.UseSwarm && fn.ComposeFile == "" {
+			report.addWarning(field("post_start_command"), "post-start commands are not supported for swarm services and will be skipped")
+		}
+		inherited := append([]string(nil), fn.InheritEnv...)
+		sort.Strings(inherited)
+		for _, key := range inherited {
+			if _, exists := fn.Environment[key]; exists {
+				report.addWarning(field("inherit_env"), "inherited environment variable %s overrides static value", key)
+			}
+		}
+		if fn.InheritAllEnv {
+			report.addWarning(field("inherit_all_env"), "function inherits the entire host environment, including any secrets held in Caddy's environment variables")
+		}
+		if fn.IPCMode == "host" {
+			report.addWarning(field("ipc_mode"), "container shares the host's IPC namespace, so it can read and write the shared memory of host processes")
+		}
+		if fn.PIDMode == "host" {
+			report.addWarning(field("pid_mode"), "container shares the host's PID namespace, so it can see host processes' command lines and signal them, including Caddy")
+		}
+		if fn.UsernsMode == "host" {
+			report.addWarning(field("userns_mode"), "user namespace remapping is disabled, so root in the container is root on the host")
+		}
+		if fn.Privileged && h.AllowPrivileged {
+			report.addWarning(field("privileged"), "SECURITY: container runs privileged, with every capability and the host's devices, which amounts to root on the host; do not use unless the function cannot work otherwise")
+		}
+		if literal, _, ok := literalPath(fn.Path); ok && h.CaseInsensitivePaths && strings.ToLower(literal) != literal {
+			report.addWarning(field("path"), "path %s has upper case letters and never matches with case_insensitive_paths", fn.Path)
+		}
+		if len(fn.PlacementConstraints) > 0 && !h.UseSwarm {
+			report.addWarning(field("placement_constraints"), "placement constraints are ignored unless use_swarm is enabled")
+		}
 	}
 
-	return nil
+	return report
 }
 
 // ServeHTTP implements the HTTP handler interface.
@@ -184,39 +1125,241 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 	// Find matching function
 	function := h.findMatchingFunction(r)
 	if function == nil {
+		// The path is ours but the method is not
+		if h.MethodNotAllowed {
+			if allowed := h.allowedMethods(r); len(allowed) > 0 {
+				return writeMethodNotAllowed(w, allowed)
+			}
+		}
+		// The method is ours but the path is unknown; answer directly if configured
+		if h.NoMatchStatus != 0 && h.handlesMethod(r.Method) {
+			return h.writeNoMatch(w)
+		}
 		// No matching function, pass to next handler
 		return next.ServeHTTP(w, r)
 	}
+	if err := function.ensureCompiled(); err != nil {
+		h.errorLogFor(function).Error("invalid path regex", zap.String("path", function.Path), zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("invalid path regex: %v", err))
+	}
+
+	if strings.ToUpper(r.Method) == http.MethodOptions && !function.proxiesOptions() {
+		return writeAllow(w, function)
+	}
 
 	h.logger.Debug("executing serverless function",
 		zap.String("method", r.Method),
 		zap.String("path", r.URL.Path),
 		zap.String("image", function.Image))
 
+	// Acknowledge repeated webhook deliveries without running the function
+	var deliveryID string
+	var delivery *statusRecorder
+	if function.dedup != nil {
+		deliveryID = r.Header.Get(function.WebhookDedup.Header)
+	}
+	if deliveryID != "" {
+		switch function.dedup.begin(deliveryID, time.Now()) {
+		case deliveryDuplicate:
+			h.logger.Info("acknowledging duplicate webhook delivery",
+				zap.String("path", r.URL.Path),
+				zap.String("delivery_id", deliveryID))
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case deliveryInProgress:
+			// Not acknowledged, as the earlier attempt may still fail
+			return caddyhttp.Error(http.StatusConflict, fmt.Errorf("webhook delivery %s is still being processed", deliveryID))
+		}
+		delivery = &statusRecorder{ResponseWriter: w}
+		w = delivery
+	}
+
 	// Execute the function
-	return h.executeFunction(w, r, function)
+	err := h.executeFunction(w, r, function)
+	if delivery != nil {
+		// Container errors are returned as 5xx responses rather than errors
+		function.dedup.finish(deliveryID, time.Now(), err == nil && delivery.status < http.StatusInternalServerError)
+	}
+	// Other errors occur after the container's status was written and recorded
+	if herr, ok := err.(caddyhttp.HandlerError); ok {
+		observeResponse(function, herr.StatusCode)
+	}
+	return err
 }
 
-// findMatchingFunction finds the first function that matches the request
+// findMatchingFunction finds the function that matches the request; see routeTable.match
 func (h *Handler) findMatchingFunction(r *http.Request) *FunctionConfig {
 	requestMethod := strings.ToUpper(r.Method)
-	pathMap, methodExists := h.routeMap[requestMethod]
+	routes, methodExists := h.routeMap[requestMethod]
 
 	if !methodExists {
 		return nil
 	}
 
-	for pathRegex, function := range pathMap {
-		if pathRegex != nil && pathRegex.MatchString(r.URL.Path) {
-			return function
+	return routes.match(h.normalizePath(r.URL.Path), r.Header.Get("Content-Type"))
+}
+
+// ensureCompiled compiles the function's path regex once and returns the
+// compilation error, if any. Functions that were not provisioned keep the
+// pathRegex they were given.
+func (fn *FunctionConfig) ensureCompiled() error {
+	if fn.once == nil {
+		return nil
+	}
+	fn.once.Do(func() {
+		if fn.pathRegex == nil {
+			fn.pathRegex, fn.compileErr = regexp.Compile(fn.Path)
+		}
+	})
+	return fn.compileErr
+}
+
+// handlesMethod reports whether any function is configured for the given method
+func (h *Handler) handlesMethod(method string) bool {
+	_, ok := h.routeMap[strings.ToUpper(method)]
+	return ok
+}
+
+// writeNoMatch writes the configured response for requests whose method is
+// served by this handler but whose path matches no function
+func (h *Handler) writeNoMatch(w http.ResponseWriter) error {
+	body := h.NoMatchBody
+	if body == "" {
+		w.Header().Set("Content-Type", "application/json")
+		body = fmt.Sprintf(`{"error":%q}`, "no function matches the requested path")
+	}
+	w.WriteHeader(h.NoMatchStatus)
+	_, err := io.WriteString(w, body)
+	return err
+}
+
+// allowedMethods returns, sorted, the methods of the functions matching the
+// request's path and content type
+func (h *Handler) allowedMethods(r *http.Request) []string {
+	path := h.normalizePath(r.URL.Path)
+	contentType := r.Header.Get("Content-Type")
+	var allowed []string
+	for method, routes := range h.routeMap {
+		if routes.match(path, contentType) != nil {
+			allowed = append(allowed, method)
 		}
 	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// writeMethodNotAllowed answers a request for a known path with a method no
+// function serves on it
+func writeMethodNotAllowed(w http.ResponseWriter, allowed []string) error {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	_, err := fmt.Fprintf(w, `{"error":%q}`, "method not allowed")
+	return err
+}
 
+// proxiesOptions reports whether OPTIONS requests are sent to the container
+func (fn *FunctionConfig) proxiesOptions() bool {
+	return fn.AutoOptions == nil || *fn.AutoOptions
+}
+
+// writeAllow answers an OPTIONS request with the function's methods
+func writeAllow(w http.ResponseWriter, function *FunctionConfig) error {
+	var allow []string
+	for _, method := range function.Methods {
+		if upper := strings.ToUpper(method); upper != http.MethodOptions {
+			allow = append(allow, upper)
+		}
+	}
+	allow = append(allow, http.MethodOptions)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
+// errorLogFor returns the logger for the function's errors
+func (h *Handler) errorLogFor(function *FunctionConfig) *zap.Logger {
+	if function.errorLog != nil {
+		return function.errorLog
+	}
+	return h.logger
+}
+
+// readinessPort returns the port probed for the container's readiness. The
+// serving port is probed where the host reaches it, container.Port, which
+// differs from Port when the container publishes it on another port.
+func readinessPort(function *FunctionConfig, container *Container) int {
+	if function.ReadyPort == function.Port && container.Port != 0 {
+		return container.Port
+	}
+	return function.ReadyPort
+}
+
+// headerSize returns the size of header as sent over HTTP/1.1, counting
+// "Name: value\r\n" for each value
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
+
 // executeFunction executes a serverless function in a Docker container
 func (h *Handler) executeFunction(w http.ResponseWriter, r *http.Request, function *FunctionConfig) error {
+	// Refuse disallowed clients before any container work
+	if err := function.ipFilter.checkClient(r); err != nil {
+		return caddyhttp.Error(http.StatusForbidden, err)
+	}
+
+	// Backends commonly cap headers at 8 KiB and fail opaquely beyond that,
+	// so refuse oversized headers before any container work as well
+	if function.MaxHeaderBytes > 0 {
+		if size := headerSize(r.Header); size > function.MaxHeaderBytes {
+			return caddyhttp.Error(http.StatusRequestHeaderFieldsTooLarge,
+				fmt.Errorf("request headers of %d bytes exceed limit of %d bytes", size, function.MaxHeaderBytes))
+		}
+	}
+
+	// Run one of the function's versions, recorded and reported with its image
+	function = function.pickVersion()
+
+	timeline := newTimeline(r, function)
+	defer h.timelines.add(timeline)
+
+	if function.MaxBodySize > 0 {
+		// Reject declared oversized bodies up front to avoid a wasted cold start
+		if r.ContentLength > function.MaxBodySize {
+			return caddyhttp.Error(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body of %d bytes exceeds limit of %d bytes", r.ContentLength, function.MaxBodySize))
+		}
+		// Bodies without a declared length are limited while streaming
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, function.MaxBodySize)
+		}
+	}
+
+	// Read the body before the cold start so the container isn't left
+	// waiting on a slow uploader
+	if function.PrebufferRequest && r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return caddyhttp.Error(http.StatusRequestEntityTooLarge, err)
+			}
+			return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("reading request body: %v", err))
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		// Allow the body to be sent again if the container refuses it
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(function.Timeout))
 	defer cancel()
 
@@ -224,57 +1367,329 @@ func (h *Handler) executeFunction(w http.ResponseWriter, r *http.Request, functi
 	// operations are not affected by request context cancellation or timeout
 	lifecycleCtx := context.Background()
 
+	// Refuse to start the container if any mounted file has changed
+	volumes, err := containerVolumes(function)
+	if err != nil {
+		h.errorLogFor(function).Error("file mount verification failed", zap.Error(err))
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	// Give the request its own scratch directory, removed after the
+	// container stops since this defer runs after stopContainer's
+	if function.RequestScratch != "" {
+		var scratch string
+		scratch, volumes, err = createRequestScratch(function, volumes)
+		if err != nil {
+			h.errorLogFor(function).Error("failed to create scratch directory", zap.Error(err))
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		defer func() {
+			if err := os.RemoveAll(scratch); err != nil {
+				h.logger.Warn("failed to remove scratch directory", zap.String("path", scratch), zap.Error(err))
+			}
+		}()
+	}
+
 	// Prepare container configuration
 	config := ContainerConfig{
 		Image:       function.Image,
-		Command:     function.Command,
-		Environment: function.Environment,
-		Volumes:     function.Volumes,
+		Command:     containerCommand(function),
+		AppendArgs:  function.AppendArgs,
+		Environment: containerEnvironment(h.GlobalEnvironment, function),
+		Volumes:     volumes,
 		Port:        function.Port,
+
+		Namespace:    function.Namespace,
+		FunctionPath: function.Path,
+
+		PlacementConstraints: function.PlacementConstraints,
+		PortCheckEnabled:     !function.DisablePortCheck,
+		Memory:               function.Memory,
+		MemorySwap:           function.MemorySwap,
+		OOMKillDisable:       function.OOMKillDisable,
+		OOMScoreAdj:          function.OOMScoreAdj,
+		CgroupParent:         function.CgroupParent,
+		IPCMode:              function.IPCMode,
+		PIDMode:              function.PIDMode,
+		UsernsMode:           function.UsernsMode,
+		GroupAdd:             function.GroupAdd,
+		Privileged:           function.Privileged,
+		SeccompProfile:       function.SeccompProfile,
+		LogConfig:            function.LogConfig,
+		LogDriver:            function.LogDriver,
+		LogOpts:              function.LogOpts,
+		MaxEnvValueLength:    function.MaxEnvValueLength,
+		MaxEnvSize:           function.MaxEnvSize,
+		ComposeFile:          function.ComposeFile,
+		ComposeService:       function.ComposeService,
 	}
+	containerManager := h.managerFor(function)
 
-	// Start container
-	container, err := h.containerManager.StartContainer(ctx, config)
-	if err != nil {
-		h.logger.Error("failed to start container",
-			zap.Error(err),
-			zap.String("image", config.Image),
-			zap.Int("port", config.Port),
-			zap.Duration("timeout", time.Duration(function.Timeout)))
-		return caddyhttp.Error(http.StatusInternalServerError, err)
+	// Refuse to start the container if the handler's budget is used up. The
+	// reservation covers the containers replacing one that is not ready.
+	var memory int64
+	if function.Memory != "" {
+		memory, _ = parseMemorySize(function.Memory)
 	}
+	if err := h.budget.reserve(memory); err != nil {
+		h.errorLogFor(function).Warn("rejecting request over the resource budget", zap.Error(err))
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+	defer h.budget.release(memory)
 
-	// Ensure container cleanup using lifecycle context to prevent cleanup failures
+	// Stop containers using lifecycle context to prevent cleanup failures
 	// due to request context cancellation or timeout
-	defer func() {
-		if err := h.containerManager.StopContainer(lifecycleCtx, container.ID); err != nil {
-			h.logger.Error("failed to stop container", zap.String("container_id", container.ID), zap.Error(err))
+	stopContainer := func(container *Container) {
+		timeline.ContainerStopCalled = timestamp()
+		h.inflight.remove(container.ID)
+		if err := containerManager.StopContainer(lifecycleCtx, container.ID); err != nil {
+			h.errorLogFor(function).Error("failed to stop container", zap.String("container_id", container.ID), zap.Error(err))
 		}
-	}()
+		observeContainerAge(function, container)
+		h.events.emit(eventContainerStopped, function, container.ID, nil)
+	}
 
-	// Wait for container to be ready
-	if err := h.containerManager.WaitForReady(ctx, container, time.Duration(function.Timeout), function.Port); err != nil {
-		h.logger.Error("container failed to become ready", zap.Error(err))
-		return caddyhttp.Error(http.StatusInternalServerError, err)
+	// Start a container, replacing it with a fresh one while it fails its
+	// readiness check and retries remain
+	var container *Container
+	timeline.ContainerStartCalled = timestamp()
+	for attempt := 0; ; attempt++ {
+		container, err = containerManager.StartContainer(ctx, config)
+		if err != nil {
+			h.errorLogFor(function).Error("failed to start container",
+				zap.Error(err),
+				zap.String("image", config.Image),
+				zap.Int("port", config.Port),
+				zap.Duration("timeout", time.Duration(function.Timeout)))
+			h.events.emit(eventContainerFailed, function, "", err)
+			if function.FallbackResponse != nil {
+				return h.writeFallback(w, function)
+			}
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		timeline.ContainerStarted = timestamp()
+		h.events.emit(eventContainerStarted, function, container.ID, nil)
+		observeContainerStart(function)
+		h.inflight.add(container, function, containerManager)
+
+		err = containerManager.WaitForReady(ctx, container, time.Duration(function.Timeout), readinessPort(function, container), function.ReadyMaxAttempts)
+		if err == nil {
+			break
+		}
+		h.errorLogFor(function).Error("container failed to become ready", zap.String("container_id", container.ID), zap.Error(err))
+		h.events.emit(eventContainerFailed, function, container.ID, err)
+		stopContainer(container)
+		if attempt >= function.ReadyFailureRetries || ctx.Err() != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		h.logger.Info("replacing container that failed to become ready",
+			zap.String("path", function.Path),
+			zap.Int("retry", attempt+1),
+			zap.Int("max_retries", function.ReadyFailureRetries))
+	}
+	defer stopContainer(container)
+	timeline.ReadyCheckPassed = timestamp()
+	observeColdStart(function, timeline.ReadyCheckPassed.Sub(*timeline.ContainerStartCalled))
+
+	if len(function.PostStartCommand) > 0 {
+		h.runPostStart(ctx, containerManager, container, function)
+	}
+
+	// Containers are never reused, so every container gets the delay
+	if err := sleepContext(ctx, time.Duration(function.WarmupDelay)); err != nil {
+		return caddyhttp.Error(http.StatusGatewayTimeout, fmt.Errorf("waiting for container warmup: %v", err))
+	}
+
+	if h.Debug && r.Header.Get(debugRequestHeader) == "1" {
+		setDebugInfo(w, function, container, timeline)
+	}
+
+	// Proxy request to container, failing early if it exits while serving
+	proxyCtx, cancelProxy := context.WithCancelCause(r.Context())
+	go h.watchContainerExit(proxyCtx, cancelProxy, containerManager, container, function)
+
+	timeline.ProxyStarted = timestamp()
+	err = h.proxyToContainer(w, r.WithContext(proxyCtx), container, function)
+	timeline.ProxyCompleted = timestamp()
+	cancelProxy(nil)
+	if err != nil && errors.Is(r.Context().Err(), context.Canceled) {
+		h.logger.Info("client disconnected",
+			zap.String("event", "client_disconnected"),
+			zap.String("path", function.Path),
+			zap.String("container_id", container.ID),
+			zap.Duration("elapsed", timeline.ProxyCompleted.Sub(*timeline.ProxyStarted)))
+		return caddyhttp.Error(statusClientClosedRequest, errClientDisconnected)
+	}
+	return err
+}
+
+// statusClientClosedRequest is the non-standard status recorded for requests
+// whose client went away, as used by nginx and Caddy's reverse proxy
+const statusClientClosedRequest = 499
+
+// errClientDisconnected is returned when the client disconnects while the
+// request is being proxied. The container is stopped as usual.
+var errClientDisconnected = errors.New("client disconnected")
+
+// managerFor returns the container manager responsible for running the function
+func (h *Handler) managerFor(function *FunctionConfig) ContainerManagerInterface {
+	if function.ComposeFile != "" && h.composeManager != nil {
+		return h.composeManager
+	}
+	return h.containerManager
+}
+
+// writeFallback serves the function's static fallback response
+func (h *Handler) writeFallback(w http.ResponseWriter, function *FunctionConfig) error {
+	fallback := function.FallbackResponse
+	for name, values := range fallback.Headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	status := fallback.StatusCode
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	observeResponse(function, status)
+	_, err := io.WriteString(w, fallback.Body)
+	return err
+}
+
+// runPostStart runs the function's post-start command in the container.
+// Failures are only logged, since the container is already serving.
+func (h *Handler) runPostStart(ctx context.Context, manager ContainerManagerInterface, container *Container, function *FunctionConfig) {
+	if function.PostStartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(function.PostStartTimeout))
+		defer cancel()
+	}
+
+	stdout, stderr, exitCode, err := manager.ExecInContainer(ctx, container.ID, function.PostStartCommand)
+	switch {
+	case errors.Is(err, errExecNotSupported):
+		h.logger.Warn("container manager does not support post-start commands",
+			zap.String("container_id", container.ID))
+	case err != nil:
+		h.logger.Warn("post-start command failed",
+			zap.String("container_id", container.ID),
+			zap.Strings("command", function.PostStartCommand),
+			zap.Error(err))
+	case exitCode != 0:
+		h.logger.Warn("post-start command failed",
+			zap.String("container_id", container.ID),
+			zap.Strings("command", function.PostStartCommand),
+			zap.Int("exit_code", exitCode),
+			zap.String("stdout", stdout),
+			zap.String("stderr", stderr))
+	default:
+		h.logger.Debug("post-start command finished",
+			zap.String("container_id", container.ID),
+			zap.Strings("command", function.PostStartCommand),
+			zap.String("stdout", stdout))
+	}
+}
+
+// sleepContext waits for d, or returns ctx's error if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// zoneinfoPath returns the host's zoneinfo file for the given timezone.
+func zoneinfoPath(timezone string) string {
+	return filepath.Join("/usr/share/zoneinfo", filepath.Clean("/"+timezone))
+}
+
+// containerCommand returns the command run in the function's container: its
+// inline script if it has one, or else its Command
+func containerCommand(function *FunctionConfig) []string {
+	if function.InlineScript != "" {
+		return []string{"/bin/sh", function.inlineScript.Target}
+	}
+	return function.Command
+}
+
+// containerEnvironment builds the environment passed to a function's container,
+// merging the function's variables over the global ones and inherited host
+// variables over both. Neither map passed in is modified.
+func containerEnvironment(global map[string]string, function *FunctionConfig) map[string]string {
+	env := make(map[string]string, len(global)+len(function.Environment))
+	for key, value := range global {
+		env[key] = value
+	}
+	for key, value := range function.Environment {
+		env[key] = value
+	}
+
+	if function.InheritAllEnv {
+		for _, kv := range os.Environ() {
+			if key, value, ok := strings.Cut(kv, "="); ok && key != "" {
+				env[key] = value
+			}
+		}
+	}
+	for _, key := range function.InheritEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			env[key] = value
+		}
+	}
+
+	if function.Timezone != "" {
+		env["TZ"] = function.Timezone
+	}
+	if function.Locale != "" {
+		env["LANG"] = function.Locale
+		env["LC_ALL"] = function.Locale
 	}
 
-	// Proxy request to container
-	return h.proxyToContainer(w, r, container, function.Port)
+	return env
 }
 
 // proxyToContainer proxies the HTTP request to the running container
-func (h *Handler) proxyToContainer(w http.ResponseWriter, r *http.Request, container *Container, internalAppPort int) error {
+func (h *Handler) proxyToContainer(w http.ResponseWriter, r *http.Request, container *Container, function *FunctionConfig) error {
 	// Create request to container
-	// Use container.IP (internal IP) and internalAppPort (the port the app inside the container listens on)
-	containerURL := fmt.Sprintf("http://%s:%d%s", container.IP, internalAppPort, r.URL.Path)
+	// Use container.IP and the port reported by the manager, falling back to
+	// function.Port (the port the app inside the container listens on)
+	port := container.Port
+	if port == 0 {
+		port = function.Port
+	}
+	// Keep the path as the client encoded it, so that an escaped slash
+	// such as %2F is not turned into a path separator
+	containerURL := fmt.Sprintf("http://%s:%d%s", container.IP, port, r.URL.EscapedPath())
 	if r.URL.RawQuery != "" {
 		containerURL += "?" + r.URL.RawQuery
 	}
 
-	req, err := http.NewRequestWithContext(r.Context(), r.Method, containerURL, r.Body)
+	// Capture bodies for debug logging as they are streamed
+	var reqBody, respBody *bodyCapture
+	body := r.Body
+	if function.DebugBodies {
+		reqBody = newBodyCapture(function.DebugBodyLimit)
+		respBody = newBodyCapture(function.DebugBodyLimit)
+		if body != nil && body != http.NoBody {
+			body = teeBody(body, reqBody)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, containerURL, body)
 	if err != nil {
 		return caddyhttp.Error(http.StatusInternalServerError, err)
 	}
+	req.ContentLength = r.ContentLength
+	req.GetBody = r.GetBody
+	req.Trailer = r.Trailer
 
 	// Copy headers
 	for name, values := range r.Header {
@@ -282,11 +1697,42 @@ func (h *Handler) proxyToContainer(w http.ResponseWriter, r *http.Request, conta
 			req.Header.Add(name, value)
 		}
 	}
+	if function.UserAgent != "" {
+		req.Header.Set("User-Agent", function.UserAgent)
+	}
 
-	// Make request to container
-	resp, err := h.HTTPClient.Do(req)
+	// Make request to container. The container was just started for this
+	// request, so its app may still be setting up its listener even though
+	// the readiness check connected; retry once if it refuses the request,
+	// or resets the connection of a request that is safe to resend.
+	client := h.clientFor(function)
+	timing := newUpstreamTiming(function)
+	resp, err := client.Do(timing.trace(req))
+	if err != nil && retryableFirstRequestError(err, req) {
+		h.logger.Debug("container refused first request, retrying",
+			zap.String("container_id", container.ID),
+			zap.Error(err))
+		select {
+		case <-time.After(firstRequestRetryDelay):
+			if req, err = rewindRequest(req); err == nil {
+				resp, err = client.Do(timing.trace(req))
+			}
+		case <-r.Context().Done():
+		}
+	}
 	if err != nil {
-		h.logger.Error("failed to proxy request to container", zap.Error(err))
+		if errors.Is(context.Cause(r.Context()), errContainerExited) {
+			w.Header().Set(serverlessErrorHeader, "container-exited-unexpectedly")
+			return caddyhttp.Error(http.StatusBadGateway, errContainerExited)
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return caddyhttp.Error(http.StatusRequestEntityTooLarge, err)
+		}
+		// A disconnected client is reported by executeFunction
+		if r.Context().Err() == nil {
+			h.errorLogFor(function).Error("failed to proxy request to container", zap.Error(err))
+		}
 		return caddyhttp.Error(http.StatusBadGateway, err)
 	}
 	defer func() {
@@ -302,25 +1748,157 @@ func (h *Handler) proxyToContainer(w http.ResponseWriter, r *http.Request, conta
 		}
 	}
 
-	// Copy status code
-	w.WriteHeader(resp.StatusCode)
+	timing.setHeader(w.Header())
+
+	// Push the resources the response preloads before sending it
+	if function.EnableHTTP2Push {
+		h.pushPreloads(w, resp.Header)
+	}
+
+	// Announce the trailers the container declared
+	announcedTrailers := len(resp.Trailer)
+	for name := range resp.Trailer {
+		w.Header().Add("Trailer", name)
+	}
+
+	// Copy status code, translating it if configured
+	status := resp.StatusCode
+	if mapped, ok := function.StatusMap[status]; ok {
+		status = mapped
+	}
+	w.WriteHeader(status)
+	observeResponse(function, status)
+
+	// Flush the header so that trailers are sent even when the body is
+	// empty, as with gRPC errors that are carried only in trailers
+	if announcedTrailers > 0 {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
 
 	// Copy response body
-	_, err = io.Copy(w, resp.Body)
+	var respReader io.Reader = resp.Body
+	if respBody != nil {
+		respReader = io.TeeReader(resp.Body, respBody)
+	}
+	_, err = io.Copy(w, respReader)
+	if respBody != nil {
+		h.logDebugBodies(function, req, reqBody, resp, respBody)
+	}
 	if err != nil {
-		h.logger.Error("failed to copy response body", zap.Error(err))
+		if r.Context().Err() == nil {
+			h.errorLogFor(function).Error("failed to copy response body", zap.Error(err))
+		}
 		return err
 	}
 
+	// Copy trailers, which are only complete once the body is read. Ones
+	// the container did not announce are sent with http.TrailerPrefix.
+	for name, values := range resp.Trailer {
+		if len(resp.Trailer) != announcedTrailers {
+			name = http.TrailerPrefix + name
+		}
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
 	return nil
 }
 
+// firstRequestRetryDelay is how long to wait before resending a request that
+// a freshly started container refused
+const firstRequestRetryDelay = 100 * time.Millisecond
+
+// retryableFirstRequestError reports whether err means the container refused
+// or reset the connection and req can be sent again. A refused connection
+// never received the request. A reset one may have been processed already,
+// so only idempotent requests are resent. Requests with a body can only be
+// resent if it can be read again through GetBody.
+func retryableFirstRequestError(err error, req *http.Request) bool {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+	case errors.Is(err, syscall.ECONNRESET):
+		if !idempotentMethod(req.Method) {
+			return false
+		}
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// idempotentMethod reports whether sending a request with method twice has
+// the same effect as sending it once (RFC 9110, section 9.2.2)
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewindRequest returns a copy of req with a fresh body for resending
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return retry, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry.Body = body
+	return retry, nil
+}
+
 // Cleanup cleans up resources when the handler is being shut down.
 func (h *Handler) Cleanup() error {
+	unregisterHandler(h)
+	eventsCtx, cancelEvents := context.WithTimeout(context.Background(), eventFlushTimeout)
+	h.events.close(eventsCtx)
+	cancelEvents()
+	if h.AutoPruneImages {
+		// After this handler's containers are stopped
+		defer h.pruneOrphanedImages()
+	}
+	var err error
+	for _, fn := range h.Functions {
+		if fn.httpClient != nil {
+			fn.httpClient.CloseIdleConnections()
+		}
+		if fn.inlineScript.Source == "" {
+			continue
+		}
+		if rmErr := os.Remove(fn.inlineScript.Source); rmErr != nil && !os.IsNotExist(rmErr) {
+			err = rmErr
+		}
+	}
+
+	// Containers of functions that are still served with the same
+	// configuration after a reload finish their requests instead
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	draining, stopErr := h.inflight.stopChanged(ctx, h)
+	if stopErr != nil {
+		err = stopErr
+	}
+	if draining > 0 {
+		return err
+	}
+
+	if h.composeManager != nil {
+		if cmErr := h.composeManager.Cleanup(); cmErr != nil {
+			err = cmErr
+		}
+	}
 	if h.containerManager != nil {
-		return h.containerManager.Cleanup()
+		if cmErr := h.containerManager.Cleanup(); cmErr != nil {
+			err = cmErr
+		}
 	}
-	return nil
+	return err
 }
 
 // Interface guards
@@ -0,0 +1,239 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// benchmarkParallelism is the number of goroutines per GOMAXPROCS used by
+// the parallel benchmark variants
+var benchmarkParallelism = []int{1, 10, 50, 100}
+
+// newBenchmarkHandler provisions a handler for benchmarks. It logs nothing,
+// since benchmark log output is always printed.
+func newBenchmarkHandler(b *testing.B, functions []FunctionConfig, cm ContainerManagerInterface) *Handler {
+	b.Helper()
+	h := &Handler{
+		Functions:        functions,
+		containerManager: cm,
+		logger:           zap.NewNop(),
+	}
+	if err := h.provision(); err != nil {
+		b.Fatalf("failed to provision handler: %v", err)
+	}
+	b.Cleanup(func() { _ = h.Cleanup() })
+	return h
+}
+
+// BenchmarkExecuteFunction measures the handler overhead of starting a
+// container and proxying to it. Containers start instantly and the backend
+// is an in-process server, so the result excludes Docker itself.
+func BenchmarkExecuteFunction(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		b.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	cm := NewMockContainerManager()
+	cm.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "bench", IP: host, Port: port}, nil
+	})
+	h := newBenchmarkHandler(b, []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/bench$", Image: "bench:latest"},
+	}, cm)
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+	for _, parallelism := range benchmarkParallelism {
+		b.Run(fmt.Sprintf("goroutines-%d", parallelism), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					w := httptest.NewRecorder()
+					if err := h.ServeHTTP(w, httptest.NewRequest("GET", "/bench", nil), next); err != nil {
+						b.Errorf("unexpected error: %v", err)
+						return
+					}
+					if w.Code != http.StatusOK {
+						b.Errorf("unexpected status %d", w.Code)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkFindMatchingFunction measures routeMap lookup with 100 functions,
+// matching the last one configured
+func BenchmarkFindMatchingFunction(b *testing.B) {
+	h := newBenchmarkHandler(b, largeFunctionSet(100), NewMockContainerManager())
+	req := httptest.NewRequest("GET", "/api/fn99/resource", nil)
+
+	for _, parallelism := range benchmarkParallelism {
+		b.Run(fmt.Sprintf("goroutines-%d", parallelism), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if h.findMatchingFunction(req) == nil {
+						b.Error("expected a matching function")
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkFindMatchingFunction_LargeFunctionSet measures routeMap lookup
+// with 1000 functions, for a path matching the last one configured and for
+// a path matching none. Both are worst cases for a scan over all functions.
+func BenchmarkFindMatchingFunction_LargeFunctionSet(b *testing.B) {
+	h := newBenchmarkHandler(b, largeFunctionSet(1000), NewMockContainerManager())
+
+	paths := []struct {
+		name string
+		path string
+	}{
+		{name: "last", path: "/api/fn999/resource"},
+		{name: "none", path: "/unknown/resource"},
+	}
+	for _, p := range paths {
+		b.Run(p.name, func(b *testing.B) {
+			req := httptest.NewRequest("GET", p.path, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.findMatchingFunction(req)
+			}
+		})
+	}
+}
+
+// largeFunctionSet returns n GET functions with unique paths /api/fn<i>/.*
+func largeFunctionSet(n int) []FunctionConfig {
+	functions := make([]FunctionConfig, n)
+	for i := range functions {
+		functions[i] = FunctionConfig{
+			Methods: []string{"GET"},
+			Path:    fmt.Sprintf("^/api/fn%d/.*$", i),
+			Image:   "bench:latest",
+		}
+	}
+	return functions
+}
+
+func TestFindMatchingFunction_NoAllocs(t *testing.T) {
+	if raceEnabled {
+		// sync.Pool drops items under the race detector, so regexp allocates
+		t.Skip("allocation counts are not meaningful under the race detector")
+	}
+	h, err := newTestHandler(t, largeFunctionSet(1000), nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	for _, path := range []string{"/api/fn999/resource", "/unknown/resource"} {
+		req := httptest.NewRequest("GET", path, nil)
+		h.findMatchingFunction(req)
+		if allocs := testing.AllocsPerRun(100, func() { h.findMatchingFunction(req) }); allocs != 0 {
+			t.Errorf("%s: expected no allocations per lookup, got %v", path, allocs)
+		}
+	}
+}
+
+// BenchmarkFindMatchingFunction_Index compares looking up literal prefixes
+// through the trie against scanning the same number of regexes
+func BenchmarkFindMatchingFunction_Index(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		prefixes := make([]FunctionConfig, n)
+		for i := range prefixes {
+			prefixes[i] = FunctionConfig{
+				Methods: []string{"GET"},
+				Path:    fmt.Sprintf("^/api/fn%d/", i),
+				Image:   "bench:latest",
+			}
+		}
+		variants := []struct {
+			name      string
+			functions []FunctionConfig
+		}{
+			{name: "trie", functions: prefixes},
+			{name: "regex", functions: largeFunctionSet(n)},
+		}
+		for _, v := range variants {
+			b.Run(fmt.Sprintf("%s-%d", v.name, n), func(b *testing.B) {
+				h := newBenchmarkHandler(b, v.functions, NewMockContainerManager())
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/fn%d/resource", n-1), nil)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if h.findMatchingFunction(req) == nil {
+						b.Fatal("expected a matching function")
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkProvision measures provisioning 1000 regex functions, with path
+// regexes compiled on first use (lazy) or all up front (eager)
+func BenchmarkProvision(b *testing.B) {
+	functions := largeFunctionSet(1000)
+	for _, eager := range []bool{false, true} {
+		name := "lazy"
+		if eager {
+			name = "eager"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h := &Handler{
+					Functions:        append([]FunctionConfig(nil), functions...),
+					containerManager: NewMockContainerManager(),
+					logger:           zap.NewNop(),
+				}
+				if err := h.provision(); err != nil {
+					b.Fatalf("failed to provision handler: %v", err)
+				}
+				if eager {
+					for j := range h.Functions {
+						if err := h.Functions[j].ensureCompiled(); err != nil {
+							b.Fatalf("failed to compile path: %v", err)
+						}
+					}
+				}
+				unregisterHandler(h)
+			}
+		})
+	}
+}
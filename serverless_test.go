@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -20,7 +22,15 @@ const (
 	goTestDockerImageName    = "caddy-serverless-go-echoserver-test"
 	pyEchoServerDir          = "./testdata/pyechoserver"
 	pyTestDockerImageName    = "caddy-serverless-py-echoserver-test"
+	envServerDir             = "./testdata/envserver"
+	envTestDockerImageName   = "caddy-serverless-envserver-test"
+	fileServerDir            = "./testdata/fileserver"
+	fileTestDockerImageName  = "caddy-serverless-fileserver-test"
 	commonTestDockerImageTag = "latest"
+
+	// maxColdStart bounds a cold start of a local image, from sending the
+	// request to the first byte of the response
+	maxColdStart = 10 * time.Second
 )
 
 // Helper to build a test Docker image
@@ -64,6 +74,9 @@ func removeTestImage(t *testing.T, imageName string) {
 
 // Structure for the echoserver's response
 type EchoResponse struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   string      `json:"query"`
 	Headers http.Header `json:"headers"`
 	Body    string      `json:"body"`
 }
@@ -186,6 +199,100 @@ func TestServerlessPlugin_PostEcho(t *testing.T) {
 	t.Log("Serverless POST echo test completed successfully.")
 }
 
+func TestServerlessPlugin_MethodsEcho(t *testing.T) {
+	// Skip if Docker is not available
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Docker not found in PATH, skipping integration test")
+	}
+
+	imageFullName := buildTestImage(t, goTestDockerImageName, commonTestDockerImageTag, goEchoServerDir)
+	defer removeTestImage(t, imageFullName)
+
+	// Ensure admin API is configured to listen on caddytest.Default.AdminPort (2999)
+	// as caddytest will continue to try and communicate with it on that port.
+	caddyJSON := fmt.Sprintf(`
+	{
+		"admin": {
+			"listen": "localhost:2999"
+		},
+		"apps": {
+			"http": {
+				"servers": {
+					"srv0": {
+						"listen": [":9080"],
+						"routes": [
+							{
+								"handle": [{
+									"handler": "serverless",
+									"functions": [{
+										"methods": ["GET", "DELETE"],
+										"path": "/items/.*",
+										"image": "%s",
+										"port": 8080,
+										"timeout": "60s"
+									}]
+								}]
+							}
+						]
+					}
+				}
+			}
+		}
+	}
+	`, imageFullName)
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(caddyJSON, "json")
+
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{method: http.MethodGet},
+		{method: http.MethodDelete, body: `{"reason": "obsolete"}`},
+	}
+	client := &http.Client{Timeout: 90 * time.Second}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "http://localhost:9080/items/42?verbose=1", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("X-Custom-Header", "CaddyServerlessTest")
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			responseBodyBytes, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d. Response body: %s", http.StatusOK, resp.StatusCode, string(responseBodyBytes))
+			}
+
+			var echoResp EchoResponse
+			if err := json.Unmarshal(responseBodyBytes, &echoResp); err != nil {
+				t.Fatalf("Failed to unmarshal response JSON: %v. Body: %s", err, string(responseBodyBytes))
+			}
+			if echoResp.Method != tt.method {
+				t.Errorf("Expected echoed method %s, got %s", tt.method, echoResp.Method)
+			}
+			if echoResp.Path != "/items/42" || echoResp.Query != "verbose=1" {
+				t.Errorf("Expected echoed path /items/42 and query verbose=1, got %s and %s", echoResp.Path, echoResp.Query)
+			}
+			if customHeader := echoResp.Headers.Get("X-Custom-Header"); customHeader != "CaddyServerlessTest" {
+				t.Errorf("Expected echoed 'X-Custom-Header' to be 'CaddyServerlessTest', got '%s'", customHeader)
+			}
+			if echoResp.Body != tt.body {
+				t.Errorf("Expected echoed body to be '%s', got '%s'", tt.body, echoResp.Body)
+			}
+		})
+	}
+}
+
 func TestServerlessPlugin_PythonPostEcho(t *testing.T) {
 	// Skip if Docker is not available
 	if _, err := exec.LookPath("docker"); err != nil {
@@ -300,6 +407,234 @@ func TestServerlessPlugin_PythonPostEcho(t *testing.T) {
 	t.Log("Serverless Python POST echo test completed successfully.")
 }
 
+// TestServerlessPlugin_Environment checks that FunctionConfig.Environment
+// reaches the process running in the container, not just the docker run args
+func TestServerlessPlugin_Environment(t *testing.T) {
+	// Skip if Docker is not available
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Docker not found in PATH, skipping integration test")
+	}
+
+	imageFullName := buildTestImage(t, envTestDockerImageName, commonTestDockerImageTag, envServerDir)
+	defer removeTestImage(t, imageFullName)
+
+	// Spaces, '=' and quotes must survive the trip through docker run -e
+	expectedValue := `hello from the environment: a=1 "quoted"`
+	environment, err := json.Marshal(map[string]string{"SERVERLESS_TEST_VALUE": expectedValue})
+	if err != nil {
+		t.Fatalf("Failed to marshal environment: %v", err)
+	}
+
+	// Ensure admin API is configured to listen on caddytest.Default.AdminPort (2999)
+	// as caddytest will continue to try and communicate with it on that port.
+	caddyJSON := fmt.Sprintf(`
+	{
+		"admin": {
+			"listen": "localhost:2999"
+		},
+		"apps": {
+			"http": {
+				"servers": {
+					"srv0": {
+						"listen": [":9080"],
+						"routes": [
+							{
+								"handle": [{
+									"handler": "serverless",
+									"functions": [{
+										"methods": ["GET"],
+										"path": "/env",
+										"image": "%s",
+										"port": 8080,
+										"timeout": "60s",
+										"environment": %s
+									}]
+								}]
+							}
+						]
+					}
+				}
+			}
+		}
+	}
+	`, imageFullName, environment)
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(caddyJSON, "json")
+
+	client := &http.Client{Timeout: 90 * time.Second} // Allow for Docker startup
+	resp, err := client.Get("http://localhost:9080/env?name=SERVERLESS_TEST_VALUE")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+	if string(body) != expectedValue {
+		t.Errorf("Expected environment value '%s', got '%s'", expectedValue, string(body))
+	}
+}
+
+// TestServerlessPlugin_VolumeMount checks the whole volume chain, from the
+// Caddyfile volume subdirective through docker run to reading the file in
+// the container
+func TestServerlessPlugin_VolumeMount(t *testing.T) {
+	// Skip if Docker is not available
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Docker not found in PATH, skipping integration test")
+	}
+
+	imageFullName := buildTestImage(t, fileTestDockerImageName, commonTestDockerImageTag, fileServerDir)
+	defer removeTestImage(t, imageFullName)
+
+	hostDir := t.TempDir()
+	expectedContents := "mounted from the host\nsecond line\n"
+	if err := os.WriteFile(filepath.Join(hostDir, "message.txt"), []byte(expectedContents), 0o644); err != nil {
+		t.Fatalf("Failed to write host file: %v", err)
+	}
+
+	// Ensure admin API is configured to listen on caddytest.Default.AdminPort (2999)
+	// as caddytest will continue to try and communicate with it on that port.
+	caddyfile := fmt.Sprintf(`
+	{
+		admin localhost:2999
+	}
+
+	:9080 {
+		route {
+			serverless {
+				function {
+					methods GET
+					path /file
+					image %s
+					port 8080
+					timeout 60s
+					volume %s:/data:ro
+				}
+			}
+		}
+	}
+	`, imageFullName, hostDir)
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(caddyfile, "caddyfile")
+
+	client := &http.Client{Timeout: 90 * time.Second} // Allow for Docker startup
+	resp, err := client.Get("http://localhost:9080/file?path=/data/message.txt")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Response body: %s", http.StatusOK, resp.StatusCode, string(body))
+	}
+	if string(body) != expectedContents {
+		t.Errorf("Expected file contents '%s', got '%s'", expectedContents, string(body))
+	}
+}
+
+// firstByteTransport records when the first byte of each response arrives
+type firstByteTransport struct {
+	http.RoundTripper
+	firstByte time.Time
+}
+
+func (t *firstByteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+	return t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// TestServerlessPlugin_ColdStartLatency measures a cold start through Caddy
+// and fails if it regresses past maxColdStart. Run with -v to see the
+// latency as a baseline.
+func TestServerlessPlugin_ColdStartLatency(t *testing.T) {
+	// Skip if Docker is not available
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Docker not found in PATH, skipping integration test")
+	}
+
+	// Build the image up front so that only the cold start is measured
+	imageFullName := buildTestImage(t, goTestDockerImageName, commonTestDockerImageTag, goEchoServerDir)
+	defer removeTestImage(t, imageFullName)
+
+	// Ensure admin API is configured to listen on caddytest.Default.AdminPort (2999)
+	// as caddytest will continue to try and communicate with it on that port.
+	caddyJSON := fmt.Sprintf(`
+	{
+		"admin": {
+			"listen": "localhost:2999"
+		},
+		"apps": {
+			"http": {
+				"servers": {
+					"srv0": {
+						"listen": [":9080"],
+						"routes": [
+							{
+								"handle": [{
+									"handler": "serverless",
+									"functions": [{
+										"methods": ["POST"],
+										"path": "/echo",
+										"image": "%s",
+										"port": 8080,
+										"timeout": "30s"
+									}]
+								}]
+							}
+						]
+					}
+				}
+			}
+		}
+	}
+	`, imageFullName)
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(caddyJSON, "json")
+
+	transport := &firstByteTransport{RoundTripper: http.DefaultTransport}
+	client := &http.Client{Transport: transport, Timeout: 60 * time.Second}
+	req, err := http.NewRequest("POST", "http://localhost:9080/echo", bytes.NewBufferString(`{"message": "cold start"}`))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status %d, got %d. Response body: %s", http.StatusOK, resp.StatusCode, string(bodyBytes))
+	}
+	if transport.firstByte.IsZero() {
+		t.Fatal("No response byte was recorded")
+	}
+
+	latency := transport.firstByte.Sub(start)
+	t.Logf("Cold start latency (request to first byte): %v", latency)
+	if latency > maxColdStart {
+		t.Errorf("Cold start took %v, exceeding %v", latency, maxColdStart)
+	}
+}
+
 // TestMain can be used for global setup/teardown if needed,
 // for example, ensuring Docker is available.
 func TestMain(m *testing.M) {
@@ -0,0 +1,47 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package serverlesstest provides helpers for testing code built on the
+// serverless handler without Caddy or Docker.
+package serverlesstest
+
+import (
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	serverless "github.com/jose/caddy-serverless"
+)
+
+// NewTestHandler returns a handler that is ready to serve the given functions
+// without a caddy.Context. It uses cm to run containers, or a
+// serverless.MockContainerManager if cm is nil, and client to reach them (a
+// default client if nil). Logs go to t, and the handler is cleaned up with
+// the test. Like provisioning, it does not validate the configuration; call
+// the handler's Validate for that.
+func NewTestHandler(t testing.TB, functions []serverless.FunctionConfig, cm serverless.ContainerManagerInterface, client *http.Client) (*serverless.Handler, error) {
+	if cm == nil {
+		cm = serverless.NewMockContainerManager()
+	}
+	h := &serverless.Handler{
+		Functions:  append([]serverless.FunctionConfig(nil), functions...),
+		HTTPClient: client,
+	}
+	if err := h.ProvisionWith(cm, zaptest.NewLogger(t)); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = h.Cleanup() })
+	return h, nil
+}
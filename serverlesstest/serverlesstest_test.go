@@ -0,0 +1,91 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverlesstest_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	serverless "github.com/jose/caddy-serverless"
+	"github.com/jose/caddy-serverless/serverlesstest"
+)
+
+func TestNewTestHandler(t *testing.T) {
+	// The backend stands in for the function's container
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	cm := serverless.NewMockContainerManager()
+	cm.SetStartContainerFunc(func(_ context.Context, config serverless.ContainerConfig) (*serverless.Container, error) {
+		if config.Image != "hello:latest" {
+			t.Errorf("expected image hello:latest, got %s", config.Image)
+		}
+		return &serverless.Container{ID: "hello", IP: host, Port: port}, nil
+	})
+
+	handler, err := serverlesstest.NewTestHandler(t, []serverless.FunctionConfig{
+		{Methods: []string{"GET"}, Path: "^/hello$", Image: "hello:latest"},
+	}, cm, nil)
+	if err != nil {
+		t.Fatalf("NewTestHandler failed: %v", err)
+	}
+
+	nextCalled := false
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error {
+		nextCalled = true
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	if err := handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil), next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "hello from /hello" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	// Unmatched paths fall through to the next handler
+	if err := handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil), next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !nextCalled {
+		t.Error("expected unmatched request to be passed to the next handler")
+	}
+
+	// Invalid configurations are rejected by validation
+	invalid, err := serverlesstest.NewTestHandler(t, []serverless.FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/bad", Image: ""},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewTestHandler failed: %v", err)
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected error for function without image")
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SwarmContainerManager runs serverless functions as Docker Swarm services,
+// which allows placement constraints to pick the nodes functions run on.
+// Services publish their port through the swarm routing mesh, so they are
+// reachable on the local node regardless of where the task is scheduled.
+type SwarmContainerManager struct {
+	services map[string]*Container
+	logger   *zap.Logger
+	mutex    sync.RWMutex
+
+	// containerManager is used for the TCP readiness probe
+	containerManager *ContainerManager
+}
+
+// NewSwarmContainerManager creates a new swarm service manager
+func NewSwarmContainerManager(logger *zap.Logger) *SwarmContainerManager {
+	return &SwarmContainerManager{
+		services:         make(map[string]*Container),
+		logger:           logger,
+		containerManager: NewContainerManager(logger),
+	}
+}
+
+// swarmActive reports whether the local Docker daemon is part of an active swarm
+func swarmActive(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{.Swarm.LocalNodeState}}")
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to query docker info: %v", err)
+	}
+	return strings.TrimSpace(string(output)) == "active", nil
+}
+
+// maxServiceNameLength is the longest service name swarm accepts
+const maxServiceNameLength = 63
+
+// serviceName returns a unique name for a namespaced service, built from its
+// containerKey. Swarm rejects dots and names over 63 characters, so the key
+// is shortened to leave room for the unique suffix.
+func (sm *SwarmContainerManager) serviceName(config ContainerConfig) string {
+	suffix := "_" + generateRequestID()[:12]
+	key := strings.ReplaceAll(sm.containerManager.containerKey(config.Namespace, config.Image, config.FunctionPath), ".", "-")
+	if len(key) > maxServiceNameLength-len(suffix) {
+		key = strings.TrimRight(key[:maxServiceNameLength-len(suffix)], "-_")
+	}
+	return key + suffix
+}
+
+// buildServiceArgs builds the docker service create arguments for the given configuration
+func buildServiceArgs(config ContainerConfig) []string {
+	args := []string{"service", "create", "--detach", "--restart-condition", "none"}
+
+	// Name and label namespaced services and their containers
+	if config.Name != "" {
+		args = append(args, "--name", config.Name)
+	}
+	if config.Namespace != "" {
+		label := namespaceLabel + "=" + config.Namespace
+		args = append(args, "--label", label, "--container-label", label)
+	}
+
+	// Publish through the routing mesh so the service is reachable locally
+	args = append(args, "--publish", fmt.Sprintf("published=%d,target=%d", config.Port, config.Port))
+
+	// Add environment variables
+	for key, value := range config.Environment {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", key, value))
+	}
+
+	// Add volume mounts
+	for _, volume := range config.Volumes {
+		mountStr := fmt.Sprintf("type=bind,source=%s,target=%s", volume.Source, volume.Target)
+		if volume.ReadOnly {
+			mountStr += ",readonly"
+		}
+		if volume.Propagation != "" {
+			mountStr += ",bind-propagation=" + volume.Propagation
+		}
+		args = append(args, "--mount", mountStr)
+	}
+
+	// Add placement constraints
+	for _, constraint := range config.PlacementConstraints {
+		args = append(args, "--constraint", constraint)
+	}
+
+	// Add memory limit
+	if config.Memory != "" {
+		args = append(args, "--limit-memory", config.Memory)
+	}
+
+	// Add supplementary groups
+	for _, group := range config.GroupAdd {
+		args = append(args, "--group", group)
+	}
+
+	// Add logging options
+	if config.LogDriver != "" {
+		args = append(args, "--log-driver", config.LogDriver)
+	}
+	if config.LogConfig != nil {
+		args = append(args, config.LogConfig.args()...)
+	}
+	logOpts := make([]string, 0, len(config.LogOpts))
+	for key := range config.LogOpts {
+		logOpts = append(logOpts, key)
+	}
+	sort.Strings(logOpts)
+	for _, key := range logOpts {
+		args = append(args, "--log-opt", key+"="+config.LogOpts[key])
+	}
+
+	// Add image, command and extra arguments
+	args = append(args, config.Image)
+	args = append(args, config.Command...)
+	args = append(args, config.AppendArgs...)
+
+	return args
+}
+
+// swarmUnsupportedOptions returns the fields set on fn that docker service
+// create has no flag for, so a swarm service cannot honor them
+func swarmUnsupportedOptions(fn FunctionConfig) []string {
+	var fields []string
+	if fn.MemorySwap != "" {
+		fields = append(fields, "memory_swap")
+	}
+	if fn.OOMKillDisable {
+		fields = append(fields, "oom_kill_disable")
+	}
+	if fn.OOMScoreAdj != 0 {
+		fields = append(fields, "oom_score_adj")
+	}
+	if fn.CgroupParent != "" {
+		fields = append(fields, "cgroup_parent")
+	}
+	if fn.IPCMode != "" {
+		fields = append(fields, "ipc_mode")
+	}
+	if fn.PIDMode == "host" {
+		fields = append(fields, "pid_mode")
+	}
+	if fn.UsernsMode != "" {
+		fields = append(fields, "userns_mode")
+	}
+	if fn.Privileged {
+		fields = append(fields, "privileged")
+	}
+	if fn.SeccompProfile != "" {
+		fields = append(fields, "seccomp_profile")
+	}
+	return fields
+}
+
+// StartContainer creates a swarm service with the given configuration
+func (sm *SwarmContainerManager) StartContainer(ctx context.Context, config ContainerConfig) (*Container, error) {
+	if err := validateContainerConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid container configuration: %w", err)
+	}
+
+	if config.Namespace != "" && config.Name == "" {
+		config.Name = sm.serviceName(config)
+	}
+
+	args := buildServiceArgs(config)
+	sm.logger.Debug("creating service", zap.Strings("args", args))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service: %v (output: %s)", err, string(output))
+	}
+
+	serviceID := strings.TrimSpace(string(output))
+	if serviceID == "" {
+		return nil, fmt.Errorf("docker service create returned empty service ID")
+	}
+
+	sm.logger.Debug("service created", zap.String("service_id", serviceID))
+
+	container := &Container{
+		ID:        serviceID,
+		IP:        "127.0.0.1",
+		Port:      config.Port,
+		StartedAt: time.Now(),
+	}
+
+	sm.mutex.Lock()
+	sm.services[serviceID] = container
+	sm.mutex.Unlock()
+
+	return container, nil
+}
+
+// WaitForReady waits for the service task to be running and its port to accept connections.
+// The routing mesh accepts connections before the task is up, so the task state is checked first.
+func (sm *SwarmContainerManager) WaitForReady(ctx context.Context, container *Container, timeout time.Duration, port int, maxAttempts int) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		running, err := sm.taskRunning(ctx, container.ID)
+		if err != nil {
+			sm.logger.Debug("failed to query service tasks", zap.String("service_id", container.ID), zap.Error(err))
+		}
+		if running {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("service did not start a running task within timeout")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	return sm.containerManager.WaitForReady(ctx, container, time.Until(deadline), port, maxAttempts)
+}
+
+// taskRunning reports whether the service has a task in the running state
+func (sm *SwarmContainerManager) taskRunning(ctx context.Context, serviceID string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "docker", "service", "ps", "--filter", "desired-state=running",
+		"--format", "{{.CurrentState}}", serviceID)
+	output, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "Running") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExecInContainer is not supported, as a service's tasks may run on any
+// node of the swarm
+func (sm *SwarmContainerManager) ExecInContainer(_ context.Context, _ string, _ []string) (string, string, int, error) {
+	return "", "", -1, errExecNotSupported
+}
+
+// GetContainerLogs returns the recent output of a service's tasks with
+// docker service logs
+func (sm *SwarmContainerManager) GetContainerLogs(ctx context.Context, serviceID string, since time.Duration, tail int) ([]LogEntry, error) {
+	args := append([]string{"service", "logs", "--raw"}, logsArgs(since, tail)...)
+	return runLogsCommand(ctx, append(args, serviceID)...)
+}
+
+// PauseContainer is not supported, as swarm services cannot be paused
+func (sm *SwarmContainerManager) PauseContainer(_ context.Context, _ string) error {
+	return errPauseNotSupported
+}
+
+// ResumeContainer is not supported, as swarm services cannot be paused
+func (sm *SwarmContainerManager) ResumeContainer(_ context.Context, _ string) error {
+	return errPauseNotSupported
+}
+
+// ContainerRunning is not supported, as swarm replaces the tasks of a
+// service that exit
+func (sm *SwarmContainerManager) ContainerRunning(_ context.Context, _ string) (bool, error) {
+	return false, errRunningNotSupported
+}
+
+// StopContainer removes a service
+func (sm *SwarmContainerManager) StopContainer(ctx context.Context, serviceID string) error {
+	sm.mutex.Lock()
+	if container, ok := sm.services[serviceID]; ok {
+		container.markStopped()
+		delete(sm.services, serviceID)
+	}
+	sm.mutex.Unlock()
+
+	return sm.removeService(ctx, serviceID)
+}
+
+// removeService removes a service by its ID
+func (sm *SwarmContainerManager) removeService(ctx context.Context, serviceID string) error {
+	sm.logger.Debug("removing service", zap.String("service_id", serviceID))
+
+	cmd := exec.CommandContext(ctx, "docker", "service", "rm", serviceID)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove service: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+// Cleanup removes all managed services
+func (sm *SwarmContainerManager) Cleanup() error {
+	sm.mutex.Lock()
+	serviceIDs := make([]string, 0, len(sm.services))
+	for id := range sm.services {
+		serviceIDs = append(serviceIDs, id)
+	}
+	sm.services = make(map[string]*Container)
+	sm.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var lastErr error
+	for _, id := range serviceIDs {
+		if err := sm.removeService(ctx, id); err != nil {
+			sm.logger.Error("failed to remove service during cleanup", zap.String("service_id", id), zap.Error(err))
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// Interface guard
+var _ ContainerManagerInterface = (*SwarmContainerManager)(nil)
@@ -10,24 +10,46 @@ import (
 )
 
 type RequestInfo struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   string      `json:"query"`
 	Headers http.Header `json:"headers"`
 	Body    string      `json:"body"`
 }
 
+// allowedMethods are the methods echoed back; others get a 405
+var allowedMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
 func echoHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if !allowedMethods[r.Method] {
+		http.Error(w, fmt.Sprintf("Method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
 		return
 	}
+	defer r.Body.Close()
 
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusInternalServerError)
-		return
+	// GET and HEAD requests are echoed with an empty body
+	var bodyBytes []byte
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		var err error
+		bodyBytes, err = io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
-	defer r.Body.Close()
 
 	requestInfo := RequestInfo{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
 		Headers: r.Header,
 		Body:    string(bodyBytes),
 	}
@@ -50,7 +72,7 @@ func main() {
 	}
 
 	http.HandleFunc("/", echoHandler)
-	log.Printf("Echo server listening on port %s", port)
+	log.Printf("Echo server listening on port %s for GET, HEAD, POST, PUT, PATCH, DELETE and OPTIONS", port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -0,0 +1,3 @@
+module github.com/caddyserver/caddy/v2/modules/caddyhttp/serverless/testdata/envserver
+
+go 1.20
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+)
+
+// envHandler responds with the value of the environment variable named by
+// the "name" query parameter
+func envHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		http.Error(w, "environment variable "+name+" is not set", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(value))
+}
+
+func main() {
+	port := os.Getenv("ENVSERVER_PORT")
+	if port == "" {
+		port = "8080" // Default port
+	}
+
+	http.HandleFunc("/", envHandler)
+	log.Printf("Env server listening on port %s", port)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultTimelineBufferSize is the number of timelines kept when TimelineBufferSize is unset
+const defaultTimelineBufferSize = 100
+
+// Timeline records when each stage of a single function execution happened.
+// Stages that were never reached (e.g. because the container failed to start) are omitted.
+type Timeline struct {
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Image     string `json:"image"`
+
+	RequestReceived      *time.Time `json:"request_received,omitempty"`
+	ContainerStartCalled *time.Time `json:"container_start_called,omitempty"`
+	ContainerStarted     *time.Time `json:"container_started,omitempty"`
+	ReadyCheckPassed     *time.Time `json:"ready_check_passed,omitempty"`
+	ProxyStarted         *time.Time `json:"proxy_started,omitempty"`
+	ProxyCompleted       *time.Time `json:"proxy_completed,omitempty"`
+	ContainerStopCalled  *time.Time `json:"container_stop_called,omitempty"`
+}
+
+// newTimeline starts a timeline for the given request, keyed by its X-Request-ID header
+// or a generated ID if the client did not send one
+func newTimeline(r *http.Request, function *FunctionConfig) *Timeline {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = generateRequestID()
+	}
+	return &Timeline{
+		RequestID:       requestID,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		Image:           function.Image,
+		RequestReceived: timestamp(),
+	}
+}
+
+// timestamp returns a pointer to the current time for recording a timeline stage
+func timestamp() *time.Time {
+	now := time.Now()
+	return &now
+}
+
+// generateRequestID returns a random hex identifier
+func generateRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// timelineBuffer is a fixed-size ring buffer of completed timelines
+type timelineBuffer struct {
+	mutex   sync.Mutex
+	entries []Timeline
+	next    int
+	full    bool
+}
+
+// newTimelineBuffer creates a ring buffer holding up to size timelines
+func newTimelineBuffer(size int) *timelineBuffer {
+	return &timelineBuffer{entries: make([]Timeline, size)}
+}
+
+// add stores a completed timeline, overwriting the oldest one when full
+func (b *timelineBuffer) add(t *Timeline) {
+	if b == nil || len(b.entries) == 0 || t == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.entries[b.next] = *t
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// list returns the buffered timelines, oldest first
+func (b *timelineBuffer) list() []Timeline {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.full {
+		return append([]Timeline(nil), b.entries[:b.next]...)
+	}
+	result := make([]Timeline, 0, len(b.entries))
+	result = append(result, b.entries[b.next:]...)
+	return append(result, b.entries[:b.next]...)
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// TestTimeline_FullRequest tests that a successful request records every stage
+func TestTimeline_FullRequest(t *testing.T) {
+	handler := &Handler{
+		Functions: []FunctionConfig{
+			{
+				Methods: []string{"GET"},
+				Path:    "/api/timeline",
+				Image:   "test:latest",
+			},
+		},
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := handler.Provision(ctx); err != nil {
+		t.Fatalf("failed to provision handler: %v", err)
+	}
+	defer func() { _ = handler.Cleanup() }()
+
+	handler.containerManager = NewMockContainerManager()
+	handler.HTTPClient = &http.Client{Transport: &MockRoundTripper{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("ok")),
+			Header:     make(http.Header),
+		},
+	}}
+
+	req := fakeRequest("GET", "/api/timeline")
+	req.Header.Set("X-Request-ID", "timeline-request-1")
+	w := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+
+	if err := handler.ServeHTTP(w, req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fetch the timeline through the admin endpoint
+	adminReq := httptest.NewRequest("GET", "/serverless/timeline", nil)
+	adminW := httptest.NewRecorder()
+	if err := new(adminAPI).handleTimeline(adminW, adminReq); err != nil {
+		t.Fatalf("unexpected admin error: %v", err)
+	}
+
+	var timelines []Timeline
+	if err := json.Unmarshal(adminW.Body.Bytes(), &timelines); err != nil {
+		t.Fatalf("failed to parse timeline JSON: %v", err)
+	}
+
+	var timeline *Timeline
+	for i := range timelines {
+		if timelines[i].RequestID == "timeline-request-1" {
+			timeline = &timelines[i]
+		}
+	}
+	if timeline == nil {
+		t.Fatalf("timeline for request not found in %s", adminW.Body.String())
+	}
+
+	stages := map[string]bool{
+		"request_received":       timeline.RequestReceived != nil,
+		"container_start_called": timeline.ContainerStartCalled != nil,
+		"container_started":      timeline.ContainerStarted != nil,
+		"ready_check_passed":     timeline.ReadyCheckPassed != nil,
+		"proxy_started":          timeline.ProxyStarted != nil,
+		"proxy_completed":        timeline.ProxyCompleted != nil,
+		"container_stop_called":  timeline.ContainerStopCalled != nil,
+	}
+	for stage, recorded := range stages {
+		if !recorded {
+			t.Errorf("expected stage %s to be recorded", stage)
+		}
+	}
+	if timeline.ProxyCompleted.Before(*timeline.RequestReceived) {
+		t.Error("expected proxy_completed to be after request_received")
+	}
+}
+
+// TestTimelineBuffer_Wraps tests that the ring buffer keeps only the newest entries
+func TestTimelineBuffer_Wraps(t *testing.T) {
+	buffer := newTimelineBuffer(3)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		buffer.add(&Timeline{RequestID: id})
+	}
+
+	entries := buffer.list()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, expected := range []string{"c", "d", "e"} {
+		if entries[i].RequestID != expected {
+			t.Errorf("expected entry %d to be '%s', got '%s'", i, expected, entries[i].RequestID)
+		}
+	}
+}
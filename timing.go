@@ -0,0 +1,72 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+// serverTimingMetric names the Server-Timing metric set for TimingHeader
+const serverTimingMetric = "upstream"
+
+// upstreamTiming measures how long a container takes to respond, from
+// sending it the request to its first response byte. A nil upstreamTiming
+// measures nothing, for functions without a TimingHeader.
+type upstreamTiming struct {
+	header    string
+	sent      time.Time
+	firstByte time.Time
+}
+
+func newUpstreamTiming(function *FunctionConfig) *upstreamTiming {
+	if function.TimingHeader == "" {
+		return nil
+	}
+	return &upstreamTiming{header: function.TimingHeader}
+}
+
+// trace restarts the measurement and returns req with a trace recording
+// when its response starts
+func (t *upstreamTiming) trace(req *http.Request) *http.Request {
+	if t == nil {
+		return req
+	}
+	t.sent = time.Now()
+	t.firstByte = time.Time{}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}))
+}
+
+// setHeader adds the measured duration to the response header
+func (t *upstreamTiming) setHeader(header http.Header) {
+	if t == nil {
+		return
+	}
+	end := t.firstByte
+	if end.IsZero() {
+		end = time.Now()
+	}
+	duration := millis(end.Sub(t.sent))
+	if http.CanonicalHeaderKey(t.header) == "Server-Timing" {
+		header.Add("Server-Timing", fmt.Sprintf("%s;dur=%.3f", serverTimingMetric, duration))
+		return
+	}
+	header.Set(t.header, strconv.FormatFloat(duration, 'f', 3, 64))
+}
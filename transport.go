@@ -0,0 +1,88 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// defaultClientTimeout limits each request proxied to a container
+const defaultClientTimeout = 30 * time.Second
+
+// TransportConfig tunes the HTTP transport used to reach a function's
+// containers. Unset fields keep Go's defaults.
+type TransportConfig struct {
+	// DialTimeout limits how long connecting to the container may take.
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+
+	// TLSHandshakeTimeout limits the TLS handshake with containers
+	// serving HTTPS.
+	TLSHandshakeTimeout caddy.Duration `json:"tls_handshake_timeout,omitempty"`
+
+	// ResponseHeaderTimeout limits the wait for the response headers once
+	// the request has been sent.
+	ResponseHeaderTimeout caddy.Duration `json:"response_header_timeout,omitempty"`
+
+	// MaxIdleConns limits the idle connections kept open.
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+}
+
+// validate checks that no limit is negative
+func (c *TransportConfig) validate() error {
+	if c.DialTimeout < 0 || c.TLSHandshakeTimeout < 0 || c.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("transport timeouts cannot be negative")
+	}
+	if c.MaxIdleConns < 0 {
+		return fmt.Errorf("transport max_idle_conns cannot be negative")
+	}
+	return nil
+}
+
+// newTransport returns a transport based on http.DefaultTransport with the
+// configured limits applied
+func (c *TransportConfig) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.DialTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   time.Duration(c.DialTimeout),
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(c.TLSHandshakeTimeout)
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(c.ResponseHeaderTimeout)
+	}
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	return transport
+}
+
+// clientFor returns the client used to proxy to the function's containers:
+// the handler's HTTPClient if set, or else the function's own
+func (h *Handler) clientFor(function *FunctionConfig) *http.Client {
+	if h.HTTPClient != nil {
+		return h.HTTPClient
+	}
+	return function.httpClient
+}
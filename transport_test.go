@@ -0,0 +1,176 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestTransportConfig_NewTransport(t *testing.T) {
+	config := &TransportConfig{
+		DialTimeout:           caddy.Duration(time.Second),
+		TLSHandshakeTimeout:   caddy.Duration(2 * time.Second),
+		ResponseHeaderTimeout: caddy.Duration(3 * time.Second),
+		MaxIdleConns:          7,
+	}
+	transport := config.newTransport()
+	if transport.DialContext == nil {
+		t.Error("expected a dialer for dial_timeout")
+	}
+	if transport.TLSHandshakeTimeout != 2*time.Second {
+		t.Errorf("expected TLSHandshakeTimeout 2s, got %v", transport.TLSHandshakeTimeout)
+	}
+	if transport.ResponseHeaderTimeout != 3*time.Second {
+		t.Errorf("expected ResponseHeaderTimeout 3s, got %v", transport.ResponseHeaderTimeout)
+	}
+	if transport.MaxIdleConns != 7 {
+		t.Errorf("expected MaxIdleConns 7, got %d", transport.MaxIdleConns)
+	}
+
+	defaults := (&TransportConfig{}).newTransport()
+	base := http.DefaultTransport.(*http.Transport)
+	if defaults.TLSHandshakeTimeout != base.TLSHandshakeTimeout || defaults.MaxIdleConns != base.MaxIdleConns {
+		t.Error("expected unset fields to keep the default transport's values")
+	}
+}
+
+func TestTransportConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TransportConfig
+		wantErr bool
+	}{
+		{name: "empty", config: TransportConfig{}},
+		{name: "valid", config: TransportConfig{DialTimeout: caddy.Duration(time.Second), MaxIdleConns: 1}},
+		{name: "negative timeout", config: TransportConfig{ResponseHeaderTimeout: -1}, wantErr: true},
+		{name: "negative idle conns", config: TransportConfig{MaxIdleConns: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_TransportResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = io.WriteString(w, "slow")
+	}))
+	defer backend.Close()
+	defer close(release)
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse backend address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	mockCM := NewMockContainerManager()
+	mockCM.SetStartContainerFunc(func(_ context.Context, _ ContainerConfig) (*Container, error) {
+		return &Container{ID: "slow", IP: host, Port: port}, nil
+	})
+
+	handler, err := newTestHandler(t, []FunctionConfig{
+		{
+			Methods:   []string{"GET"},
+			Path:      "/api/slow",
+			Image:     "test:latest",
+			Port:      port,
+			Transport: &TransportConfig{ResponseHeaderTimeout: caddy.Duration(50 * time.Millisecond)},
+		},
+		{Methods: []string{"GET"}, Path: "/api/shared", Image: "test:latest", Port: port},
+	}, mockCM, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	if handler.clientFor(&handler.Functions[0]) == handler.clientFor(&handler.Functions[1]) {
+		t.Error("expected a dedicated client for the function with a transport")
+	}
+
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) error { return nil })
+	start := time.Now()
+	err = handler.ServeHTTP(httptest.NewRecorder(), fakeRequest("GET", "/api/slow"), next)
+	herr, ok := err.(caddyhttp.HandlerError)
+	if !ok || herr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a 502 after the response header timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the request to fail after the response header timeout, took %v", elapsed)
+	}
+}
+
+func TestHandler_IsolatedTransports(t *testing.T) {
+	functions := []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/a", Image: "test:latest"},
+		{Methods: []string{"GET"}, Path: "/api/b", Image: "test:latest"},
+		{Methods: []string{"GET"}, Path: "/api/c", Image: "test:latest", Transport: &TransportConfig{MaxIdleConns: 3}},
+	}
+	handler, err := newTestHandler(t, functions, nil, nil)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	transports := make(map[http.RoundTripper]bool)
+	for i := range handler.Functions {
+		client := handler.clientFor(&handler.Functions[i])
+		if client == nil || client.Timeout != defaultClientTimeout {
+			t.Fatalf("function %d: expected a client with the default timeout, got %+v", i, client)
+		}
+		if transports[client.Transport] {
+			t.Errorf("function %d: expected its own transport", i)
+		}
+		transports[client.Transport] = true
+	}
+	if transport := handler.clientFor(&handler.Functions[2]).Transport.(*http.Transport); transport.MaxIdleConns != 3 {
+		t.Errorf("expected the function's transport settings, got MaxIdleConns %d", transport.MaxIdleConns)
+	}
+
+	// An injected client serves every function
+	injected := &http.Client{}
+	handler, err = newTestHandler(t, functions, nil, injected)
+	if err != nil {
+		t.Fatalf("newTestHandler failed: %v", err)
+	}
+	for i := range handler.Functions {
+		if handler.clientFor(&handler.Functions[i]) != injected {
+			t.Errorf("function %d: expected the injected client", i)
+		}
+	}
+}
+
+func TestHandler_TransportValidation(t *testing.T) {
+	h := Handler{Functions: []FunctionConfig{
+		{Methods: []string{"GET"}, Path: "/api/bad", Image: "test:latest", Transport: &TransportConfig{DialTimeout: -1}},
+	}}
+	if err := h.Validate(); err == nil {
+		t.Error("expected a negative transport timeout to be rejected")
+	}
+}
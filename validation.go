@@ -0,0 +1,96 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Severities of a ValidationError
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationError is one issue found while validating a configuration.
+// Issues of SeverityWarning do not prevent the configuration from loading.
+type ValidationError struct {
+	// Field locates the offending setting, like functions[1].memory_swap
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors is the list of issues found in a configuration, in the
+// order the settings were checked.
+type ValidationErrors []ValidationError
+
+// HasErrors reports whether any issue is of SeverityError
+func (v ValidationErrors) HasErrors() bool {
+	for _, issue := range v {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns the issues of SeverityWarning
+func (v ValidationErrors) Warnings() ValidationErrors {
+	var warnings ValidationErrors
+	for _, issue := range v {
+		if issue.Severity == SeverityWarning {
+			warnings = append(warnings, issue)
+		}
+	}
+	return warnings
+}
+
+// Err aggregates the issues of SeverityError into a single error, or returns
+// nil if there are none. Warnings are left out.
+func (v ValidationErrors) Err() error {
+	var messages []string
+	for _, issue := range v {
+		if issue.Severity == SeverityError {
+			messages = append(messages, issue.Error())
+		}
+	}
+	switch len(messages) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(messages[0])
+	}
+	return fmt.Errorf("%d configuration errors: %s", len(messages), strings.Join(messages, "; "))
+}
+
+// addError records an issue of SeverityError
+func (v *ValidationErrors) addError(field, format string, args ...any) {
+	*v = append(*v, ValidationError{Field: field, Message: fmt.Sprintf(format, args...), Severity: SeverityError})
+}
+
+// addWarning records an issue of SeverityWarning
+func (v *ValidationErrors) addWarning(field, format string, args ...any) {
+	*v = append(*v, ValidationError{Field: field, Message: fmt.Sprintf(format, args...), Severity: SeverityWarning})
+}
@@ -0,0 +1,342 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestHandler_ValidationReport(t *testing.T) {
+	h := Handler{
+		NoMatchStatus: 42,
+		Functions: []FunctionConfig{
+			{
+				Path:    "^/ok$",
+				Image:   "alpine",
+				Methods: []string{"GET"},
+				Memory:  "128m",
+			},
+			{
+				Path:                 "^/broken$",
+				Image:                "alpine",
+				Methods:              []string{"GET", "FETCH"},
+				MemorySwap:           "1g",
+				Volumes:              []VolumeMount{{Source: "relative", Target: ""}},
+				FallbackResponse:     &FallbackResponse{StatusCode: 42},
+				InheritAllEnv:        true,
+				IPCMode:              "host",
+				PIDMode:              "host",
+				UsernsMode:           "host",
+				PlacementConstraints: []string{"node.role==worker"},
+			},
+		},
+	}
+
+	report := h.ValidationReport()
+	if !report.HasErrors() {
+		t.Fatal("expected errors")
+	}
+
+	var errs, warnings []string
+	for _, issue := range report {
+		switch issue.Severity {
+		case SeverityError:
+			errs = append(errs, issue.Field)
+		case SeverityWarning:
+			warnings = append(warnings, issue.Field)
+		default:
+			t.Errorf("unexpected severity %q for %s", issue.Severity, issue.Field)
+		}
+	}
+	wantErrs := []string{
+		"no_match_status",
+		"functions[1].methods",
+		"functions[1].volumes[0].source",
+		"functions[1].volumes[0].target",
+		"functions[1].fallback_response.status_code",
+		"functions[1].memory_swap",
+	}
+	if strings.Join(errs, ",") != strings.Join(wantErrs, ",") {
+		t.Errorf("errors = %v, want %v", errs, wantErrs)
+	}
+	wantWarnings := []string{"functions[1].inherit_all_env", "functions[1].ipc_mode", "functions[1].pid_mode", "functions[1].userns_mode", "functions[1].placement_constraints"}
+	if strings.Join(warnings, ",") != strings.Join(wantWarnings, ",") {
+		t.Errorf("warnings = %v, want %v", warnings, wantWarnings)
+	}
+
+	err := h.Validate()
+	if err == nil {
+		t.Fatal("expected Validate to fail")
+	}
+	if !strings.HasPrefix(err.Error(), "6 configuration errors: ") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if strings.Contains(err.Error(), "placement") {
+		t.Errorf("warnings should not be part of the error: %v", err)
+	}
+
+	if err := report.Warnings().Err(); err != nil {
+		t.Errorf("warnings alone should not be an error: %v", err)
+	}
+}
+
+// TestHandler_ReportsProvisionChecks tests that the checks provisioning used
+// to stop at are reported together with the rest of the report
+func TestHandler_ReportsProvisionChecks(t *testing.T) {
+	h := &Handler{
+		Functions: []FunctionConfig{
+			{
+				Path:         "^/hooks$",
+				Image:        "alpine",
+				Methods:      []string{"POST", "FETCH"},
+				MaxBodySize:  -1,
+				WebhookDedup: &DedupConfig{Window: caddy.Duration(time.Minute)},
+				WarmupDelay:  caddy.Duration(-time.Second),
+			},
+			{Path: "^/noimage$", Methods: []string{"GET"}, MemorySwap: "1g"},
+		},
+		containerManager: NewMockContainerManager(),
+		logger:           zap.NewNop(),
+	}
+	if err := h.provision(); err != nil {
+		t.Fatalf("expected provisioning to leave validation to the report, got %v", err)
+	}
+	defer h.Cleanup()
+
+	var errs []string
+	for _, issue := range h.ValidationReport() {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.Field)
+		}
+	}
+	wantErrs := []string{
+		"functions[0].methods",
+		"functions[0].max_body_size",
+		"functions[0].webhook_dedup.header",
+		"functions[0].warmup_delay",
+		"functions[1].image",
+		"functions[1].memory_swap",
+	}
+	if strings.Join(errs, ",") != strings.Join(wantErrs, ",") {
+		t.Errorf("errors = %v, want %v", errs, wantErrs)
+	}
+}
+
+func TestContainerConfigReport(t *testing.T) {
+	report := containerConfigReport(ContainerConfig{
+		Image:   "",
+		Command: []string{"run", " "},
+		Volumes: []VolumeMount{{Source: "/data"}},
+	})
+	if len(report) != 3 {
+		t.Fatalf("expected 3 issues, got %v", report)
+	}
+	for i, field := range []string{"image", "command[1]", "volumes[0].target"} {
+		if report[i].Field != field {
+			t.Errorf("issue %d: field = %q, want %q", i, report[i].Field, field)
+		}
+	}
+}
+
+func TestHandler_SwarmCgroupParent(t *testing.T) {
+	h := Handler{Functions: []FunctionConfig{
+		{Path: "^/payments$", Image: "alpine", CgroupParent: "payments.slice"},
+	}}
+	if warnings := h.ValidationReport().Warnings(); len(warnings) != 0 {
+		t.Errorf("expected no warnings without use_swarm, got %v", warnings)
+	}
+
+	h.UseSwarm = true
+	report := h.ValidationReport()
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "functions[0].cgroup_parent: not supported for swarm services") {
+		t.Errorf("expected a cgroup_parent error with use_swarm, got %v", err)
+	}
+	if warnings := report.Warnings(); len(warnings) != 0 {
+		t.Errorf("expected no warnings with use_swarm, got %v", warnings)
+	}
+}
+
+func TestHandler_SwarmUnsupportedOptions(t *testing.T) {
+	h := Handler{
+		UseSwarm:        true,
+		AllowPrivileged: true,
+		Functions: []FunctionConfig{
+			{
+				Path:             "^/jobs$",
+				Image:            "alpine",
+				Methods:          []string{"POST"},
+				Memory:           "256m",
+				MemorySwap:       "512m",
+				OOMKillDisable:   true,
+				OOMScoreAdj:      -500,
+				CgroupParent:     "jobs.slice",
+				IPCMode:          "shareable",
+				PIDMode:          "host",
+				UsernsMode:       "host",
+				Privileged:       true,
+				SeccompProfile:   seccompUnconfined,
+				GroupAdd:         []string{"video"},
+				LogDriver:        "journald",
+				PostStartCommand: []string{"seed"},
+			},
+		},
+	}
+
+	var errs []string
+	postStartWarned := false
+	for _, issue := range h.ValidationReport() {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.Field)
+		} else if issue.Field == "functions[0].post_start_command" {
+			postStartWarned = true
+		}
+	}
+	if !postStartWarned {
+		t.Error("expected a post_start_command warning with use_swarm")
+	}
+	wantErrs := []string{
+		"functions[0].memory_swap",
+		"functions[0].oom_kill_disable",
+		"functions[0].oom_score_adj",
+		"functions[0].cgroup_parent",
+		"functions[0].ipc_mode",
+		"functions[0].pid_mode",
+		"functions[0].userns_mode",
+		"functions[0].privileged",
+		"functions[0].seccomp_profile",
+	}
+	if strings.Join(errs, ",") != strings.Join(wantErrs, ",") {
+		t.Errorf("errors = %v, want %v", errs, wantErrs)
+	}
+
+	h.UseSwarm = false
+	if err := h.Validate(); err != nil {
+		t.Errorf("expected the options to be accepted without use_swarm, got %v", err)
+	}
+}
+
+func TestHandler_ComposeUnsupportedOptions(t *testing.T) {
+	h := Handler{
+		UseSwarm:          true,
+		GlobalEnvironment: map[string]string{"REGION": "eu"},
+		Functions: []FunctionConfig{
+			{
+				Path:           "^/app$",
+				Methods:        []string{"GET"},
+				ComposeFile:    "/srv/app/docker-compose.yml",
+				ComposeService: "web",
+				Environment:    map[string]string{"MODE": "prod"},
+				Volumes:        []VolumeMount{{Source: "/srv/data", Target: "/data"}},
+				Memory:         "256m",
+				PIDMode:        "host",
+			},
+		},
+	}
+
+	var errs, warnings []string
+	for _, issue := range h.ValidationReport() {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.Field)
+		} else if issue.Field == "functions[0].compose_file" {
+			warnings = append(warnings, issue.Field)
+		}
+	}
+	// Reported once as compose options, not again as swarm options
+	wantErrs := []string{
+		"functions[0].environment",
+		"functions[0].volumes",
+		"functions[0].memory",
+		"functions[0].pid_mode",
+	}
+	if strings.Join(errs, ",") != strings.Join(wantErrs, ",") {
+		t.Errorf("errors = %v, want %v", errs, wantErrs)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected a warning that global_environment is not passed on, got %v", warnings)
+	}
+}
+
+func TestHandler_DuplicateFunctions(t *testing.T) {
+	functions := []FunctionConfig{
+		{Path: "^/api/users$", Image: "users:v1", Methods: []string{"GET", "POST"}},
+		{Path: "^/api/users$", Image: "users:v2", Methods: []string{"get"}},
+		// Different content types or paths are told apart by the route tables
+		{Path: "^/api/users$", Image: "uploads", Methods: []string{"POST"}, MatchContentType: []string{"multipart/*"}},
+		{Path: "^/api/users/$", Image: "users:v1", Methods: []string{"GET"}},
+		{Path: "^/api/users$", Image: "uploads:v2", Methods: []string{"POST"}, MatchContentType: []string{"Multipart/*"}},
+	}
+
+	tests := []struct {
+		mode     string
+		severity string
+	}{
+		{"", SeverityWarning},
+		{"warn", SeverityWarning},
+		{"error", SeverityError},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			h := Handler{DuplicateFunctions: tt.mode, Functions: functions}
+			var issues []string
+			for _, issue := range h.ValidationReport() {
+				if issue.Severity != tt.severity {
+					t.Errorf("expected severity %s, got %s for %s", tt.severity, issue.Severity, issue.Field)
+				}
+				issues = append(issues, issue.Field)
+			}
+			want := []string{"functions[1].path", "functions[4].path"}
+			if strings.Join(issues, ",") != strings.Join(want, ",") {
+				t.Errorf("issues = %v, want %v", issues, want)
+			}
+			if err := h.Validate(); (err != nil) != (tt.severity == SeverityError) {
+				t.Errorf("unexpected Validate result: %v", err)
+			}
+		})
+	}
+
+	h := Handler{DuplicateFunctions: "ignore"}
+	if err := h.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate_functions") {
+		t.Errorf("expected an invalid duplicate_functions to be rejected, got %v", err)
+	}
+}
+
+func TestHandler_Privileged(t *testing.T) {
+	functions := []FunctionConfig{
+		{Path: "/api/gpu", Image: "gpu:latest", Methods: []string{"GET"}, Privileged: true, GroupAdd: []string{"video", "44"}},
+	}
+
+	h := Handler{Functions: functions}
+	report := h.ValidationReport()
+	if len(report) != 1 || report[0].Field != "functions[0].privileged" || report[0].Severity != SeverityError {
+		t.Fatalf("expected privileged to be rejected without allow_privileged, got %v", report)
+	}
+
+	h.AllowPrivileged = true
+	report = h.ValidationReport()
+	if len(report) != 1 || report[0].Field != "functions[0].privileged" || report[0].Severity != SeverityWarning {
+		t.Fatalf("expected a warning for an allowed privileged function, got %v", report)
+	}
+
+	h.Functions = []FunctionConfig{{Path: "/api/gpu", Image: "gpu:latest", Methods: []string{"GET"}, GroupAdd: []string{"video", "bad group"}}}
+	report = h.ValidationReport()
+	if len(report) != 1 || report[0].Field != "functions[0].group_add[1]" {
+		t.Errorf("expected the invalid group to be rejected, got %v", report)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serverless
+
+import "math/rand/v2"
+
+// FunctionVersion is one image of a function serving a share of its
+// requests, as in a canary deployment.
+type FunctionVersion struct {
+	// Image is the Docker image of this version.
+	Image string `json:"image,omitempty"`
+
+	// Weight is this version's share of requests relative to the weights
+	// of the others; 90 and 10 send one request in ten to the second.
+	Weight int `json:"weight,omitempty"`
+}
+
+// images returns every image the function may run
+func (fn *FunctionConfig) images() []string {
+	var images []string
+	if fn.Image != "" {
+		images = append(images, fn.Image)
+	}
+	for _, version := range fn.Versions {
+		if version.Image != "" {
+			images = append(images, version.Image)
+		}
+	}
+	return images
+}
+
+// pickVersion returns the function to execute for one request: fn itself,
+// or with versions a copy of fn whose Image is picked at random according
+// to their weights.
+func (fn *FunctionConfig) pickVersion() *FunctionConfig {
+	if len(fn.Versions) == 0 {
+		return fn
+	}
+	total := 0
+	for _, version := range fn.Versions {
+		total += version.Weight
+	}
+	if total <= 0 {
+		return fn
+	}
+	n := rand.IntN(total)
+	versioned := *fn
+	for _, version := range fn.Versions {
+		if n < version.Weight {
+			versioned.Image = version.Image
+			break
+		}
+		n -= version.Weight
+	}
+	return &versioned
+}